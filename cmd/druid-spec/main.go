@@ -0,0 +1,56 @@
+// Command druid-spec prints the kafka supervisor specs of the druid datasources, to be submitted to the
+// overlord /druid/indexer/v1/supervisor endpoint, and the task rewriting the stored trace IDs in the
+// canonical form, to be submitted to the /druid/indexer/v1/task endpoint.
+//
+//	druid-spec --druid.brokers=kafka:9092 ingestion | curl -XPOST -H 'Content-Type: application/json' -d @- http://overlord:8090/druid/indexer/v1/supervisor
+//	druid-spec --druid.brokers=kafka:9092 rollup
+//	druid-spec reindex-trace-ids | curl -XPOST -H 'Content-Type: application/json' -d @- http://overlord:8090/druid/indexer/v1/task
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rubenvp8510/jaeger-storages/druid"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger, _ := zap.NewProduction()
+	if err := run(); err != nil {
+		logger.Fatal("Printing the spec failed", zap.Error(err))
+	}
+}
+
+func run() error {
+	options := druid.Options{}
+	flagSet := flag.NewFlagSet("druid-spec", flag.ExitOnError)
+	options.AddFlags(flagSet)
+
+	pflag.CommandLine.AddGoFlagSet(flagSet)
+	pflag.Parse()
+	v := viper.New()
+	if err := v.BindPFlags(pflag.CommandLine); err != nil {
+		return err
+	}
+	options.InitFromViper(v)
+
+	var spec map[string]interface{}
+	switch command := pflag.Arg(0); command {
+	case "ingestion":
+		spec = druid.IngestionSpec(options)
+	case "rollup":
+		spec = druid.RollupIngestionSpec(options)
+	case "reindex-trace-ids":
+		spec = druid.TraceIDReindexSpec()
+	default:
+		return fmt.Errorf("unknown spec %q, expected ingestion, rollup or reindex-trace-ids", command)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(spec)
+}
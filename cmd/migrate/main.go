@@ -0,0 +1,171 @@
+// Command migrate copies traces between the storages of this repository, or through an intermediate
+// file for offline transfers.
+//
+//	migrate --from=questdb --to=file --file=traces.ndjson --start=2020-07-01T00:00:00Z --end=2020-07-02T00:00:00Z
+//	migrate --from=file --to=druid --file=traces.ndjson --rate=500 --checkpoint=import.checkpoint
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/rubenvp8510/jaeger-storages/druid"
+	"github.com/rubenvp8510/jaeger-storages/fanout"
+	"github.com/rubenvp8510/jaeger-storages/migrate"
+	"github.com/rubenvp8510/jaeger-storages/questbd"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+const fileBackend = "file"
+
+func main() {
+	logger, _ := zap.NewProduction()
+	if err := run(logger); err != nil {
+		logger.Fatal("Migration failed", zap.Error(err))
+	}
+}
+
+func run(logger *zap.Logger) error {
+	factories := map[string]fanout.StorageFactory{
+		"druid":   druid.NewFactory(),
+		"questdb": questbd.NewFactory(),
+	}
+
+	flagSet := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flagSet.String("from", "", "The source of the traces: 'druid', 'questdb' or 'file'")
+	to := flagSet.String("to", "", "The destination of the traces: 'druid', 'questdb' or 'file'")
+	file := flagSet.String("file", "", "The intermediate file, read when importing and written when exporting")
+	format := flagSet.String("format", migrate.FormatJSON, "The intermediate file format: 'ndjson' or 'protobuf'")
+	start := flagSet.String("start", "", "The start of the exported time range, RFC3339")
+	end := flagSet.String("end", time.Now().UTC().Format(time.RFC3339), "The end of the exported time range, RFC3339")
+	window := flagSet.Duration("window", time.Hour, "The time range fetched from the source at once")
+	maxTraces := flagSet.Int("max-traces", 10000, "The maximum number of traces fetched per service and window")
+	rate := flagSet.Float64("rate", 0, "The maximum number of spans written per second, 0 is unlimited")
+	checkpoint := flagSet.String("checkpoint", "", "The file the progress is saved to, a migration resumes from it")
+	for _, factory := range factories {
+		factory.AddFlags(flagSet)
+	}
+
+	pflag.CommandLine.AddGoFlagSet(flagSet)
+	pflag.Parse()
+	v := viper.New()
+	if err := v.BindPFlags(pflag.CommandLine); err != nil {
+		return err
+	}
+
+	if *from == *to {
+		return fmt.Errorf("source and destination must be different")
+	}
+	options := migrate.Options{
+		Window:     *window,
+		MaxTraces:  *maxTraces,
+		Rate:       *rate,
+		Checkpoint: *checkpoint,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	initialize := func(name string) (fanout.StorageFactory, error) {
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown backend %q", name)
+		}
+		factory.InitFromViper(v)
+		if err := factory.Initialize(metrics.NullFactory, logger); err != nil {
+			return nil, err
+		}
+		return factory, nil
+	}
+
+	if *to == fileBackend {
+		var err error
+		if options.StartTime, options.EndTime, err = parseTimeRange(*start, *end); err != nil {
+			return err
+		}
+		source, err := initialize(*from)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		reader, err := source.CreateSpanReader()
+		if err != nil {
+			return err
+		}
+		output, err := os.OpenFile(*file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+		writer, err := migrate.NewFileWriter(output, *format)
+		if err != nil {
+			return err
+		}
+		if err := migrate.NewMigrator(writer, options, logger).Export(ctx, reader); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+
+	destination, err := initialize(*to)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+	writer, err := destination.CreateSpanWriter()
+	if err != nil {
+		return err
+	}
+
+	if *from == fileBackend {
+		input, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+		reader, err := migrate.NewFileReader(input, *format)
+		if err != nil {
+			return err
+		}
+		return migrate.NewMigrator(writer, options, logger).Import(ctx, reader)
+	}
+
+	if options.StartTime, options.EndTime, err = parseTimeRange(*start, *end); err != nil {
+		return err
+	}
+	source, err := initialize(*from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	reader, err := source.CreateSpanReader()
+	if err != nil {
+		return err
+	}
+	return migrate.NewMigrator(writer, options, logger).Export(ctx, reader)
+}
+
+func parseTimeRange(start, end string) (time.Time, time.Time, error) {
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return startTime, startTime, fmt.Errorf("invalid start time: %w", err)
+	}
+	endTime, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return startTime, endTime, fmt.Errorf("invalid end time: %w", err)
+	}
+	return startTime, endTime, nil
+}
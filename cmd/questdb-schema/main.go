@@ -0,0 +1,74 @@
+// Command questdb-schema reports and applies the schema migrations of the QuestDB trace store, for
+// deployments started with --questdb.migrations=check or disabled.
+//
+//	questdb-schema --questdb.host=http://localhost:9000 status
+//	questdb-schema --questdb.host=http://localhost:9000 apply
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rubenvp8510/jaeger-storages/questbd"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger, _ := zap.NewProduction()
+	if err := run(logger); err != nil {
+		logger.Fatal("Schema migration failed", zap.Error(err))
+	}
+}
+
+func run(logger *zap.Logger) error {
+	options := questbd.Options{}
+	flagSet := flag.NewFlagSet("questdb-schema", flag.ExitOnError)
+	options.AddFlags(flagSet)
+
+	pflag.CommandLine.AddGoFlagSet(flagSet)
+	pflag.Parse()
+	v := viper.New()
+	if err := v.BindPFlags(pflag.CommandLine); err != nil {
+		return err
+	}
+	options.InitFromViper(v)
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	command := pflag.Arg(0)
+	if command != "status" && command != "apply" {
+		return fmt.Errorf("unknown command %q, expected status or apply", command)
+	}
+
+	client, err := questbd.NewQuestDBRest(options)
+	if err != nil {
+		return err
+	}
+	migrator := questbd.NewMigrator(client, options)
+
+	if command == "apply" {
+		applied, err := migrator.Migrate()
+		for _, migration := range applied {
+			logger.Info("Applied schema migration", zap.Int("version", migration.Version), zap.String("description", migration.Description))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Pending()
+	if err != nil {
+		return err
+	}
+	logger.Info("Schema status", zap.Int("version", version), zap.Int("latest", migrator.Latest()), zap.Int("pending", len(pending)))
+	for _, migration := range pending {
+		logger.Info("Pending schema migration", zap.Int("version", migration.Version), zap.String("description", migration.Description))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Command replay backfills QuestDB from the spans the druid storage published to kafka.
+//
+//	replay --brokers=localhost:9092 --topic=jaeger-spans --since=2020-07-01T00:00:00Z --questdb.host=http://localhost:9000
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rubenvp8510/jaeger-storages/questbd"
+	"github.com/rubenvp8510/jaeger-storages/replay"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger, _ := zap.NewProduction()
+	if err := run(logger); err != nil {
+		logger.Fatal("Replay failed", zap.Error(err))
+	}
+}
+
+func run(logger *zap.Logger) error {
+	factory := questbd.NewFactory()
+
+	flagSet := flag.NewFlagSet("replay", flag.ExitOnError)
+	brokers := flagSet.String("brokers", "127.0.0.1:9092", "The comma-separated list of kafka brokers")
+	topic := flagSet.String("topic", "jaeger-spans", "The topic the druid storage publishes spans to")
+	offset := flagSet.Int64("offset", sarama.OffsetOldest, "The first offset replayed from every partition, -2 is the oldest one")
+	since := flagSet.String("since", "", "Replay the spans published after this time, RFC3339, instead of starting from the offset")
+	factory.AddFlags(flagSet)
+
+	pflag.CommandLine.AddGoFlagSet(flagSet)
+	pflag.Parse()
+	v := viper.New()
+	if err := v.BindPFlags(pflag.CommandLine); err != nil {
+		return err
+	}
+
+	options := replay.Options{
+		Topic:  *topic,
+		Offset: *offset,
+	}
+	if *since != "" {
+		var err error
+		if options.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			return fmt.Errorf("invalid since time: %w", err)
+		}
+	}
+
+	factory.InitFromViper(v)
+	if err := factory.Initialize(metrics.NullFactory, logger); err != nil {
+		return err
+	}
+	defer factory.Close()
+	spanWriter, err := factory.CreateSpanWriter()
+	if err != nil {
+		return err
+	}
+
+	client, err := sarama.NewClient(strings.Split(*brokers, ","), sarama.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	replayer := replay.NewReplayer(consumer, client, spanWriter.(*questbd.Writer), options, metrics.NullFactory, logger)
+	return replayer.Run(ctx)
+}
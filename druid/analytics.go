@@ -0,0 +1,149 @@
+package druid
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rubenvp8510/godruid"
+)
+
+var (
+	// ErrInvalidStep is returned by Analytics if the query step is lower than the rollup granularity.
+	ErrInvalidStep = errors.New("step must be at least one minute")
+)
+
+var defaultQuantiles = []float64{0.5, 0.95, 0.99}
+
+// Analytics returns aggregated request, error and latency metrics per service and operation.
+type Analytics interface {
+	GetOperationMetrics(ctx context.Context, query *AnalyticsQuery) ([]*OperationMetrics, error)
+}
+
+// AnalyticsQuery contains the parameters of an analytics query, empty service or operation
+// names means all of them.
+type AnalyticsQuery struct {
+	ServiceName   string
+	OperationName string
+	StartTime     time.Time
+	EndTime       time.Time
+	Step          time.Duration
+	Quantiles     []float64
+}
+
+// OperationMetrics contains the metrics of an operation within a time bucket.
+type OperationMetrics struct {
+	Timestamp     time.Time
+	ServiceName   string
+	OperationName string
+	Requests      int64
+	Errors        int64
+	// Latencies maps each requested quantile to its latency
+	Latencies map[float64]time.Duration
+}
+
+func buildAnalyticsFilter(query *AnalyticsQuery) *godruid.Filter {
+	filters := make([]*godruid.Filter, 0)
+	if query.ServiceName != "" {
+		filters = append(filters, godruid.FilterSelector("process.serviceName", query.ServiceName))
+	}
+	if query.OperationName != "" {
+		filters = append(filters, godruid.FilterSelector("operationName", query.OperationName))
+	}
+	return godruid.FilterAnd(filters...)
+}
+
+func (r *Reader) analyticsQueryBuilder(query *AnalyticsQuery, quantiles []float64) (*godruid.QueryGroupBy, map[string]interface{}) {
+	druidQuery := &godruid.QueryGroupBy{
+		DataSource: rollupDataSource,
+		Intervals:  []string{formatInterval(query.StartTime, query.EndTime)},
+		Filter:     buildAnalyticsFilter(query),
+		Dimensions: []godruid.DimSpec{
+			godruid.DimDefault("process.serviceName", "serviceName"),
+			godruid.DimDefault("operationName", "operationName"),
+		},
+		Aggregations: []godruid.Aggregation{},
+		Granularity: godruid.GranPeriod{
+			Type:     "period",
+			Period:   isoPeriod(query.Step),
+			TimeZone: "UTC",
+		},
+	}
+	// Sketches are provided by the druid-datasketches extension, godruid doesn't support them.
+	return druidQuery, map[string]interface{}{
+		"aggregations": []interface{}{
+			godruid.AggLongSum("requests", "count"),
+			godruid.AggLongSum("errors", "errors"),
+			map[string]interface{}{
+				"type":      "quantilesDoublesSketch",
+				"name":      "latency",
+				"fieldName": "durationSketch",
+				"k":         sketchSize,
+			},
+		},
+		"postAggregations": []interface{}{
+			map[string]interface{}{
+				"type": "quantilesDoublesSketchToQuantiles",
+				"name": "quantiles",
+				"field": map[string]interface{}{
+					"type":      "fieldAccess",
+					"fieldName": "latency",
+				},
+				"fractions": quantiles,
+			},
+		},
+	}
+}
+
+// GetOperationMetrics returns the metrics of every service and operation matching the query, one
+// entry per time bucket of the query step.
+func (r *Reader) GetOperationMetrics(ctx context.Context, query *AnalyticsQuery) ([]*OperationMetrics, error) {
+	if query.Step < time.Minute {
+		return nil, ErrInvalidStep
+	}
+	quantiles := query.Quantiles
+	if len(quantiles) == 0 {
+		quantiles = defaultQuantiles
+	}
+
+	tenantFilter, err := r.tenantFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	druidQuery, overrides := r.analyticsQueryBuilder(query, quantiles)
+	druidQuery.Filter = godruid.FilterAnd(druidQuery.Filter, tenantFilter)
+	if err := r.execute(druidQuery, overrides); err != nil {
+		return nil, err
+	}
+
+	metrics := make([]*OperationMetrics, 0, len(druidQuery.QueryResult))
+	for _, res := range druidQuery.QueryResult {
+		timestamp, err := time.Parse(time.RFC3339, res.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		serviceName, _ := res.Event["serviceName"].(string)
+		operationName, _ := res.Event["operationName"].(string)
+		requests, _ := res.Event["requests"].(float64)
+		errorCount, _ := res.Event["errors"].(float64)
+		operationMetrics := &OperationMetrics{
+			Timestamp:     timestamp,
+			ServiceName:   serviceName,
+			OperationName: operationName,
+			Requests:      int64(requests),
+			Errors:        int64(errorCount),
+			Latencies:     make(map[float64]time.Duration, len(quantiles)),
+		}
+		values, _ := res.Event["quantiles"].([]interface{})
+		for i, value := range values {
+			latency, ok := value.(float64)
+			if !ok || i >= len(quantiles) {
+				continue
+			}
+			operationMetrics.Latencies[quantiles[i]] = time.Duration(latency) * time.Microsecond
+		}
+		metrics = append(metrics, operationMetrics)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,108 @@
+package druid
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rubenvp8510/godruid"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+)
+
+func TestGetOperationMetrics(t *testing.T) {
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		return groupByRows(map[string]interface{}{
+			"serviceName":   "frontend",
+			"operationName": "get",
+			"requests":      120,
+			"errors":        3,
+			"quantiles":     []interface{}{1500.0, 20000.0},
+		})
+	})
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{Options: spans.Options{Tenancy: true, DefaultTenant: "acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)
+	metrics, err := reader.GetOperationMetrics(context.Background(), &AnalyticsQuery{
+		ServiceName: "frontend",
+		StartTime:   end.Add(-time.Hour),
+		EndTime:     end,
+		Step:        5 * time.Minute,
+		Quantiles:   []float64{0.5, 0.99},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*OperationMetrics{{
+		Timestamp:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ServiceName:   "frontend",
+		OperationName: "get",
+		Requests:      120,
+		Errors:        3,
+		Latencies:     map[float64]time.Duration{0.5: 1500 * time.Microsecond, 0.99: 20 * time.Millisecond},
+	}}
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("metrics are %+v, expected %+v", metrics[0], expected[0])
+	}
+
+	query := broker.recorded()[0]
+	if query["queryType"] != "groupBy" || query["dataSource"] != rollupDataSource {
+		t.Errorf("query %v isn't a groupBy of the rollup datasource", query)
+	}
+	if granularity := query["granularity"].(map[string]interface{}); granularity["period"] != "PT300S" {
+		t.Errorf("granularity is %v, expected the step", granularity)
+	}
+	filter := asJSON(t, godruid.FilterAnd(
+		godruid.FilterSelector("process.serviceName", "frontend"),
+		godruid.FilterSelector(tenantDimension, "acme"),
+	))
+	if !reflect.DeepEqual(query["filter"], filter) {
+		t.Errorf("filter is %v, expected %v", query["filter"], filter)
+	}
+	postAggregation := query["postAggregations"].([]interface{})[0].(map[string]interface{})
+	if !reflect.DeepEqual(postAggregation["fractions"], []interface{}{0.5, 0.99}) {
+		t.Errorf("quantiles are %v, expected the requested ones", postAggregation["fractions"])
+	}
+}
+
+func TestGetOperationMetricsDefaultQuantiles(t *testing.T) {
+	broker := newFakeBroker(t, nil)
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	query := &AnalyticsQuery{StartTime: now.Add(-time.Hour), EndTime: now, Step: time.Minute}
+	if _, err := reader.GetOperationMetrics(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	postAggregation := broker.recorded()[0]["postAggregations"].([]interface{})[0].(map[string]interface{})
+	if !reflect.DeepEqual(postAggregation["fractions"], []interface{}{0.5, 0.95, 0.99}) {
+		t.Errorf("quantiles are %v, expected the default ones", postAggregation["fractions"])
+	}
+	if _, ok := broker.recorded()[0]["filter"]; ok {
+		t.Errorf("query of every service is filtered by %v", broker.recorded()[0]["filter"])
+	}
+}
+
+func TestGetOperationMetricsInvalidStep(t *testing.T) {
+	broker := newFakeBroker(t, nil)
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	query := &AnalyticsQuery{StartTime: now.Add(-time.Hour), EndTime: now, Step: 30 * time.Second}
+	if _, err := reader.GetOperationMetrics(context.Background(), query); err != ErrInvalidStep {
+		t.Errorf("step of 30s returned %v, expected %v", err, ErrInvalidStep)
+	}
+	if queries := broker.recorded(); len(queries) != 0 {
+		t.Errorf("queries %v sent for an invalid step", queries)
+	}
+}
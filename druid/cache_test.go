@@ -0,0 +1,148 @@
+package druid
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/pkg/cache"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// fakeClock is the clock of the cache of a reader
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// useClock makes the cache of the reader expire its entries by the clock
+func useClock(t *testing.T, readerCache cache.Cache, clock *fakeClock) {
+	lru, ok := readerCache.(*cache.LRU)
+	if !ok {
+		t.Fatalf("cache is a %T, expected an LRU", readerCache)
+	}
+	lru.TimeNow = clock.Now
+}
+
+// parseInterval returns the bounds of an ISO interval of a native query
+func parseInterval(t *testing.T, interval string) (time.Time, time.Time) {
+	bounds := strings.Split(interval, "/")
+	if len(bounds) != 2 {
+		t.Fatalf("invalid interval %q", interval)
+	}
+	start, err := time.Parse(time.RFC3339Nano, bounds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, bounds[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return start, end
+}
+
+func TestGetServicesLookback(t *testing.T) {
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		return groupByRows(map[string]interface{}{"process.serviceName": "frontend"})
+	})
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{Lookback: 2 * time.Hour, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if _, err := reader.GetServices(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+	intervals := broker.recorded()[0]["intervals"].([]interface{})
+	if len(intervals) != 1 {
+		t.Fatalf("intervals are %v, expected the lookback window", intervals)
+	}
+	start, end := parseInterval(t, intervals[0].(string))
+	// the interval is formatted with milliseconds
+	if end.Before(before.Truncate(time.Millisecond)) || end.After(after) || end.Sub(start) != 2*time.Hour {
+		t.Errorf("interval is %s to %s, expected the 2h before the query", start, end)
+	}
+}
+
+func TestGetOperationsCacheTTL(t *testing.T) {
+	operations := []string{"get"}
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		var rows []map[string]interface{}
+		for _, operation := range operations {
+			rows = append(rows, map[string]interface{}{"operationName": operation})
+		}
+		return groupByRows(rows...)
+	})
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{Lookback: time.Hour, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Now()}
+	useClock(t, reader.cache, clock)
+	query := spanstore.OperationQueryParameters{ServiceName: "frontend"}
+
+	read := func() int {
+		found, err := reader.GetOperations(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(found)
+	}
+	if read() != 1 {
+		t.Fatal("operations not found")
+	}
+	operations = append(operations, "post")
+	clock.now = clock.now.Add(59 * time.Second)
+	if found := read(); found != 1 || len(broker.recorded()) != 1 {
+		t.Errorf("%d operations after %d queries, expected the cached ones", found, len(broker.recorded()))
+	}
+	clock.now = clock.now.Add(2 * time.Second)
+	if found := read(); found != 2 || len(broker.recorded()) != 2 {
+		t.Errorf("%d operations after %d queries, expected them refreshed once the TTL expired", found, len(broker.recorded()))
+	}
+}
+
+func TestSQLGetServicesLookbackAndCacheTTL(t *testing.T) {
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		return []map[string]interface{}{{"process.serviceName": "frontend"}}
+	})
+	defer broker.Close()
+	reader, err := NewSQLReader(broker.URL, Options{Lookback: 2 * time.Hour, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Now()}
+	useClock(t, reader.cache, clock)
+
+	for i := 0; i < 2; i++ {
+		if _, err := reader.GetServices(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queries := broker.recorded()
+	if len(queries) != 1 {
+		t.Fatalf("%d queries sent, expected the services to be cached", len(queries))
+	}
+	parameters := queries[0]["parameters"].([]interface{})
+	start := parameters[0].(map[string]interface{})["value"].(float64)
+	end := parameters[1].(map[string]interface{})["value"].(float64)
+	if window := time.Duration(end-start) * time.Millisecond; window != 2*time.Hour {
+		t.Errorf("services searched over %v, expected the 2h lookback", window)
+	}
+
+	clock.now = clock.now.Add(time.Minute + time.Second)
+	if _, err := reader.GetServices(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(broker.recorded()) != 2 {
+		t.Errorf("%d queries sent, expected the services refreshed once the TTL expired", len(broker.recorded()))
+	}
+}
@@ -0,0 +1,80 @@
+package druid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	rulesEndpoint      = "/druid/coordinator/v1/rules/"
+	compactionEndpoint = "/druid/coordinator/v1/config/compaction"
+	defaultTier        = "_default_tier"
+)
+
+// Coordinator manages datasource load, drop and compaction settings through the druid coordinator API.
+type Coordinator struct {
+	url    string
+	client *http.Client
+}
+
+func NewCoordinator(url string) *Coordinator {
+	return &Coordinator{
+		url: url,
+		client: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}
+}
+
+// isoPeriod formats a duration as an ISO 8601 period, as druid expects in rules and granularities.
+func isoPeriod(d time.Duration) string {
+	return fmt.Sprintf("PT%dS", int64(d.Seconds()))
+}
+
+func (c *Coordinator) post(path string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.url+path, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, string(message))
+	}
+	return nil
+}
+
+// SetRetention keeps the segments of the last retention period of dataSource loaded and drops the rest.
+func (c *Coordinator) SetRetention(dataSource string, retention time.Duration, replicants int) error {
+	rules := []map[string]interface{}{
+		{
+			"type":             "loadByPeriod",
+			"period":           isoPeriod(retention),
+			"includeFuture":    true,
+			"tieredReplicants": map[string]int{defaultTier: replicants},
+		},
+		{
+			"type": "dropForever",
+		},
+	}
+	return c.post(rulesEndpoint+dataSource, rules)
+}
+
+// SetCompaction enables automatic compaction of dataSource, merging the small segments produced by
+// the kafka ingestion. Segments newer than skipOffset are left alone as they may still be written.
+func (c *Coordinator) SetCompaction(dataSource string, skipOffset time.Duration, maxRowsPerSegment int) error {
+	config := map[string]interface{}{
+		"dataSource":           dataSource,
+		"skipOffsetFromLatest": isoPeriod(skipOffset),
+		"maxRowsPerSegment":    maxRowsPerSegment,
+	}
+	return c.post(compactionEndpoint, config)
+}
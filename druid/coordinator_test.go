@@ -0,0 +1,107 @@
+package druid
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// coordinatorRequest is a request received by the fake coordinator
+type coordinatorRequest struct {
+	method      string
+	path        string
+	contentType string
+	payload     interface{}
+}
+
+func newFakeCoordinator(t *testing.T, status int, requests *[]coordinatorRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading the payload: %v", err)
+			return
+		}
+		request := coordinatorRequest{method: r.Method, path: r.URL.Path, contentType: r.Header.Get("Content-Type")}
+		if err := json.Unmarshal(body, &request.payload); err != nil {
+			t.Errorf("payload %s is not JSON: %v", body, err)
+		}
+		*requests = append(*requests, request)
+		w.WriteHeader(status)
+		w.Write([]byte("coordinator message"))
+	}))
+}
+
+func TestSetRetention(t *testing.T) {
+	var requests []coordinatorRequest
+	server := newFakeCoordinator(t, http.StatusOK, &requests)
+	defer server.Close()
+
+	if err := NewCoordinator(server.URL).SetRetention(spansDataSource, 7*24*time.Hour, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, expected 1", len(requests))
+	}
+	request := requests[0]
+	if request.method != http.MethodPost || request.path != "/druid/coordinator/v1/rules/jaeger-spans" {
+		t.Errorf("rules sent with %s %s", request.method, request.path)
+	}
+	if request.contentType != "application/json" {
+		t.Errorf("rules sent as %s", request.contentType)
+	}
+	expected := []interface{}{
+		map[string]interface{}{
+			"type":             "loadByPeriod",
+			"period":           "PT604800S",
+			"includeFuture":    true,
+			"tieredReplicants": map[string]interface{}{"_default_tier": float64(2)},
+		},
+		map[string]interface{}{"type": "dropForever"},
+	}
+	if !reflect.DeepEqual(request.payload, expected) {
+		t.Errorf("rules are %v, expected %v", request.payload, expected)
+	}
+}
+
+func TestSetCompaction(t *testing.T) {
+	var requests []coordinatorRequest
+	server := newFakeCoordinator(t, http.StatusOK, &requests)
+	defer server.Close()
+
+	if err := NewCoordinator(server.URL).SetCompaction(spansDataSource, 2*time.Hour, 5000000); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, expected 1", len(requests))
+	}
+	request := requests[0]
+	if request.method != http.MethodPost || request.path != "/druid/coordinator/v1/config/compaction" {
+		t.Errorf("compaction sent with %s %s", request.method, request.path)
+	}
+	expected := map[string]interface{}{
+		"dataSource":           "jaeger-spans",
+		"skipOffsetFromLatest": "PT7200S",
+		"maxRowsPerSegment":    float64(5000000),
+	}
+	if !reflect.DeepEqual(request.payload, expected) {
+		t.Errorf("compaction is %v, expected %v", request.payload, expected)
+	}
+}
+
+func TestCoordinatorError(t *testing.T) {
+	var requests []coordinatorRequest
+	server := newFakeCoordinator(t, http.StatusBadRequest, &requests)
+	defer server.Close()
+
+	err := NewCoordinator(server.URL).SetRetention(spansDataSource, time.Hour, 1)
+	if err == nil {
+		t.Fatal("retention set despite the coordinator error")
+	}
+	if expected := "400 Bad Request: coordinator message"; err.Error() != expected {
+		t.Errorf("error is %q, expected %q", err, expected)
+	}
+}
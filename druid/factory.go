@@ -6,6 +6,8 @@ import (
 	"github.com/jaegertracing/jaeger/pkg/kafka/producer"
 	"github.com/jaegertracing/jaeger/storage/dependencystore"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/jaeger-storages/health"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
 	"github.com/spf13/viper"
 	"github.com/uber/jaeger-lib/metrics"
 	"go.uber.org/zap"
@@ -15,6 +17,10 @@ type Factory struct {
 	options Options
 	producer.Builder
 	producer   sarama.AsyncProducer
+	codec      *spans.Codec
+	// writer is returned by every CreateSpanWriter, it must be the only consumer of the
+	// acknowledgments of the producer
+	writer *SpanWriter
 
 }
 
@@ -39,22 +45,59 @@ func (f *Factory) InitFromOptions(o Options) {
 
 
 func (f *Factory) Initialize(metricsFactory metrics.Factory, zapLogger *zap.Logger) error {
+	if err := f.options.Validate(); err != nil {
+		return err
+	}
+	codec, err := spans.NewCodec(f.options.SpanCompression)
+	if err != nil {
+		return err
+	}
+	f.codec = codec
 	p, err := f.NewProducer()
 	if err != nil {
 		return err
 	}
 	f.producer = p
+	f.writer = NewSpanWriter(f.producer, f.options.Topic, f.options.Tenants(), f.codec)
+	if err := f.applyLifecycle(); err != nil {
+		return err
+	}
+	return health.Startup(f.options.HealthCheck, f.HealthChecks(), zapLogger)
+}
+
+// applyLifecycle configures retention rules and compaction of the spans datasource in the coordinator
+func (f *Factory) applyLifecycle() error {
+	if f.options.CoordinatorURL == "" {
+		return nil
+	}
+	coordinator := NewCoordinator(f.options.CoordinatorURL)
+	if f.options.Retention > 0 {
+		if err := coordinator.SetRetention(spansDataSource, f.options.Retention, f.options.Replicants); err != nil {
+			return err
+		}
+	}
+	if f.options.Compaction {
+		return coordinator.SetCompaction(spansDataSource, f.options.CompactionSkipOffset, f.options.CompactionMaxRows)
+	}
 	return nil
 }
 
 func (f *Factory) CreateSpanReader() (spanstore.Reader, error) {
-	reader, err := NewReader("http://localhost:8888")
+	if f.options.SQLReader {
+		return NewSQLReader(f.options.BrokerURL, f.options)
+	}
+	reader, err := NewReader(f.options.BrokerURL, f.options)
 	return reader, err
 }
 
 func (f *Factory) CreateSpanWriter() (spanstore.Writer, error) {
-	return NewSpanWriter(f.producer, f.options.Topic), nil
+	return f.writer, nil
+}
+// CreateAnalytics returns the metrics API served from the rollup datasource
+func (f *Factory) CreateAnalytics() (Analytics, error) {
+	return NewReader(f.options.BrokerURL, f.options)
 }
+
 func (f *Factory) CreateDependencyReader() (dependencystore.Reader, error) {
 	return nil, nil
 }
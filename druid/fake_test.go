@@ -0,0 +1,78 @@
+package druid
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeBroker is a druid broker answering native queries on /druid/v2 and SQL queries on
+// /druid/v2/sql with the responses of its handler, it records the decoded queries. It must be closed.
+type fakeBroker struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	queries []map[string]interface{}
+	respond func(query map[string]interface{}) interface{}
+}
+
+func newFakeBroker(t *testing.T, respond func(query map[string]interface{}) interface{}) *fakeBroker {
+	broker := &fakeBroker{respond: respond}
+	broker.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading the query: %v", err)
+			return
+		}
+		query := map[string]interface{}{}
+		if err := json.Unmarshal(body, &query); err != nil {
+			t.Errorf("decoding the query %s: %v", body, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		broker.mtx.Lock()
+		broker.queries = append(broker.queries, query)
+		broker.mtx.Unlock()
+		response := interface{}([]interface{}{})
+		if broker.respond != nil {
+			response = broker.respond(query)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	return broker
+}
+
+// recorded returns the queries received so far
+func (b *fakeBroker) recorded() []map[string]interface{} {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return append([]map[string]interface{}{}, b.queries...)
+}
+
+// groupByRows is the response of a groupBy query returning the events
+func groupByRows(events ...map[string]interface{}) []interface{} {
+	rows := make([]interface{}, len(events))
+	for i, event := range events {
+		rows[i] = map[string]interface{}{
+			"version":   "v1",
+			"timestamp": "2020-01-01T00:00:00.000Z",
+			"event":     event,
+		}
+	}
+	return rows
+}
+
+// asJSON re-encodes a value to compare it with decoded queries
+func asJSON(t *testing.T, value interface{}) interface{} {
+	body, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
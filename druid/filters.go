@@ -0,0 +1,130 @@
+package druid
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rubenvp8510/godruid"
+)
+
+// Extended tag predicates, only parsed when Options.ExtendedTagPredicates is enabled:
+//
+//	!=value   tag is not equal to value
+//	~pattern  tag matches the regular expression
+//	prefix*   tag starts with prefix
+//	min..max  tag is a number within [min, max], either side may be omitted
+const (
+	notEqualPrefix   = "!="
+	regexPrefix      = "~"
+	prefixWildcard   = "*"
+	rangeSeparator   = ".."
+	numericOrdering  = "numeric"
+	boundFilterType  = "bound"
+	orderingProperty = "ordering"
+)
+
+func buildTagFilter(key, value string, extended bool) *godruid.Filter {
+	dimension := tagDimension(key)
+	if !extended {
+		return godruid.FilterSelector(dimension, value)
+	}
+	switch {
+	case strings.HasPrefix(value, notEqualPrefix):
+		return godruid.FilterNot(godruid.FilterSelector(dimension, strings.TrimPrefix(value, notEqualPrefix)))
+	case strings.HasPrefix(value, regexPrefix):
+		return godruid.FilterRegex(dimension, strings.TrimPrefix(value, regexPrefix))
+	case strings.HasSuffix(value, prefixWildcard):
+		return godruid.FilterRegex(dimension, "^"+regexp.QuoteMeta(strings.TrimSuffix(value, prefixWildcard)))
+	case strings.Contains(value, rangeSeparator):
+		bounds := strings.SplitN(value, rangeSeparator, 2)
+		return godruid.FilterBound(dimension, strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[1]))
+	}
+	return godruid.FilterSelector(dimension, value)
+}
+
+// filteredAggregation is a druid filtered aggregator, it isn't supported by godruid.
+type filteredAggregation struct {
+	Type       string              `json:"type"`
+	Filter     *godruid.Filter     `json:"filter"`
+	Aggregator godruid.Aggregation `json:"aggregator"`
+}
+
+func aggFiltered(filter *godruid.Filter, aggregator godruid.Aggregation) filteredAggregation {
+	return filteredAggregation{
+		Type:       "filtered",
+		Filter:     filter,
+		Aggregator: aggregator,
+	}
+}
+
+// execute runs a query that godruid is not able to express by itself. The query is serialized
+// here, overrides replace top level properties of the query and every bound filter is marked as
+// numeric, godruid doesn't expose the bound ordering and druid would compare them lexicographically.
+func (r *Reader) execute(query godruid.Query, overrides map[string]interface{}) error {
+	var result interface{}
+	switch q := query.(type) {
+	case *godruid.QueryTopN:
+		q.QueryType = "topN"
+		result = &q.QueryResult
+	case *godruid.QueryGroupBy:
+		q.QueryType = "groupBy"
+		result = &q.QueryResult
+	case *godruid.QueryScan:
+		q.QueryType = "scan"
+		result = &q.QueryResult
+	case *godruid.QueryTimeseries:
+		q.QueryType = "timeseries"
+		result = &q.QueryResult
+	default:
+		return fmt.Errorf("unsupported druid query type %T", query)
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return err
+	}
+	generic := map[string]interface{}{}
+	if err := json.Unmarshal(body, &generic); err != nil {
+		return err
+	}
+	for key, value := range overrides {
+		generic[key] = value
+	}
+	body, err = json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	generic = map[string]interface{}{}
+	if err := json.Unmarshal(body, &generic); err != nil {
+		return err
+	}
+	setNumericOrdering(generic)
+	body, err = json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	response, err := r.client.QueryRaw(body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(response, result)
+}
+
+func setNumericOrdering(filter interface{}) {
+	switch f := filter.(type) {
+	case map[string]interface{}:
+		if f["type"] == boundFilterType {
+			f[orderingProperty] = numericOrdering
+		}
+		for _, child := range f {
+			setNumericOrdering(child)
+		}
+	case []interface{}:
+		for _, child := range f {
+			setNumericOrdering(child)
+		}
+	}
+}
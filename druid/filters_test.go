@@ -0,0 +1,163 @@
+package druid
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/godruid"
+)
+
+func TestBuildTagFilter(t *testing.T) {
+	dimension := tagDimension("http.status_code")
+	tests := []struct {
+		name     string
+		value    string
+		extended bool
+		expected *godruid.Filter
+	}{
+		{
+			name:     "plain value",
+			value:    "200",
+			extended: true,
+			expected: godruid.FilterSelector(dimension, "200"),
+		},
+		{
+			name:     "not equal",
+			value:    "!=200",
+			extended: true,
+			expected: godruid.FilterNot(godruid.FilterSelector(dimension, "200")),
+		},
+		{
+			name:     "regex",
+			value:    "~^5\\d\\d$",
+			extended: true,
+			expected: godruid.FilterRegex(dimension, "^5\\d\\d$"),
+		},
+		{
+			name:     "prefix quotes the regex metacharacters",
+			value:    "2.0*",
+			extended: true,
+			expected: godruid.FilterRegex(dimension, "^2\\.0"),
+		},
+		{
+			name:     "range",
+			value:    "200..299",
+			extended: true,
+			expected: godruid.FilterBound(dimension, "200", "299"),
+		},
+		{
+			name:     "range without upper bound",
+			value:    "500..",
+			extended: true,
+			expected: godruid.FilterBound(dimension, "500", ""),
+		},
+		{
+			name:     "range without lower bound",
+			value:    " .. 299",
+			extended: true,
+			expected: godruid.FilterBound(dimension, "", "299"),
+		},
+		{
+			name:     "predicates are literal values when disabled",
+			value:    "!=200",
+			extended: false,
+			expected: godruid.FilterSelector(dimension, "!=200"),
+		},
+		{
+			name:     "wildcard is a literal value when disabled",
+			value:    "2*",
+			extended: false,
+			expected: godruid.FilterSelector(dimension, "2*"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := buildTagFilter("http.status_code", test.value, test.extended)
+			if !reflect.DeepEqual(filter, test.expected) {
+				t.Errorf("filter is %+v, expected %+v", filter, test.expected)
+			}
+		})
+	}
+}
+
+func TestBuildFilterMatchesEveryCriteria(t *testing.T) {
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:   "frontend",
+		OperationName: "get",
+		Tags:          map[string]string{"error": "true"},
+		DurationMin:   time.Millisecond,
+	}
+	filter := buildFilter(query, false)
+
+	if filter.Type != "and" {
+		t.Fatalf("filter is a %q filter, expected and", filter.Type)
+	}
+	expected := []*godruid.Filter{
+		godruid.FilterBound("duration", "1000", ""),
+		godruid.FilterAnd(
+			godruid.FilterSelector("operationName", "get"),
+			godruid.FilterSelector("process.serviceName", "frontend"),
+		),
+		godruid.FilterSelector(tagDimension("error"), "true"),
+	}
+	if !reflect.DeepEqual(filter.Fields, expected) {
+		t.Errorf("filters are %+v, expected %+v", filter.Fields, expected)
+	}
+}
+
+func TestBuildFilterWithoutCriteria(t *testing.T) {
+	if filter := buildFilter(&spanstore.TraceQueryParameters{}, true); filter != nil {
+		t.Errorf("filter is %+v, expected none", filter)
+	}
+}
+
+func TestFindTraceIDsNumericBounds(t *testing.T) {
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		return []interface{}{map[string]interface{}{
+			"timestamp": "2020-01-01T00:00:00.000Z",
+			"result":    []interface{}{map[string]interface{}{"traceId": "0000000000000001"}},
+		}}
+	})
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{ExtendedTagPredicates: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	ids, err := reader.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		Tags:         map[string]string{"http.status_code": "500..599"},
+		StartTimeMin: now.Add(-time.Hour),
+		StartTimeMax: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0].Low != 1 {
+		t.Errorf("trace IDs are %v, expected 1", ids)
+	}
+
+	queries := broker.recorded()
+	if len(queries) != 1 {
+		t.Fatalf("%d queries sent, expected 1", len(queries))
+	}
+	if queryType := queries[0]["queryType"]; queryType != "topN" {
+		t.Errorf("query type is %v, expected topN", queryType)
+	}
+	bound := map[string]interface{}{
+		"type":      "bound",
+		"dimension": tagDimension("http.status_code"),
+		"lower":     "500",
+		"upper":     "599",
+		"ordering":  "numeric",
+	}
+	filter, _ := queries[0]["filter"].(map[string]interface{})
+	fields, _ := filter["fields"].([]interface{})
+	if filter["type"] != "and" || len(fields) != 2 || !reflect.DeepEqual(fields[1], bound) {
+		t.Errorf("filter is %v, expected the service and a numeric bound on the tag", filter)
+	}
+}
@@ -0,0 +1,137 @@
+package druid
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/rubenvp8510/jaeger-storages/health"
+)
+
+const statusEndpoint = "/status"
+
+// spansColumns are the columns of the spans datasource the readers query, with their druid SQL types
+var spansColumns = map[string]string{
+	"__time":              "TIMESTAMP",
+	"traceId":             sqlVarchar,
+	"span":                sqlVarchar,
+	"operationName":       sqlVarchar,
+	"process.serviceName": sqlVarchar,
+	"spanKind":            sqlVarchar,
+	"duration":            sqlBigint,
+}
+
+// HealthChecks returns the checks of the druid broker, of the kafka topic spans are published to
+// and of the schema of the spans datasource.
+func (f *Factory) HealthChecks() []health.Check {
+	return []health.Check{
+		{Name: "druid-broker", Run: f.checkBroker},
+		{Name: "kafka", Run: f.checkKafka},
+		{Name: "druid-schema", Run: f.checkSchema},
+	}
+}
+
+// CheckHealth runs the health checks, for readiness probes.
+func (f *Factory) CheckHealth(ctx context.Context) health.Report {
+	return health.Run(ctx, f.HealthChecks())
+}
+
+func (f *Factory) checkBroker(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.options.BrokerURL+statusEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, string(message))
+	}
+	return nil
+}
+
+// checkKafka fetches the metadata of the topic from the brokers, with the settings of the producer.
+// The sarama client doesn't take a context, the check gives up on it when ctx is done.
+func (f *Factory) checkKafka(ctx context.Context) error {
+	return health.Await(ctx, f.fetchTopicMetadata)
+}
+
+func (f *Factory) fetchTopicMetadata() error {
+	config := sarama.NewConfig()
+	if err := f.options.Config.AuthenticationConfig.SetConfiguration(config); err != nil {
+		return err
+	}
+	if f.options.Config.ProtocolVersion != "" {
+		version, err := sarama.ParseKafkaVersion(f.options.Config.ProtocolVersion)
+		if err != nil {
+			return err
+		}
+		config.Version = version
+	}
+	client, err := sarama.NewClient(f.options.Config.Brokers, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	partitions, err := client.Partitions(f.options.Topic)
+	if err != nil {
+		return fmt.Errorf("topic %s: %w", f.options.Topic, err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions", f.options.Topic)
+	}
+	return nil
+}
+
+// checkSchema verifies the spans datasource has the columns the readers query, it fails until
+// the first spans are ingested.
+func (f *Factory) checkSchema(ctx context.Context) error {
+	reader, err := NewSQLReader(f.options.BrokerURL, f.options)
+	if err != nil {
+		return err
+	}
+	rows, err := reader.query(ctx, "SELECT COLUMN_NAME, DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS", sqlCondition{
+		"TABLE_SCHEMA = 'druid' AND TABLE_NAME = ?",
+		[]sqlParameter{varchar(spansDataSource)},
+	}, "")
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("datasource %s doesn't exist", spansDataSource)
+	}
+	types := make(map[string]string, len(rows))
+	for _, row := range rows {
+		types[fmt.Sprintf("%v", row["COLUMN_NAME"])] = fmt.Sprintf("%v", row["DATA_TYPE"])
+	}
+	expected := make(map[string]string, len(spansColumns)+1)
+	for column, columnType := range spansColumns {
+		expected[column] = columnType
+	}
+	if f.options.Tenancy {
+		expected[tenantDimension] = sqlVarchar
+	}
+
+	var mismatches []string
+	for column, columnType := range expected {
+		actual, ok := types[column]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, column+" is missing")
+		case actual != columnType:
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s instead of %s", column, actual, columnType))
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("unexpected schema of datasource %s: %s", spansDataSource, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package druid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckBroker(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		healthy bool
+	}{
+		{name: "healthy", status: http.StatusOK, healthy: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var path string
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.WriteHeader(test.status)
+			}))
+			defer broker.Close()
+			factory := &Factory{options: Options{BrokerURL: broker.URL}}
+
+			err := factory.checkBroker(context.Background())
+			if (err == nil) != test.healthy {
+				t.Errorf("check returned %v", err)
+			}
+			if path != statusEndpoint {
+				t.Errorf("broker checked on %s, expected %s", path, statusEndpoint)
+			}
+		})
+	}
+}
+
+func TestCheckBrokerGivesUpWithContext(t *testing.T) {
+	release := make(chan struct{})
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer broker.Close()
+	defer close(release)
+	factory := &Factory{options: Options{BrokerURL: broker.URL}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := factory.checkBroker(ctx); err == nil {
+		t.Error("check of a hanging broker passed")
+	}
+	if ctx.Err() == nil {
+		t.Error("check returned before the context was done")
+	}
+}
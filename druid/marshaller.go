@@ -2,18 +2,34 @@ package druid
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/traceid"
 )
 
-const tagPrefix = "__tag."
+const (
+	// tagSeparator joins the tag prefix and the tag key in the dimension names
+	tagSeparator    = "."
+	tenantDimension = "tenant"
+)
 
-type DruidMarshall struct {
+// tagDimension returns the dimension storing the tag
+func tagDimension(key string) string {
+	return spans.TagField(tagSeparator, key)
+}
 
+type DruidMarshall struct {
+	codec *spans.Codec
 }
 
-func (m *DruidMarshall) Marshal(span *model.Span) ([]byte, error) {
+// Marshal normalizes the span into a flat json document, tenant is only added when not empty
+func (m *DruidMarshall) Marshal(span *model.Span, tenant string) ([]byte, error) {
 	normalizedSpan := map[string]interface{}{}
-	normalizedSpan["traceId"] = span.TraceID.String()
+	if tenant != "" {
+		normalizedSpan[tenantDimension] = tenant
+	}
+	normalizedSpan["traceId"] = traceid.Canonical(span.TraceID)
 	normalizedSpan["spanID"] = span.SpanID.String()
 	normalizedSpan["operationName"] = span.OperationName
 	normalizedSpan["flags"] = uint32(span.Flags)
@@ -21,14 +37,32 @@ func (m *DruidMarshall) Marshal(span *model.Span) ([]byte, error) {
 	normalizedSpan["duration"] = span.Duration.Microseconds()
 	normalizedSpan["process.serviceName"] = span.Process.ServiceName
 	normalizedSpan["process.processId"] = span.ProcessID
-	bytes, err :=  marshallSpan(span)
+	spanKind, _ := span.GetSpanKind()
+	normalizedSpan["spanKind"] = spanKind
+	bytes, err :=  m.codec.Encode(span)
 	if err != nil {
 		return nil, err
 	}
 	normalizedSpan["span"] = bytes
 	for _, tag := range span.Tags  {
-		normalizedSpan[tagPrefix+tag.Key] = tag.AsString()
+		normalizedSpan[tagDimension(tag.Key)] = tag.AsString()
 	}
 	return json.Marshal(normalizedSpan)
 }
 
+
+// Unmarshal decodes a document written by Marshal, returning the span and its tenant
+func (m *DruidMarshall) Unmarshal(data []byte) (*model.Span, string, error) {
+	var document struct {
+		Span   string `json:"span"`
+		Tenant string `json:"tenant"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, "", err
+	}
+	if document.Span == "" {
+		return nil, "", errors.New("document has no span")
+	}
+	span, err := spans.Decode(document.Span)
+	return span, document.Tenant, err
+}
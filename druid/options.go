@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"github.com/jaegertracing/jaeger/pkg/kafka/auth"
 	"github.com/jaegertracing/jaeger/pkg/kafka/producer"
-	"log"
+	"github.com/jaegertracing/jaeger/pkg/multierror"
+	"net"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
 	"github.com/spf13/viper"
 )
 
@@ -27,6 +31,18 @@ const (
 	suffixBatchLinger      = ".batch-linger"
 	suffixBatchSize        = ".batch-size"
 	suffixBatchMaxMessages = ".batch-max-messages"
+	suffixLookback         = ".lookback"
+	suffixCacheTTL         = ".cache-ttl"
+	suffixExtendedTags     = ".extended-tag-predicates"
+	suffixCoordinatorURL   = ".coordinator-url"
+	suffixRetention        = ".retention"
+	suffixReplicants       = ".replicants"
+	suffixCompaction       = ".compaction"
+	suffixCompactionOffset = ".compaction-skip-offset"
+	suffixCompactionRows   = ".compaction-max-rows-per-segment"
+	suffixSQLReader        = ".sql-reader"
+	suffixTracePadding     = ".sql-reader.trace-window-padding"
+	suffixBrokerURL        = ".broker-url"
 
 	defaultBroker           = "127.0.0.1:9092"
 	defaultTopic            = "jaeger-spans"
@@ -36,6 +52,15 @@ const (
 	defaultBatchLinger      = 0
 	defaultBatchSize        = 0
 	defaultBatchMaxMessages = 0
+	defaultLookback         = 7 * 24 * time.Hour
+	defaultCacheTTL         = time.Minute
+	defaultCoordinatorURL   = ""
+	defaultBrokerURL        = "http://localhost:8888"
+	defaultRetention        = 0
+	defaultReplicants       = 1
+	defaultCompactionOffset = time.Hour
+	defaultCompactionRows   = 5000000
+	defaultTracePadding     = time.Hour
 )
 
 var (
@@ -88,6 +113,29 @@ type Options struct {
 	Config   producer.Configuration `mapstructure:",squash"`
 	Topic    string                 `mapstructure:"topic"`
 	Encoding string                 `mapstructure:"encoding"`
+	Lookback time.Duration          `mapstructure:"lookback"`
+	CacheTTL time.Duration          `mapstructure:"cache_ttl"`
+	// BrokerURL is the druid broker the readers and the health checks query
+	BrokerURL string `mapstructure:"broker_url"`
+
+	ExtendedTagPredicates bool `mapstructure:"extended_tag_predicates"`
+
+	CoordinatorURL       string        `mapstructure:"coordinator_url"`
+	Retention            time.Duration `mapstructure:"retention"`
+	Replicants           int           `mapstructure:"replicants"`
+	Compaction           bool          `mapstructure:"compaction"`
+	CompactionSkipOffset time.Duration `mapstructure:"compaction_skip_offset"`
+	CompactionMaxRows    int           `mapstructure:"compaction_max_rows_per_segment"`
+
+	SQLReader bool `mapstructure:"sql_reader"`
+	// TraceWindowPadding widens the searched window when the SQL reader fetches the spans of the
+	// traces found, zero fetches them from every segment
+	TraceWindowPadding time.Duration `mapstructure:"trace_window_padding"`
+
+	spans.Options `mapstructure:",squash"`
+
+	// parseErrors are the errors of the flags InitFromViper couldn't map, reported by Validate
+	parseErrors []error
 }
 
 // AddFlags adds flags for Options
@@ -134,6 +182,67 @@ func (opt *Options) AddFlags(flagSet *flag.FlagSet) {
 		defaultBatchMaxMessages,
 		"(experimental) Number of message to batch before sending records to Kafka. Higher value reduce request to Kafka but increase latency and the possibility of data loss in case of process restart. See https://kafka.apache.org/documentation/",
 	)
+	flagSet.String(
+		configPrefix+suffixBrokerURL,
+		defaultBrokerURL,
+		"The druid broker URL the spans are read from, i.e. 'http://127.0.0.1:8082'",
+	)
+	flagSet.Duration(
+		configPrefix+suffixLookback,
+		defaultLookback,
+		"How far back in time GetServices and GetOperations look for distinct services and operations",
+	)
+	flagSet.Duration(
+		configPrefix+suffixCacheTTL,
+		defaultCacheTTL,
+		"How long the results of GetServices and GetOperations are cached in memory",
+	)
+	flagSet.Bool(
+		configPrefix+suffixExtendedTags,
+		false,
+		"(experimental) Parse tag search values as predicates: '!=value', '~regex', 'prefix*' and numeric ranges 'min..max'",
+	)
+	flagSet.String(
+		configPrefix+suffixCoordinatorURL,
+		defaultCoordinatorURL,
+		"The druid coordinator URL, i.e. 'http://127.0.0.1:8081'. Retention and compaction are only applied when set",
+	)
+	flagSet.Duration(
+		configPrefix+suffixRetention,
+		defaultRetention,
+		"How long the spans are kept loaded in druid, older segments are dropped. Zero leaves the datasource rules untouched",
+	)
+	flagSet.Int(
+		configPrefix+suffixReplicants,
+		defaultReplicants,
+		"Number of replicas of each loaded segment in the default tier",
+	)
+	flagSet.Bool(
+		configPrefix+suffixCompaction,
+		false,
+		"Enable automatic compaction of the small hourly segments produced by the kafka ingestion",
+	)
+	flagSet.Duration(
+		configPrefix+suffixCompactionOffset,
+		defaultCompactionOffset,
+		"Segments newer than this offset from the latest one are not compacted",
+	)
+	flagSet.Int(
+		configPrefix+suffixCompactionRows,
+		defaultCompactionRows,
+		"Maximum number of rows per compacted segment",
+	)
+	flagSet.Bool(
+		configPrefix+suffixSQLReader,
+		false,
+		"(experimental) Read spans with druid SQL queries instead of native queries",
+	)
+	flagSet.Duration(
+		configPrefix+suffixTracePadding,
+		defaultTracePadding,
+		"How far around the searched window the SQL reader fetches the spans of the traces found. Spans of longer traces outside of it are missing, zero fetches them from every segment",
+	)
+	opt.Options.AddFlags(configPrefix, flagSet)
 	auth.AddFlags(configPrefix, flagSet)
 }
 
@@ -155,6 +264,17 @@ func DefaultOptions()Options  {
 			AuthenticationConfig: authenticationOptions,
 		},
 		Topic:defaultTopic,
+		Lookback: defaultLookback,
+		CacheTTL: defaultCacheTTL,
+		BrokerURL: defaultBrokerURL,
+
+		CoordinatorURL:       defaultCoordinatorURL,
+		Retention:            defaultRetention,
+		Replicants:           defaultReplicants,
+		CompactionSkipOffset: defaultCompactionOffset,
+		CompactionMaxRows:    defaultCompactionRows,
+		TraceWindowPadding:   defaultTracePadding,
+		Options:              spans.DefaultOptions(),
 	}
 }
 
@@ -164,20 +284,19 @@ func (opt *Options) InitFromViper(v *viper.Viper) {
 	authenticationOptions := auth.AuthenticationConfig{}
 	authenticationOptions.InitFromViper(configPrefix, v)
 
+	opt.parseErrors = nil
 	requiredAcks, err := getRequiredAcks(v.GetString(configPrefix + suffixRequiredAcks))
 	if err != nil {
-		log.Fatal(err)
+		opt.parseErrors = append(opt.parseErrors, fmt.Errorf("%s: %w", configPrefix+suffixRequiredAcks, err))
 	}
 
 	compressionMode := strings.ToLower(v.GetString(configPrefix + suffixCompression))
+	var compressionLevel int
 	compressionModeCodec, err := getCompressionMode(compressionMode)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	compressionLevel, err := getCompressionLevel(compressionMode, v.GetInt(configPrefix+suffixCompressionLevel))
-	if err != nil {
-		log.Fatal(err)
+		opt.parseErrors = append(opt.parseErrors, fmt.Errorf("%s: %w", configPrefix+suffixCompression, err))
+	} else if compressionLevel, err = getCompressionLevel(compressionMode, v.GetInt(configPrefix+suffixCompressionLevel)); err != nil {
+		opt.parseErrors = append(opt.parseErrors, fmt.Errorf("%s: %w", configPrefix+suffixCompressionLevel, err))
 	}
 
 	opt.Config = producer.Configuration{
@@ -192,6 +311,73 @@ func (opt *Options) InitFromViper(v *viper.Viper) {
 		BatchMaxMessages:     v.GetInt(configPrefix + suffixBatchMaxMessages),
 	}
 	opt.Topic = v.GetString(configPrefix + suffixTopic)
+	opt.Lookback = v.GetDuration(configPrefix + suffixLookback)
+	opt.CacheTTL = v.GetDuration(configPrefix + suffixCacheTTL)
+	opt.BrokerURL = v.GetString(configPrefix + suffixBrokerURL)
+	opt.ExtendedTagPredicates = v.GetBool(configPrefix + suffixExtendedTags)
+	opt.CoordinatorURL = v.GetString(configPrefix + suffixCoordinatorURL)
+	opt.Retention = v.GetDuration(configPrefix + suffixRetention)
+	opt.Replicants = v.GetInt(configPrefix + suffixReplicants)
+	opt.Compaction = v.GetBool(configPrefix + suffixCompaction)
+	opt.CompactionSkipOffset = v.GetDuration(configPrefix + suffixCompactionOffset)
+	opt.CompactionMaxRows = v.GetInt(configPrefix + suffixCompactionRows)
+	opt.SQLReader = v.GetBool(configPrefix + suffixSQLReader)
+	opt.TraceWindowPadding = v.GetDuration(configPrefix + suffixTracePadding)
+	opt.Options.InitFromViper(configPrefix, v)
+}
+
+// Validate returns an error reporting every invalid option, named after its flag.
+func (opt *Options) Validate() error {
+	errs := append([]error{}, opt.parseErrors...)
+	if len(opt.Config.Brokers) == 0 {
+		errs = append(errs, fmt.Errorf("%s: no broker", configPrefix+suffixBrokers))
+	}
+	for _, broker := range opt.Config.Brokers {
+		if _, port, err := net.SplitHostPort(broker); err != nil || port == "" {
+			errs = append(errs, fmt.Errorf("%s: invalid broker address %q, expected host:port", configPrefix+suffixBrokers, broker))
+		}
+	}
+	if opt.Topic == "" {
+		errs = append(errs, fmt.Errorf("%s: empty topic", configPrefix+suffixTopic))
+	}
+	if opt.Config.BatchLinger < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative linger %v", configPrefix+suffixBatchLinger, opt.Config.BatchLinger))
+	}
+	if opt.Config.BatchSize < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative size %d", configPrefix+suffixBatchSize, opt.Config.BatchSize))
+	}
+	if opt.Config.BatchMaxMessages < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative number of messages %d", configPrefix+suffixBatchMaxMessages, opt.Config.BatchMaxMessages))
+	}
+	if opt.Lookback <= 0 {
+		errs = append(errs, fmt.Errorf("%s: the lookback must be positive", configPrefix+suffixLookback))
+	}
+	// the cache never expires its entries with a zero TTL, new services would never be listed
+	if opt.CacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("%s: the TTL must be positive, got %v", configPrefix+suffixCacheTTL, opt.CacheTTL))
+	}
+	if opt.TraceWindowPadding < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative padding %v", configPrefix+suffixTracePadding, opt.TraceWindowPadding))
+	}
+	if broker, err := url.Parse(opt.BrokerURL); err != nil || broker.Scheme == "" || broker.Host == "" {
+		errs = append(errs, fmt.Errorf("%s: invalid URL %q", configPrefix+suffixBrokerURL, opt.BrokerURL))
+	}
+	if opt.CoordinatorURL != "" {
+		if coordinator, err := url.Parse(opt.CoordinatorURL); err != nil || coordinator.Scheme == "" || coordinator.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: invalid URL %q", configPrefix+suffixCoordinatorURL, opt.CoordinatorURL))
+		}
+	}
+	if opt.Retention < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative retention %v", configPrefix+suffixRetention, opt.Retention))
+	}
+	if opt.Retention > 0 && opt.Replicants < 1 {
+		errs = append(errs, fmt.Errorf("%s: at least one replica is needed", configPrefix+suffixReplicants))
+	}
+	if opt.Compaction && opt.CompactionMaxRows <= 0 {
+		errs = append(errs, fmt.Errorf("%s: the maximum rows per segment must be positive", configPrefix+suffixCompactionRows))
+	}
+	errs = append(errs, opt.Options.Validate(configPrefix)...)
+	return multierror.Wrap(errs)
 }
 
 // stripWhiteSpace removes all whitespace characters from a string
@@ -0,0 +1,80 @@
+package druid
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(options *Options)
+		// invalid is the flag reported, empty when the options are valid
+		invalid string
+	}{
+		{name: "defaults", modify: func(*Options) {}},
+		{name: "no broker", modify: func(o *Options) { o.Config.Brokers = nil }, invalid: "druid.brokers"},
+		{name: "broker without port", modify: func(o *Options) { o.Config.Brokers = []string{"kafka"} }, invalid: "druid.brokers"},
+		{name: "empty topic", modify: func(o *Options) { o.Topic = "" }, invalid: "druid.topic"},
+		{name: "negative linger", modify: func(o *Options) { o.Config.BatchLinger = -time.Second }, invalid: "druid.batch-linger"},
+		{name: "negative batch size", modify: func(o *Options) { o.Config.BatchSize = -1 }, invalid: "druid.batch-size"},
+		{name: "negative batch messages", modify: func(o *Options) { o.Config.BatchMaxMessages = -1 }, invalid: "druid.batch-max-messages"},
+		{name: "zero lookback", modify: func(o *Options) { o.Lookback = 0 }, invalid: "druid.lookback"},
+		{name: "zero cache TTL", modify: func(o *Options) { o.CacheTTL = 0 }, invalid: "druid.cache-ttl"},
+		{name: "negative cache TTL", modify: func(o *Options) { o.CacheTTL = -time.Minute }, invalid: "druid.cache-ttl"},
+		{name: "broker URL without scheme", modify: func(o *Options) { o.BrokerURL = "druid:8082" }, invalid: "druid.broker-url"},
+		{name: "coordinator", modify: func(o *Options) { o.CoordinatorURL = "http://druid:8081" }},
+		{name: "coordinator URL without host", modify: func(o *Options) { o.CoordinatorURL = "http://" }, invalid: "druid.coordinator-url"},
+		{name: "negative retention", modify: func(o *Options) { o.Retention = -time.Hour }, invalid: "druid.retention"},
+		{name: "retention without replica", modify: func(o *Options) {
+			o.Retention = time.Hour
+			o.Replicants = 0
+		}, invalid: "druid.replicants"},
+		{name: "compaction without rows", modify: func(o *Options) {
+			o.Compaction = true
+			o.CompactionMaxRows = 0
+		}, invalid: "druid.compaction-max-rows-per-segment"},
+		{name: "unknown health check mode", modify: func(o *Options) { o.HealthCheck = "eventually" }, invalid: "druid.health-check"},
+		{name: "trusted process tag without tenancy", modify: func(o *Options) { o.TrustProcessTag = true }, invalid: "druid.tenancy.trust-process-tag"},
+		{name: "tenancy without write tenant", modify: func(o *Options) { o.Tenancy = true }, invalid: "druid.tenancy.enabled"},
+		{name: "negative trace window padding", modify: func(o *Options) { o.TraceWindowPadding = -time.Hour }, invalid: "druid.sql-reader.trace-window-padding"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DefaultOptions()
+			test.modify(&options)
+			err := options.Validate()
+			switch {
+			case test.invalid == "" && err != nil:
+				t.Errorf("valid options rejected: %v", err)
+			case test.invalid != "" && err == nil:
+				t.Errorf("invalid %s accepted", test.invalid)
+			case test.invalid != "" && !strings.Contains(err.Error(), test.invalid):
+				t.Errorf("error %q doesn't name %s", err, test.invalid)
+			}
+		})
+	}
+}
+
+func TestOptionsValidateReportsUnparsedFlags(t *testing.T) {
+	flagSet := flag.NewFlagSet("druid", flag.ContinueOnError)
+	options := Options{}
+	options.AddFlags(flagSet)
+	if err := flagSet.Parse([]string{"--druid.required-acks=some", "--druid.compression=brotli"}); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	flagSet.VisitAll(func(f *flag.Flag) {
+		v.Set(f.Name, f.Value.String())
+	})
+	options.InitFromViper(v)
+
+	err := options.Validate()
+	if err == nil || !strings.Contains(err.Error(), "druid.required-acks") || !strings.Contains(err.Error(), "druid.compression") {
+		t.Errorf("error %v doesn't report both invalid flags", err)
+	}
+}
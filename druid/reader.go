@@ -2,88 +2,164 @@ package druid
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"time"
+
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/pkg/cache"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
 	"github.com/rubenvp8510/godruid"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/rubenvp8510/jaeger-storages/traceid"
 )
 
 var (
 	// ErrTraceNotFound is returned by Reader's GetTrace if no data is found for given trace ID.
-	ErrTraceNotFound = errors.New("trace not found")
+	ErrTraceNotFound = spans.ErrTraceNotFound
+)
+
+const (
+	servicesCacheKey  = "services"
+	distinctCacheSize = 1000
 )
 
 type Reader struct {
-	client *godruid.Client
+	client       *godruid.Client
+	lookback     time.Duration
+	cache        cache.Cache
+	extendedTags bool
+	traceLevel   bool
+	tenants      tenancy.Resolver
 }
 
-func NewReader(host string) (*Reader, error) {
+func NewReader(host string, options Options) (*Reader, error) {
 	client := &godruid.Client{
 		Url: host,
 	}
-	client.Debug = true
 	return &Reader{
-		client: client,
+		client:       client,
+		lookback:     options.Lookback,
+		extendedTags: options.ExtendedTagPredicates,
+		traceLevel:   options.TraceLevelMatching,
+		tenants:      options.Tenants(),
+		cache: cache.NewLRUWithOptions(distinctCacheSize, &cache.Options{
+			TTL: options.CacheTTL,
+		}),
 	}, nil
 }
 
+func formatInterval(start, end time.Time) string {
+	return fmt.Sprintf("%s/%s",
+		start.UTC().Format("2006-01-02T15:04:05.999Z"),
+		end.UTC().Format("2006-01-02T15:04:05.999Z"),
+	)
+}
+
 type FilterSelector map[string]string
 
-func buildFilter(query *spanstore.TraceQueryParameters) *godruid.Filter {
+// tenantFilter returns the filter that restricts a query to the tenant of ctx, nil when tenancy is disabled.
+func (r *Reader) tenantFilter(ctx context.Context) (*godruid.Filter, error) {
+	tenant, err := r.tenants.Read(ctx)
+	return tenantSelector(tenant), err
+}
+
+func tenantSelector(tenant string) *godruid.Filter {
+	if tenant == "" {
+		return nil
+	}
+	return godruid.FilterSelector(tenantDimension, tenant)
+}
+
+// buildPredicates returns one filter per search criteria. Service and operation are kept together,
+// operations are listed per service so both refer to the same span.
+func buildPredicates(query *spanstore.TraceQueryParameters, extendedTags bool) []*godruid.Filter {
 	filters := make([]*godruid.Filter, 0)
 	if query.DurationMax != 0 || query.DurationMin != 0 {
-		max := query.DurationMax.Microseconds()
-		min := query.DurationMin.Microseconds()
-		filters = append(filters, godruid.FilterBound("duration", fmt.Sprintf("%d", min), fmt.Sprintf("%d", max)))
+		var min, max string
+		if query.DurationMin != 0 {
+			min = fmt.Sprintf("%d", query.DurationMin.Microseconds())
+		}
+		if query.DurationMax != 0 {
+			max = fmt.Sprintf("%d", query.DurationMax.Microseconds())
+		}
+		filters = append(filters, godruid.FilterBound("duration", min, max))
 	}
 
+	serviceFilters := make([]*godruid.Filter, 0)
 	if query.OperationName != "" {
-		filters = append(filters, godruid.FilterSelector("operationName", query.OperationName))
+		serviceFilters = append(serviceFilters, godruid.FilterSelector("operationName", query.OperationName))
 	}
 
 	if query.ServiceName != "" {
-		filters = append(filters, godruid.FilterSelector("process.serviceName", query.ServiceName))
+		serviceFilters = append(serviceFilters, godruid.FilterSelector("process.serviceName", query.ServiceName))
+	}
+	if serviceFilter := godruid.FilterAnd(serviceFilters...); serviceFilter != nil {
+		filters = append(filters, serviceFilter)
 	}
 
-	if len(query.Tags) > 0 {
-		tagFilters := make([]*godruid.Filter, 0)
-
-		for k, v := range query.Tags {
-			tagFilters = append(tagFilters, godruid.FilterSelector(tagPrefix+k, v))
-		}
-		tagFilter := godruid.FilterOr(tagFilters...)
-		filters = append(filters, tagFilter)
+	for k, v := range query.Tags {
+		filters = append(filters, buildTagFilter(k, v, extendedTags))
 	}
+	return filters
+}
 
-	return godruid.FilterAnd(filters...)
+// buildFilter matches spans that satisfy every search criteria.
+func buildFilter(query *spanstore.TraceQueryParameters, extendedTags bool) *godruid.Filter {
+	return godruid.FilterAnd(buildPredicates(query, extendedTags)...)
 }
 
 func (r *Reader) topNQueryBuilder(query *spanstore.TraceQueryParameters) *godruid.QueryTopN {
 	druidQuery := &godruid.QueryTopN{
 		DataSource: "jaeger-spans",
-		Intervals: []string{
-			fmt.Sprintf("%s/%s",
-				query.StartTimeMin.UTC().Format("2006-01-02T15:04:05.999Z"),
-				query.StartTimeMax.UTC().Format("2006-01-02T15:04:05.999Z"),
-			),
-		},
-		Filter:    buildFilter(query),
-		Dimension: godruid.DimDefault("traceId", "traceId"),
+		Intervals:  []string{formatInterval(query.StartTimeMin, query.StartTimeMax)},
+		Filter:     buildFilter(query, r.extendedTags),
+		Dimension:  godruid.DimDefault("traceId", "traceId"),
 		Metric: &godruid.TopNMetric{
 			Type: "dimension",
 		},
-		Threshold:   100,
+		Threshold:   spans.NumTraces(query),
 		Granularity: godruid.GranAll,
 	}
 	return druidQuery
 }
 
-func (r *Reader) getTraceIds(traceQuery *spanstore.TraceQueryParameters) ([]string, error) {
+// traceLevelQueryBuilder groups spans by trace, counting the spans that match each search criteria
+// with a filtered aggregator. A trace matches when every criteria is satisfied by any of its spans.
+func (r *Reader) traceLevelQueryBuilder(query *spanstore.TraceQueryParameters) (*godruid.QueryGroupBy, map[string]interface{}) {
+	predicates := buildPredicates(query, r.extendedTags)
+	aggregations := make([]filteredAggregation, len(predicates))
+	havings := make([]*godruid.Having, len(predicates))
+	for i, predicate := range predicates {
+		name := fmt.Sprintf("match_%d", i)
+		aggregations[i] = aggFiltered(predicate, godruid.AggCount(name))
+		havings[i] = godruid.HavingGreaterThan(name, 0)
+	}
+
+	limit := spans.NumTraces(query)
+
+	druidQuery := &godruid.QueryGroupBy{
+		DataSource:   "jaeger-spans",
+		Intervals:    []string{formatInterval(query.StartTimeMin, query.StartTimeMax)},
+		Filter:       godruid.FilterOr(predicates...),
+		Dimensions:   []godruid.DimSpec{godruid.DimDefault("traceId", "traceId")},
+		Aggregations: []godruid.Aggregation{},
+		Having:       godruid.HavingAnd(havings...),
+		LimitSpec:    godruid.LimitDefault(limit),
+		Granularity:  godruid.GranAll,
+	}
+	return druidQuery, map[string]interface{}{
+		"aggregations": aggregations,
+	}
+}
+
+func (r *Reader) getTraceIds(traceQuery *spanstore.TraceQueryParameters, tenantFilter *godruid.Filter) ([]string, error) {
+	if r.traceLevel {
+		return r.getTraceLevelIds(traceQuery, tenantFilter)
+	}
 	query := r.topNQueryBuilder(traceQuery)
-	err := r.client.Query(query)
-	println(r.client.LastRequest)
-	if err != nil {
+	query.Filter = godruid.FilterAnd(query.Filter, tenantFilter)
+	if err := r.execute(query, nil); err != nil {
 		return nil, err
 	}
 	traces := make([]string, 0)
@@ -99,97 +175,170 @@ func (r *Reader) getTraceIds(traceQuery *spanstore.TraceQueryParameters) ([]stri
 	return traces, nil
 }
 
-func (r *Reader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+func (r *Reader) getTraceLevelIds(traceQuery *spanstore.TraceQueryParameters, tenantFilter *godruid.Filter) ([]string, error) {
+	query, overrides := r.traceLevelQueryBuilder(traceQuery)
+	query.Filter = godruid.FilterAnd(query.Filter, tenantFilter)
+	if err := r.execute(query, overrides); err != nil {
+		return nil, err
+	}
+	traces := make([]string, 0)
+	for _, res := range query.QueryResult {
+		value, _ := res.Event["traceId"].(string)
+		if value != "" {
+			traces = append(traces, value)
+		}
+	}
+	return traces, nil
+}
 
+// traceIDFilter matches the spans of a trace, whichever form its ID was stored in.
+func traceIDFilter(traceID model.TraceID) *godruid.Filter {
+	return &godruid.Filter{
+		Type:      "in",
+		Dimension: "traceId",
+		Values:    traceid.Forms(traceID),
+	}
+}
+
+// scanTraces assembles the traces of the spans matching the filter.
+func (r *Reader) scanTraces(filter *godruid.Filter) (*spans.TraceAssembler, error) {
 	query := &godruid.QueryScan{
 		DataSource: "jaeger-spans",
-		Intervals:  []string{"-146136543-09-08T08:23:32.096Z/146140482-04-24T15:36:27.903Z"},
+		Intervals:  []string{allTimeInterval},
 		Columns:    []string{"span"},
-		Filter:     godruid.FilterSelector("traceId", traceID.String()),
+		Filter:     filter,
 	}
-
-	err := r.client.Query(query)
-	if err != nil {
+	if err := r.client.Query(query); err != nil {
 		return nil, err
 	}
 
-	trace := &model.Trace{
-		Spans: []*model.Span{},
-	}
-
+	assembler := spans.NewTraceAssembler()
 	for _, results := range query.QueryResult {
 		for _, event := range results.Events {
-			spanb64 := event["span"].(string)
-			span, err := unmarshallSpan(spanb64)
+			spanb64, _ := event["span"].(string)
+			span, err := spans.Decode(spanb64)
 			if err != nil {
 				return nil, err
 			}
-			trace.Spans = append(trace.Spans, span)
+			assembler.Add(span)
 		}
 	}
-	return trace, nil
+	return assembler, nil
+}
 
+func (r *Reader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	tenantFilter, err := r.tenantFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assembler, err := r.scanTraces(godruid.FilterAnd(traceIDFilter(traceID), tenantFilter))
+	if err != nil {
+		return nil, err
+	}
+	if trace := assembler.Trace(traceID); trace != nil {
+		return trace, nil
+	}
+	return nil, ErrTraceNotFound
 }
 
-func (r *Reader) getDistinctQuery(dimension, name string) *godruid.QueryTopN {
-	return &godruid.QueryTopN{
-		DataSource: "jaeger-spans",
-		Dimension:  godruid.DimDefault(dimension, name),
-		Metric: &godruid.TopNMetric{
-			Type: "dimension",
-		},
-		Threshold:   100,
-		Intervals:   []string{"-146136543-09-08T08:23:32.096Z/146140482-04-24T15:36:27.903Z"},
-		Granularity: godruid.GranAll,
+// getDistinctQuery groups by the given dimensions over the configured lookback window, so every
+// distinct combination is returned instead of the first N values of a topN query.
+func (r *Reader) getDistinctQuery(filter *godruid.Filter, dimensions ...string) *godruid.QueryGroupBy {
+	dimSpecs := make([]godruid.DimSpec, len(dimensions))
+	for i, dimension := range dimensions {
+		dimSpecs[i] = godruid.DimDefault(dimension, dimension)
+	}
+	now := time.Now()
+	return &godruid.QueryGroupBy{
+		DataSource:   "jaeger-spans",
+		Dimensions:   dimSpecs,
+		Filter:       filter,
+		Aggregations: []godruid.Aggregation{},
+		Intervals:    []string{formatInterval(now.Add(-r.lookback), now)},
+		Granularity:  godruid.GranAll,
 	}
 }
 
 func (r *Reader) GetServices(ctx context.Context) ([]string, error) {
-	query := r.getDistinctQuery("process.serviceName", "serviceName")
-	err := r.client.Query(query)
+	tenant, err := r.tenants.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := tenant + "|" + servicesCacheKey
+	if cached, ok := r.cache.Get(cacheKey).([]string); ok {
+		return cached, nil
+	}
+	query := r.getDistinctQuery(tenantSelector(tenant), "process.serviceName")
+	err = r.client.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	final := make([]string, 0)
 
 	for _, res := range query.QueryResult {
-		for _, r := range res.Result {
-			value := r["serviceName"].(string)
-			if value != "" {
-				final = append(final, value)
-			}
+		value, _ := res.Event["process.serviceName"].(string)
+		if value != "" {
+			final = append(final, value)
 		}
-
 	}
+	r.cache.Put(cacheKey, final)
 	return final, nil
 
 }
 
+func buildOperationsFilter(query spanstore.OperationQueryParameters) *godruid.Filter {
+	filters := make([]*godruid.Filter, 0)
+	if query.ServiceName != "" {
+		filters = append(filters, godruid.FilterSelector("process.serviceName", query.ServiceName))
+	}
+	if query.SpanKind != "" {
+		filters = append(filters, godruid.FilterSelector("spanKind", query.SpanKind))
+	}
+	return godruid.FilterAnd(filters...)
+}
+
 func (r *Reader) GetOperations(ctx context.Context, traceQuery spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
-	query := r.getDistinctQuery("operationName", "operationName")
-	err := r.client.Query(query)
+	tenant, err := r.tenants.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := tenant + "|operations|" + traceQuery.ServiceName + "|" + traceQuery.SpanKind
+	if cached, ok := r.cache.Get(cacheKey).([]spanstore.Operation); ok {
+		return cached, nil
+	}
+	filter := godruid.FilterAnd(buildOperationsFilter(traceQuery), tenantSelector(tenant))
+	query := r.getDistinctQuery(filter, "operationName", "spanKind")
+	err = r.client.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	final := make([]spanstore.Operation, 0)
 
 	for _, res := range query.QueryResult {
-		for _, r := range res.Result {
-			value := r["operationName"].(string)
-			if value != "" {
-				final = append(final, spanstore.Operation{
-					Name: value,
-				})
-			}
+		name, _ := res.Event["operationName"].(string)
+		if name == "" {
+			continue
 		}
-
+		spanKind, _ := res.Event["spanKind"].(string)
+		final = append(final, spanstore.Operation{
+			Name:     name,
+			SpanKind: spanKind,
+		})
 	}
+	r.cache.Put(cacheKey, final)
 	return final, nil
 }
 
 func (r *Reader) FindTraces(ctx context.Context, traceQuery *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
+	if err := spans.ValidateQuery(traceQuery); err != nil {
+		return nil, err
+	}
+	tenantFilter, err := r.tenantFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	traceIds, err := r.getTraceIds(traceQuery)
+	traceIds, err := r.getTraceIds(traceQuery, tenantFilter)
 	if err != nil {
 		return nil, err
 	}
@@ -199,63 +348,32 @@ func (r *Reader) FindTraces(ctx context.Context, traceQuery *spanstore.TraceQuer
 	}
 
 	traceFilters := &godruid.Filter{
-		Type:"in",
-		Dimension:"traceId",
-		Values:traceIds,
-	}
-
-	query := &godruid.QueryScan{
-		DataSource: "jaeger-spans",
-		Intervals:  []string{"-146136543-09-08T08:23:32.096Z/146140482-04-24T15:36:27.903Z"},
-		Columns:    []string{"span"},
-		Filter:     traceFilters,
+		Type:      "in",
+		Dimension: "traceId",
+		Values:    traceIds,
 	}
-	err = r.client.Query(query)
-
+	assembler, err := r.scanTraces(godruid.FilterAnd(traceFilters, tenantFilter))
 	if err != nil {
 		return nil, err
 	}
-
-	tracesMap := make(map[string]*model.Trace)
-
-	for _, results := range query.QueryResult {
-		for _, event := range results.Events {
-			spanb64 := event["span"].(string)
-			span, err := unmarshallSpan(spanb64)
-			if err != nil {
-				return nil, err
-			}
-			strTraceId := span.TraceID.String()
-			trace, ok := tracesMap[strTraceId]
-			if !ok {
-				trace = &model.Trace{
-					Spans: []*model.Span{},
-				}
-				tracesMap[strTraceId] = trace
-			}
-			trace.Spans = append(trace.Spans, span)
-		}
-	}
-
-	traces := make([]*model.Trace, len(tracesMap))
-
-	count := 0
-	for _, trace := range tracesMap {
-		traces[count] = trace
-		count++
-	}
-	return traces, nil
-
+	return assembler.Traces(), nil
 }
 
 func (r *Reader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
-	ids, err := r.getTraceIds(query)
+	if err := spans.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	tenantFilter, err := r.tenantFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := r.getTraceIds(query, tenantFilter)
 	if err != nil {
 		return nil, err
 	}
 	traceIds := make([]model.TraceID, len(ids))
 	for i, id := range ids {
-		trid, err := model.TraceIDFromString(id)
+		trid, err := traceid.Parse(id)
 		if err != nil {
 			return nil, err
 		}
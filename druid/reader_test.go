@@ -0,0 +1,272 @@
+package druid
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/godruid"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+)
+
+func TestGetOperationsSpanKind(t *testing.T) {
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		return groupByRows(map[string]interface{}{"operationName": "get", "spanKind": "server"})
+	})
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations, err := reader.GetOperations(context.Background(), spanstore.OperationQueryParameters{
+		ServiceName: "frontend",
+		SpanKind:    "server",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []spanstore.Operation{{Name: "get", SpanKind: "server"}}; !reflect.DeepEqual(operations, expected) {
+		t.Errorf("operations are %v, expected %v", operations, expected)
+	}
+
+	queries := broker.recorded()
+	if len(queries) != 1 {
+		t.Fatalf("%d queries sent, expected 1", len(queries))
+	}
+	expected := asJSON(t, godruid.FilterAnd(
+		godruid.FilterSelector("process.serviceName", "frontend"),
+		godruid.FilterSelector("spanKind", "server"),
+	))
+	if filter := queries[0]["filter"]; !reflect.DeepEqual(filter, expected) {
+		t.Errorf("filter is %v, expected %v", filter, expected)
+	}
+	if dimensions := asJSON(t, queries[0]["dimensions"]); !reflect.DeepEqual(dimensions, asJSON(t, []godruid.DimSpec{
+		godruid.DimDefault("operationName", "operationName"),
+		godruid.DimDefault("spanKind", "spanKind"),
+	})) {
+		t.Errorf("dimensions are %v, expected operationName and spanKind", dimensions)
+	}
+}
+
+func TestGetOperationsWithoutSpanKind(t *testing.T) {
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		return groupByRows(
+			map[string]interface{}{"operationName": "get", "spanKind": "server"},
+			map[string]interface{}{"operationName": "get", "spanKind": "client"},
+		)
+	})
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations, err := reader.GetOperations(context.Background(), spanstore.OperationQueryParameters{ServiceName: "frontend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(operations) != 2 {
+		t.Errorf("operations are %v, expected one per span kind", operations)
+	}
+	expected := asJSON(t, godruid.FilterSelector("process.serviceName", "frontend"))
+	if filter := broker.recorded()[0]["filter"]; !reflect.DeepEqual(filter, expected) {
+		t.Errorf("filter is %v, expected %v", filter, expected)
+	}
+}
+
+func TestGetServicesTenantIsolation(t *testing.T) {
+	broker := newFakeBroker(t, func(query map[string]interface{}) interface{} {
+		return groupByRows(map[string]interface{}{"process.serviceName": "frontend"})
+	})
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{Options: spans.Options{Tenancy: true}, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reader.GetServices(context.Background()); err != tenancy.ErrMissingTenant {
+		t.Errorf("services read without tenant: %v", err)
+	}
+	for _, tenant := range []string{"acme", "umbrella", "acme"} {
+		if _, err := reader.GetServices(tenancy.WithTenant(context.Background(), tenant)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the services of a tenant are cached apart from the others
+	queries := broker.recorded()
+	if len(queries) != 2 {
+		t.Fatalf("%d queries sent, expected one per tenant", len(queries))
+	}
+	for i, tenant := range []string{"acme", "umbrella"} {
+		expected := asJSON(t, godruid.FilterSelector(tenantDimension, tenant))
+		if filter := queries[i]["filter"]; !reflect.DeepEqual(filter, expected) {
+			t.Errorf("filter is %v, expected %v", filter, expected)
+		}
+	}
+}
+
+func TestFindTracesTenantFilter(t *testing.T) {
+	broker := newFakeBroker(t, nil)
+	defer broker.Close()
+	reader, err := NewReader(broker.URL, Options{Options: spans.Options{Tenancy: true, DefaultTenant: "acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	_, err = reader.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: now.Add(-time.Hour),
+		StartTimeMax: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := asJSON(t, godruid.FilterAnd(
+		godruid.FilterSelector("process.serviceName", "frontend"),
+		godruid.FilterSelector(tenantDimension, "acme"),
+	))
+	if filter := broker.recorded()[0]["filter"]; !reflect.DeepEqual(filter, expected) {
+		t.Errorf("filter is %v, expected %v", filter, expected)
+	}
+}
+
+// splitTagsEvents are the rows of a trace whose two spans each carry one of the searched tags.
+var splitTagsEvents = []map[string]interface{}{
+	{"traceId": "000000000000000b", "process.serviceName": "frontend", tagDimension("http.method"): "GET"},
+	{"traceId": "000000000000000b", "process.serviceName": "frontend", tagDimension("error"): "true"},
+}
+
+// matchesFilter evaluates the selector, in, not, and & or filters of a decoded query over an event.
+func matchesFilter(t *testing.T, filter interface{}, event map[string]interface{}) bool {
+	if filter == nil {
+		return true
+	}
+	spec := filter.(map[string]interface{})
+	switch spec["type"] {
+	case "selector":
+		return event[spec["dimension"].(string)] == spec["value"]
+	case "in":
+		for _, value := range spec["values"].([]interface{}) {
+			if event[spec["dimension"].(string)] == value {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !matchesFilter(t, spec["field"], event)
+	case "and", "or":
+		for _, field := range spec["fields"].([]interface{}) {
+			if matchesFilter(t, field, event) != (spec["type"] == "and") {
+				return spec["type"] != "and"
+			}
+		}
+		return spec["type"] == "and"
+	}
+	t.Errorf("unsupported filter %v", spec)
+	return false
+}
+
+// matchesHaving evaluates the and & greaterThan having specs over the aggregated counts.
+func matchesHaving(t *testing.T, having interface{}, counts map[string]float64) bool {
+	if having == nil {
+		return true
+	}
+	spec := having.(map[string]interface{})
+	switch spec["type"] {
+	case "greaterThan":
+		return counts[spec["aggregation"].(string)] > spec["value"].(float64)
+	case "and":
+		for _, nested := range spec["havingSpecs"].([]interface{}) {
+			if !matchesHaving(t, nested, counts) {
+				return false
+			}
+		}
+		return true
+	}
+	t.Errorf("unsupported having %v", spec)
+	return false
+}
+
+// emulateSearch answers the topN and groupBy searches of trace IDs over the events.
+func emulateSearch(t *testing.T, events []map[string]interface{}) func(query map[string]interface{}) interface{} {
+	return func(query map[string]interface{}) interface{} {
+		var matching []map[string]interface{}
+		for _, event := range events {
+			if matchesFilter(t, query["filter"], event) {
+				matching = append(matching, event)
+			}
+		}
+		var traceIDs []string
+		byTrace := map[string][]map[string]interface{}{}
+		for _, event := range matching {
+			traceID := event["traceId"].(string)
+			if _, ok := byTrace[traceID]; !ok {
+				traceIDs = append(traceIDs, traceID)
+			}
+			byTrace[traceID] = append(byTrace[traceID], event)
+		}
+
+		switch query["queryType"] {
+		case "topN":
+			result := []interface{}{}
+			for _, traceID := range traceIDs {
+				result = append(result, map[string]interface{}{"traceId": traceID})
+			}
+			return []interface{}{map[string]interface{}{"timestamp": "2020-01-01T00:00:00.000Z", "result": result}}
+		case "groupBy":
+			var rows []map[string]interface{}
+			for _, traceID := range traceIDs {
+				counts := map[string]float64{}
+				for _, aggregation := range query["aggregations"].([]interface{}) {
+					spec := aggregation.(map[string]interface{})
+					name := spec["aggregator"].(map[string]interface{})["name"].(string)
+					for _, event := range byTrace[traceID] {
+						if matchesFilter(t, spec["filter"], event) {
+							counts[name]++
+						}
+					}
+				}
+				if matchesHaving(t, query["having"], counts) {
+					rows = append(rows, map[string]interface{}{"traceId": traceID})
+				}
+			}
+			return groupByRows(rows...)
+		}
+		t.Errorf("unexpected query %v", query)
+		return []interface{}{}
+	}
+}
+
+func TestFindTraceIDsTraceLevelMatching(t *testing.T) {
+	for _, traceLevel := range []bool{false, true} {
+		broker := newFakeBroker(t, emulateSearch(t, splitTagsEvents))
+		reader, err := NewReader(broker.URL, Options{Options: spans.Options{TraceLevelMatching: traceLevel}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		ids, err := reader.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{
+			ServiceName:  "frontend",
+			Tags:         map[string]string{"http.method": "GET", "error": "true"},
+			StartTimeMin: now.Add(-time.Hour),
+			StartTimeMax: now,
+		})
+		broker.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if traceLevel && (len(ids) != 1 || ids[0] != model.NewTraceID(0, 0xb)) {
+			t.Errorf("trace-level matching found %v, expected the trace", ids)
+		}
+		if !traceLevel && len(ids) != 0 {
+			t.Errorf("span-level matching found %v, expected none", ids)
+		}
+	}
+}
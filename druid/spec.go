@@ -0,0 +1,153 @@
+package druid
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rubenvp8510/jaeger-storages/traceid"
+)
+
+const (
+	spansDataSource  = "jaeger-spans"
+	rollupDataSource = "jaeger-spans-rollup"
+	allTimeInterval  = "-146136543-09-08T08:23:32.096Z/146140482-04-24T15:36:27.903Z"
+
+	// sketchSize is the k parameter of the latency quantiles sketch, higher values are more accurate
+	// but use more storage.
+	sketchSize = 128
+)
+
+// IngestionSpec returns the kafka supervisor spec that ingests raw spans from the topic into the spans
+// datasource, it can be submitted to the overlord /druid/indexer/v1/supervisor endpoint. Dimensions
+// are discovered, so every tag is ingested as a string dimension as soon as it is written. The
+// duration is ingested as a long column, range filters and the health checks expect a BIGINT.
+func IngestionSpec(options Options) map[string]interface{} {
+	return supervisorSpec(options, spansDataSource, map[string]interface{}{
+		"type":               "uniform",
+		"queryGranularity":   "HOUR",
+		"segmentGranularity": "HOUR",
+		"rollup":             false,
+	}, map[string]interface{}{
+		"dimensions":          []interface{}{},
+		"dimensionExclusions": []interface{}{"startTime", "duration"},
+	}, []interface{}{
+		map[string]interface{}{"name": "count", "type": "count"},
+		// spans aren't rolled up, the sum is the duration of the span
+		map[string]interface{}{"name": "duration", "type": "longSum", "fieldName": "duration"},
+	})
+}
+
+// RollupIngestionSpec returns the kafka supervisor spec that rolls up spans from the topic per
+// minute, service, operation and span kind. It feeds the Analytics queries.
+func RollupIngestionSpec(options Options) map[string]interface{} {
+	return supervisorSpec(options, rollupDataSource, map[string]interface{}{
+		"type":               "uniform",
+		"queryGranularity":   "MINUTE",
+		"segmentGranularity": "HOUR",
+		"rollup":             true,
+	}, map[string]interface{}{
+		"dimensions": []interface{}{
+			map[string]interface{}{"name": tenantDimension, "type": "string"},
+			map[string]interface{}{"name": "process.serviceName", "type": "string"},
+			map[string]interface{}{"name": "operationName", "type": "string"},
+			map[string]interface{}{"name": "spanKind", "type": "string"},
+		},
+	}, []interface{}{
+		map[string]interface{}{"name": "count", "type": "count"},
+		map[string]interface{}{
+			"type": "filtered",
+			"filter": map[string]interface{}{
+				"type":      "selector",
+				"dimension": tagDimension("error"),
+				"value":     "true",
+			},
+			"aggregator": map[string]interface{}{"name": "errors", "type": "count"},
+		},
+		map[string]interface{}{"name": "durationSum", "type": "longSum", "fieldName": "duration"},
+		map[string]interface{}{"name": "durationSketch", "type": "quantilesDoublesSketch", "fieldName": "duration", "k": sketchSize},
+	})
+}
+
+func supervisorSpec(options Options, dataSource string, granularity, dimensions map[string]interface{}, metrics []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "kafka",
+		"ioConfig": map[string]interface{}{
+			"type": "kafka",
+			"consumerProperties": map[string]interface{}{
+				"bootstrap.servers": strings.Join(options.Config.Brokers, ","),
+			},
+			"topic": options.Topic,
+			"inputFormat": map[string]interface{}{
+				"type": "json",
+			},
+			"useEarliestOffset": false,
+		},
+		"tuningConfig": map[string]interface{}{
+			"type":               "kafka",
+			"maxRowsPerSegment":  5000000,
+			"logParseExceptions": true,
+		},
+		"dataSchema": map[string]interface{}{
+			"dataSource":      dataSource,
+			"granularitySpec": granularity,
+			"timestampSpec": map[string]interface{}{
+				"column": "startTime",
+				"format": "iso",
+			},
+			"dimensionsSpec": dimensions,
+			"metricsSpec":    metrics,
+		},
+	}
+}
+
+// TraceIDReindexSpec returns the native batch task that rewrites the trace IDs of the spans datasource
+// in the canonical format, padding them to traceid.Length characters. Spans are read from the
+// datasource itself and the segments are replaced. It can be submitted to the overlord
+// /druid/indexer/v1/task endpoint.
+func TraceIDReindexSpec() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "index_parallel",
+		"spec": map[string]interface{}{
+			"dataSchema": map[string]interface{}{
+				"dataSource": spansDataSource,
+				"timestampSpec": map[string]interface{}{
+					"column": "__time",
+					"format": "millis",
+				},
+				// schemaless, every dimension of the existing segments is kept
+				"dimensionsSpec": map[string]interface{}{},
+				"metricsSpec": []interface{}{
+					map[string]interface{}{"name": "count", "type": "longSum", "fieldName": "count"},
+					map[string]interface{}{"name": "duration", "type": "longSum", "fieldName": "duration"},
+				},
+				"granularitySpec": map[string]interface{}{
+					"type":               "uniform",
+					"queryGranularity":   "HOUR",
+					"segmentGranularity": "HOUR",
+					"rollup":             false,
+				},
+				"transformSpec": map[string]interface{}{
+					"transforms": []interface{}{
+						map[string]interface{}{
+							"type":       "expression",
+							"name":       "traceId",
+							"expression": fmt.Sprintf("lpad(traceId, %d, '0')", traceid.Length),
+						},
+					},
+				},
+			},
+			"ioConfig": map[string]interface{}{
+				"type": "index_parallel",
+				"inputSource": map[string]interface{}{
+					"type":       "druid",
+					"dataSource": spansDataSource,
+					"interval":   allTimeInterval,
+				},
+				"appendToExisting": false,
+			},
+			"tuningConfig": map[string]interface{}{
+				"type": "index_parallel",
+			},
+		},
+	}
+}
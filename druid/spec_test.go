@@ -0,0 +1,136 @@
+package druid
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden specs")
+
+func specOptions() Options {
+	options := DefaultOptions()
+	options.Config.Brokers = []string{"kafka:9092"}
+	return options
+}
+
+// ingested returns the columns a spec stores out of the fields of a document.
+func ingested(spec map[string]interface{}, document map[string]interface{}) map[string]bool {
+	schema := spec["dataSchema"].(map[string]interface{})
+	dimensions := schema["dimensionsSpec"].(map[string]interface{})
+	columns := map[string]bool{"__time": true}
+	for _, metric := range schema["metricsSpec"].([]interface{}) {
+		columns[metric.(map[string]interface{})["name"].(string)] = true
+	}
+	if listed := dimensions["dimensions"].([]interface{}); len(listed) > 0 {
+		for _, dimension := range listed {
+			columns[dimension.(map[string]interface{})["name"].(string)] = true
+		}
+		return columns
+	}
+	// schemaless, every field but the timestamp and the exclusions is a dimension
+	excluded := map[string]bool{schema["timestampSpec"].(map[string]interface{})["column"].(string): true}
+	for _, exclusion := range dimensions["dimensionExclusions"].([]interface{}) {
+		excluded[exclusion.(string)] = true
+	}
+	for field := range document {
+		if !excluded[field] {
+			columns[field] = true
+		}
+	}
+	return columns
+}
+
+func TestIngestionSpecIngestsQueriedColumns(t *testing.T) {
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 1),
+		SpanID:        model.NewSpanID(1),
+		OperationName: "get",
+		StartTime:     time.Now(),
+		Duration:      time.Millisecond,
+		Process:       model.NewProcess("frontend", nil),
+		Tags:          []model.KeyValue{model.String("http.method", "GET"), model.Bool("error", true)},
+	}
+	body, err := (&DruidMarshall{}).Marshal(span, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := IngestionSpec(specOptions())
+	columns := ingested(spec, document)
+	expected := []string{tenantDimension, tagDimension("http.method"), tagDimension("error")}
+	for column := range spansColumns {
+		expected = append(expected, column)
+	}
+	for _, column := range expected {
+		if !columns[column] {
+			t.Errorf("%s isn't ingested", column)
+		}
+	}
+	// a discovered duration would be a string dimension
+	metrics := spec["dataSchema"].(map[string]interface{})["metricsSpec"].([]interface{})
+	if duration := metrics[len(metrics)-1].(map[string]interface{}); duration["name"] != "duration" || duration["type"] != "longSum" {
+		t.Errorf("duration is ingested as %v, expected a long column", duration)
+	}
+}
+
+func TestSpecs(t *testing.T) {
+	specs := map[string]map[string]interface{}{
+		"ingestion-spec.json": IngestionSpec(specOptions()),
+		"rollup-spec.json":    RollupIngestionSpec(specOptions()),
+		"reindex-spec.json":   TraceIDReindexSpec(),
+	}
+	for name, spec := range specs {
+		body, err := json.MarshalIndent(spec, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join("testdata", name)
+		if *update {
+			if err := ioutil.WriteFile(golden, append(body, '\n'), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(expected) != string(body)+"\n" {
+			t.Errorf("%s differs from the spec, run go test -update to rewrite it:\n%s", golden, body)
+		}
+	}
+}
+
+func TestTraceIDReindexSpec(t *testing.T) {
+	spec := TraceIDReindexSpec()["spec"].(map[string]interface{})
+	schema := spec["dataSchema"].(map[string]interface{})
+	source := spec["ioConfig"].(map[string]interface{})["inputSource"].(map[string]interface{})
+	// the datasource is rewritten in place
+	if schema["dataSource"] != spansDataSource || source["dataSource"] != spansDataSource {
+		t.Errorf("%v is reindexed into %v, expected the spans datasource", source["dataSource"], schema["dataSource"])
+	}
+	if spec["ioConfig"].(map[string]interface{})["appendToExisting"] != false {
+		t.Error("reindexed segments are appended to the existing ones")
+	}
+	transform := schema["transformSpec"].(map[string]interface{})["transforms"].([]interface{})[0].(map[string]interface{})
+	if transform["name"] != "traceId" || transform["expression"] != "lpad(traceId, 32, '0')" {
+		t.Errorf("transform is %v, expected the trace ID padded to the canonical length", transform)
+	}
+	// the columns the readers query keep their type
+	metrics := map[string]bool{}
+	for _, metric := range schema["metricsSpec"].([]interface{}) {
+		metrics[metric.(map[string]interface{})["name"].(string)] = true
+	}
+	if !metrics["count"] || !metrics["duration"] {
+		t.Errorf("metrics are %v, expected count and duration", metrics)
+	}
+}
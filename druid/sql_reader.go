@@ -0,0 +1,447 @@
+package druid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/pkg/cache"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/rubenvp8510/jaeger-storages/traceid"
+)
+
+const (
+	sqlEndpoint = "/druid/v2/sql"
+
+	sqlVarchar = "VARCHAR"
+	sqlBigint  = "BIGINT"
+	sqlDouble  = "DOUBLE"
+)
+
+// sqlParameter is a dynamic parameter of a druid SQL query, bound to a ? placeholder.
+type sqlParameter struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// sqlCondition is a SQL boolean expression along with the parameters of its placeholders, in order.
+type sqlCondition struct {
+	expression string
+	parameters []sqlParameter
+}
+
+func varchar(value string) sqlParameter {
+	return sqlParameter{Type: sqlVarchar, Value: value}
+}
+
+func bigint(value int64) sqlParameter {
+	return sqlParameter{Type: sqlBigint, Value: value}
+}
+
+// double binds a numeric bound, values that are not numbers are left for druid to reject.
+func double(value string) sqlParameter {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return sqlParameter{Type: sqlDouble, Value: number}
+	}
+	return sqlParameter{Type: sqlDouble, Value: value}
+}
+
+// quoteIdentifier quotes a column name, tag columns contain dots and any character of the tag key.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// joinConditions joins the conditions with the operator, merging their parameters in order.
+func joinConditions(operator string, conditions ...sqlCondition) sqlCondition {
+	expressions := make([]string, 0, len(conditions))
+	var parameters []sqlParameter
+	for _, condition := range conditions {
+		if condition.expression == "" {
+			continue
+		}
+		expressions = append(expressions, "("+condition.expression+")")
+		parameters = append(parameters, condition.parameters...)
+	}
+	return sqlCondition{
+		expression: strings.Join(expressions, " "+operator+" "),
+		parameters: parameters,
+	}
+}
+
+func sqlTagPredicate(key, value string, extended bool) sqlCondition {
+	column := quoteIdentifier(tagDimension(key))
+	if !extended {
+		return sqlCondition{column + " = ?", []sqlParameter{varchar(value)}}
+	}
+	switch {
+	case strings.HasPrefix(value, notEqualPrefix):
+		return sqlCondition{column + " <> ?", []sqlParameter{varchar(strings.TrimPrefix(value, notEqualPrefix))}}
+	case strings.HasPrefix(value, regexPrefix):
+		return sqlCondition{"REGEXP_LIKE(" + column + ", ?)", []sqlParameter{varchar(strings.TrimPrefix(value, regexPrefix))}}
+	case strings.HasSuffix(value, prefixWildcard):
+		escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+		pattern := escaper.Replace(strings.TrimSuffix(value, prefixWildcard)) + "%"
+		return sqlCondition{column + ` LIKE ? ESCAPE '\'`, []sqlParameter{varchar(pattern)}}
+	case strings.Contains(value, rangeSeparator):
+		bounds := strings.SplitN(value, rangeSeparator, 2)
+		var conditions []sqlCondition
+		if min := strings.TrimSpace(bounds[0]); min != "" {
+			conditions = append(conditions, sqlCondition{"CAST(" + column + " AS DOUBLE) >= ?", []sqlParameter{double(min)}})
+		}
+		if max := strings.TrimSpace(bounds[1]); max != "" {
+			conditions = append(conditions, sqlCondition{"CAST(" + column + " AS DOUBLE) <= ?", []sqlParameter{double(max)}})
+		}
+		return joinConditions("AND", conditions...)
+	}
+	return sqlCondition{column + " = ?", []sqlParameter{varchar(value)}}
+}
+
+// sqlPredicates returns one condition per search criteria, as buildPredicates does for native queries.
+func sqlPredicates(query *spanstore.TraceQueryParameters, extendedTags bool) []sqlCondition {
+	predicates := make([]sqlCondition, 0)
+	var durationConditions []sqlCondition
+	if query.DurationMin != 0 {
+		durationConditions = append(durationConditions, sqlCondition{`"duration" >= ?`, []sqlParameter{bigint(query.DurationMin.Microseconds())}})
+	}
+	if query.DurationMax != 0 {
+		durationConditions = append(durationConditions, sqlCondition{`"duration" <= ?`, []sqlParameter{bigint(query.DurationMax.Microseconds())}})
+	}
+	if duration := joinConditions("AND", durationConditions...); duration.expression != "" {
+		predicates = append(predicates, duration)
+	}
+
+	var serviceConditions []sqlCondition
+	if query.ServiceName != "" {
+		serviceConditions = append(serviceConditions, sqlCondition{`"process.serviceName" = ?`, []sqlParameter{varchar(query.ServiceName)}})
+	}
+	if query.OperationName != "" {
+		serviceConditions = append(serviceConditions, sqlCondition{`"operationName" = ?`, []sqlParameter{varchar(query.OperationName)}})
+	}
+	if service := joinConditions("AND", serviceConditions...); service.expression != "" {
+		predicates = append(predicates, service)
+	}
+
+	for k, v := range query.Tags {
+		predicates = append(predicates, sqlTagPredicate(k, v, extendedTags))
+	}
+	return predicates
+}
+
+// traceIDCondition matches the spans of any of the trace IDs.
+func traceIDCondition(traceIDs ...string) sqlCondition {
+	placeholders := make([]string, len(traceIDs))
+	parameters := make([]sqlParameter, len(traceIDs))
+	for i, traceID := range traceIDs {
+		placeholders[i] = "?"
+		parameters[i] = varchar(traceID)
+	}
+	return sqlCondition{`"traceId" IN (` + strings.Join(placeholders, ", ") + ")", parameters}
+}
+
+func timeRange(start, end time.Time) sqlCondition {
+	return sqlCondition{
+		"__time >= MILLIS_TO_TIMESTAMP(?) AND __time <= MILLIS_TO_TIMESTAMP(?)",
+		[]sqlParameter{bigint(start.UnixNano() / int64(time.Millisecond)), bigint(end.UnixNano() / int64(time.Millisecond))},
+	}
+}
+
+// SQLReader is a span reader that queries druid through its SQL API instead of native queries.
+type SQLReader struct {
+	url          string
+	client       *http.Client
+	lookback     time.Duration
+	cache        cache.Cache
+	extendedTags bool
+	traceLevel   bool
+	tenants      tenancy.Resolver
+	// tracePadding widens the searched window when the spans of the traces found are fetched, they
+	// may have started before or after the span that matched. Zero doesn't bound them in time.
+	tracePadding time.Duration
+}
+
+func NewSQLReader(host string, options Options) (*SQLReader, error) {
+	return &SQLReader{
+		url: host,
+		client: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		lookback:     options.Lookback,
+		extendedTags: options.ExtendedTagPredicates,
+		traceLevel:   options.TraceLevelMatching,
+		tenants:      options.Tenants(),
+		tracePadding: options.TraceWindowPadding,
+		cache: cache.NewLRUWithOptions(distinctCacheSize, &cache.Options{
+			TTL: options.CacheTTL,
+		}),
+	}, nil
+}
+
+// tenantCondition returns the condition that restricts a query to the tenant of ctx, empty when tenancy is disabled.
+func (r *SQLReader) tenantCondition(ctx context.Context) (sqlCondition, error) {
+	tenant, err := r.tenants.Read(ctx)
+	return tenantEquals(tenant), err
+}
+
+func tenantEquals(tenant string) sqlCondition {
+	if tenant == "" {
+		return sqlCondition{}
+	}
+	return sqlCondition{quoteIdentifier(tenantDimension) + " = ?", []sqlParameter{varchar(tenant)}}
+}
+
+// query runs the statement followed by the where condition and the suffix, returning a row per object.
+func (r *SQLReader) query(ctx context.Context, statement string, where sqlCondition, suffix string, suffixParameters ...sqlParameter) ([]map[string]interface{}, error) {
+	query := statement
+	if where.expression != "" {
+		query += " WHERE " + where.expression
+	}
+	query += suffix
+	payload, err := json.Marshal(map[string]interface{}{
+		"query":        query,
+		"parameters":   append(append([]sqlParameter{}, where.parameters...), suffixParameters...),
+		"resultFormat": "object",
+		"context": map[string]interface{}{
+			"sqlTimeZone": "Etc/UTC",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.url+sqlEndpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, string(body))
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// scanTraces assembles the traces of the spans matching the condition.
+func (r *SQLReader) scanTraces(ctx context.Context, where sqlCondition) (*spans.TraceAssembler, error) {
+	rows, err := r.query(ctx, fmt.Sprintf("SELECT %s FROM %s", quoteIdentifier("span"), quoteIdentifier(spansDataSource)), where, "")
+	if err != nil {
+		return nil, err
+	}
+	assembler := spans.NewTraceAssembler()
+	for _, row := range rows {
+		spanb64, _ := row["span"].(string)
+		span, err := spans.Decode(spanb64)
+		if err != nil {
+			return nil, err
+		}
+		assembler.Add(span)
+	}
+	return assembler, nil
+}
+
+func (r *SQLReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	tenantCondition, err := r.tenantCondition(ctx)
+	if err != nil {
+		return nil, err
+	}
+	where := joinConditions("AND",
+		traceIDCondition(traceid.Forms(traceID)...),
+		tenantCondition,
+	)
+	assembler, err := r.scanTraces(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+	if trace := assembler.Trace(traceID); trace != nil {
+		return trace, nil
+	}
+	return nil, ErrTraceNotFound
+}
+
+// distinct returns the distinct values of the columns over the lookback window.
+func (r *SQLReader) distinct(ctx context.Context, where sqlCondition, columns ...string) ([]map[string]interface{}, error) {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteIdentifier(column)
+	}
+	now := time.Now()
+	where = joinConditions("AND", timeRange(now.Add(-r.lookback), now), where)
+	statement := fmt.Sprintf("SELECT DISTINCT %s FROM %s", strings.Join(quoted, ", "), quoteIdentifier(spansDataSource))
+	return r.query(ctx, statement, where, "")
+}
+
+func (r *SQLReader) GetServices(ctx context.Context) ([]string, error) {
+	tenant, err := r.tenants.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := tenant + "|" + servicesCacheKey
+	if cached, ok := r.cache.Get(cacheKey).([]string); ok {
+		return cached, nil
+	}
+	rows, err := r.distinct(ctx, tenantEquals(tenant), "process.serviceName")
+	if err != nil {
+		return nil, err
+	}
+	services := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if service, _ := row["process.serviceName"].(string); service != "" {
+			services = append(services, service)
+		}
+	}
+	r.cache.Put(cacheKey, services)
+	return services, nil
+}
+
+func (r *SQLReader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
+	tenant, err := r.tenants.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := tenant + "|operations|" + query.ServiceName + "|" + query.SpanKind
+	if cached, ok := r.cache.Get(cacheKey).([]spanstore.Operation); ok {
+		return cached, nil
+	}
+	conditions := []sqlCondition{tenantEquals(tenant)}
+	if query.ServiceName != "" {
+		conditions = append(conditions, sqlCondition{`"process.serviceName" = ?`, []sqlParameter{varchar(query.ServiceName)}})
+	}
+	if query.SpanKind != "" {
+		conditions = append(conditions, sqlCondition{`"spanKind" = ?`, []sqlParameter{varchar(query.SpanKind)}})
+	}
+	rows, err := r.distinct(ctx, joinConditions("AND", conditions...), "operationName", "spanKind")
+	if err != nil {
+		return nil, err
+	}
+	operations := make([]spanstore.Operation, 0, len(rows))
+	for _, row := range rows {
+		name, _ := row["operationName"].(string)
+		if name == "" {
+			continue
+		}
+		spanKind, _ := row["spanKind"].(string)
+		operations = append(operations, spanstore.Operation{
+			Name:     name,
+			SpanKind: spanKind,
+		})
+	}
+	r.cache.Put(cacheKey, operations)
+	return operations, nil
+}
+
+// traceIDs returns the IDs of the traces matching the query, most recent first. With trace level
+// matching each criteria is counted per trace and may be satisfied by a different span. The spans of
+// a trace may be stored with different forms of its ID, they are grouped by the canonical form so
+// the limit counts traces rather than forms.
+func (r *SQLReader) traceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	if err := spans.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	tenantCondition, err := r.tenantCondition(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limit := spans.NumTraces(query)
+
+	predicates := sqlPredicates(query, r.extendedTags)
+	where := joinConditions("AND", timeRange(query.StartTimeMin, query.StartTimeMax), tenantCondition)
+	having := ""
+	var havingParameters []sqlParameter
+	if r.traceLevel && len(predicates) > 0 {
+		where = joinConditions("AND", where, joinConditions("OR", predicates...))
+		matches := make([]string, len(predicates))
+		for i, predicate := range predicates {
+			matches[i] = fmt.Sprintf("SUM(CASE WHEN %s THEN 1 ELSE 0 END) > 0", predicate.expression)
+			havingParameters = append(havingParameters, predicate.parameters...)
+		}
+		having = " HAVING " + strings.Join(matches, " AND ")
+	} else {
+		where = joinConditions("AND", append([]sqlCondition{where}, predicates...)...)
+	}
+
+	canonical := fmt.Sprintf("LPAD(%s, %d, '0')", quoteIdentifier("traceId"), traceid.Length)
+	statement := fmt.Sprintf("SELECT %s AS %s FROM %s", canonical, quoteIdentifier("traceId"), quoteIdentifier(spansDataSource))
+	suffix := fmt.Sprintf(" GROUP BY %s%s ORDER BY MAX(__time) DESC LIMIT ?", canonical, having)
+	rows, err := r.query(ctx, statement, where, suffix, append(havingParameters, bigint(int64(limit)))...)
+	if err != nil {
+		return nil, err
+	}
+	traceIDs := make([]model.TraceID, 0, len(rows))
+	found := make(map[model.TraceID]bool, len(rows))
+	for _, row := range rows {
+		id, _ := row["traceId"].(string)
+		if id == "" {
+			continue
+		}
+		traceID, err := traceid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		if !found[traceID] {
+			found[traceID] = true
+			traceIDs = append(traceIDs, traceID)
+		}
+	}
+	return traceIDs, nil
+}
+
+func (r *SQLReader) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
+	tenantCondition, err := r.tenantCondition(ctx)
+	if err != nil {
+		return nil, err
+	}
+	traceIDs, err := r.traceIDs(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(traceIDs) == 0 {
+		return nil, ErrTraceNotFound
+	}
+
+	var forms []string
+	for _, traceID := range traceIDs {
+		forms = append(forms, traceid.Forms(traceID)...)
+	}
+	// the spans of the traces found are searched around the queried window rather than in every segment
+	var window sqlCondition
+	if r.tracePadding > 0 {
+		window = timeRange(query.StartTimeMin.Add(-r.tracePadding), query.StartTimeMax.Add(r.tracePadding))
+	}
+	where := joinConditions("AND", window, traceIDCondition(forms...), tenantCondition)
+	assembler, err := r.scanTraces(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the recency order of the trace IDs
+	traces := make([]*model.Trace, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		if trace := assembler.Trace(traceID); trace != nil {
+			traces = append(traces, trace)
+		}
+	}
+	return traces, nil
+}
+
+func (r *SQLReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	return r.traceIDs(ctx, query)
+}
@@ -0,0 +1,238 @@
+package druid
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/traceid"
+)
+
+// sqlRows answers the SQL queries of the trace IDs with ids and the other queries with the spans
+func sqlRows(t *testing.T, ids []string, stored ...*model.Span) func(query map[string]interface{}) interface{} {
+	return func(query map[string]interface{}) interface{} {
+		statement, _ := query["query"].(string)
+		rows := []map[string]interface{}{}
+		if strings.Contains(statement, "GROUP BY") {
+			for _, id := range ids {
+				rows = append(rows, map[string]interface{}{"traceId": id})
+			}
+			return rows
+		}
+		for _, span := range stored {
+			blob, err := (*spans.Codec)(nil).Encode(span)
+			if err != nil {
+				t.Error(err)
+			}
+			rows = append(rows, map[string]interface{}{"span": blob})
+		}
+		return rows
+	}
+}
+
+func sqlSpan(traceID model.TraceID, spanID uint64) *model.Span {
+	return &model.Span{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(spanID),
+		OperationName: "get",
+		StartTime:     time.Now(),
+		Process:       model.NewProcess("frontend", nil),
+	}
+}
+
+func TestSQLFindTracesDeduplicatesTraceIDForms(t *testing.T) {
+	first := model.NewTraceID(0, 0xa)
+	second := model.NewTraceID(1, 0xb)
+	// the spans of the first trace were written with the legacy and the canonical form of its ID
+	ids := []string{traceid.Canonical(first), first.String(), traceid.Canonical(second)}
+	broker := newFakeBroker(t, sqlRows(t, ids, sqlSpan(first, 1), sqlSpan(first, 2), sqlSpan(second, 3)))
+	defer broker.Close()
+	reader, err := NewSQLReader(broker.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: now.Add(-time.Hour),
+		StartTimeMax: now,
+	}
+
+	traceIDs, err := reader.FindTraceIDs(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traceIDs) != 2 || traceIDs[0] != first || traceIDs[1] != second {
+		t.Errorf("trace IDs are %v, expected %v and %v once", traceIDs, first, second)
+	}
+
+	traces, err := reader.FindTraces(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 2 || len(traces[0].Spans) != 2 || len(traces[1].Spans) != 1 {
+		t.Fatalf("traces are %v, expected the first with 2 spans then the second", traces)
+	}
+	if traces[0].Spans[0].TraceID != first || traces[1].Spans[0].TraceID != second {
+		t.Errorf("traces aren't in the order of the trace IDs")
+	}
+}
+
+func TestSQLFindTracesTimeBound(t *testing.T) {
+	traceID := model.NewTraceID(0, 0xa)
+	start := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: start,
+		StartTimeMax: end,
+	}
+	millis := func(at time.Time) float64 {
+		return float64(at.UnixNano() / int64(time.Millisecond))
+	}
+
+	for _, padding := range []time.Duration{0, 3 * time.Hour} {
+		broker := newFakeBroker(t, sqlRows(t, []string{traceid.Canonical(traceID)}, sqlSpan(traceID, 1)))
+		reader, err := NewSQLReader(broker.URL, Options{TraceWindowPadding: padding})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = reader.FindTraces(context.Background(), query)
+		broker.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		queries := broker.recorded()
+		if len(queries) != 2 {
+			t.Fatalf("%d queries sent, expected the trace IDs and the spans", len(queries))
+		}
+		scan := queries[1]
+		bounded := strings.Contains(scan["query"].(string), "__time >= MILLIS_TO_TIMESTAMP(?) AND __time <= MILLIS_TO_TIMESTAMP(?)")
+		if padding == 0 {
+			// long traces are fetched whole
+			if bounded {
+				t.Errorf("spans query %q is bound in time without padding", scan["query"])
+			}
+			continue
+		}
+		if !bounded {
+			t.Fatalf("spans query %q isn't bound in time", scan["query"])
+		}
+		parameters := scan["parameters"].([]interface{})
+		expected := []float64{millis(start.Add(-padding)), millis(end.Add(padding))}
+		for i, bound := range expected {
+			if value := parameters[i].(map[string]interface{})["value"]; value != bound {
+				t.Errorf("bound %d is %v, expected %v", i, value, bound)
+			}
+		}
+	}
+}
+
+func TestSQLTraceIDsGroupedByCanonicalForm(t *testing.T) {
+	broker := newFakeBroker(t, sqlRows(t, nil))
+	defer broker.Close()
+	reader, err := NewSQLReader(broker.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := reader.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: now.Add(-time.Hour),
+		StartTimeMax: now,
+		NumTraces:    3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// the legacy and the canonical forms of a trace are a single group, counted once by the limit
+	statement := broker.recorded()[0]["query"].(string)
+	canonical := `LPAD("traceId", 32, '0')`
+	if !strings.HasPrefix(statement, "SELECT "+canonical+` AS "traceId"`) || !strings.Contains(statement, " GROUP BY "+canonical+" ") {
+		t.Errorf("trace IDs query %q isn't grouped by the canonical form", statement)
+	}
+}
+
+func TestSQLFindTracesBindsParameters(t *testing.T) {
+	broker := newFakeBroker(t, sqlRows(t, nil))
+	defer broker.Close()
+	reader, err := NewSQLReader(broker.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	_, err = reader.FindTraces(context.Background(), &spanstore.TraceQueryParameters{
+		ServiceName:  "front'end",
+		Tags:         map[string]string{`http"url`: "/a?b=1;c"},
+		StartTimeMin: now.Add(-time.Hour),
+		StartTimeMax: now,
+		NumTraces:    5,
+	})
+	if err != ErrTraceNotFound {
+		t.Fatalf("no trace found returned %v", err)
+	}
+	query := broker.recorded()[0]
+	statement := query["query"].(string)
+	if strings.Contains(statement, "front'end") || strings.Contains(statement, "/a?b=1;c") {
+		t.Errorf("values are inlined in %q", statement)
+	}
+	if !strings.Contains(statement, quoteIdentifier(tagDimension(`http"url`))) {
+		t.Errorf("tag column isn't quoted in %q", statement)
+	}
+	var values []interface{}
+	for _, parameter := range query["parameters"].([]interface{}) {
+		values = append(values, parameter.(map[string]interface{})["value"])
+	}
+	if len(values) != 5 || values[2] != "front'end" || values[3] != "/a?b=1;c" || values[4] != float64(5) {
+		t.Errorf("parameters are %v, expected the window, the service, the tag and the limit", values)
+	}
+}
+
+func TestSQLTraceLevelMatching(t *testing.T) {
+	methodColumn := quoteIdentifier(tagDimension("http.method"))
+	errorColumn := quoteIdentifier(tagDimension("error"))
+	for _, traceLevel := range []bool{false, true} {
+		broker := newFakeBroker(t, sqlRows(t, nil))
+		reader, err := NewSQLReader(broker.URL, Options{Options: spans.Options{TraceLevelMatching: traceLevel}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		_, err = reader.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{
+			Tags:         map[string]string{"http.method": "GET", "error": "true"},
+			StartTimeMin: now.Add(-time.Hour),
+			StartTimeMax: now,
+		})
+		broker.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		statement := broker.recorded()[0]["query"].(string)
+		where := statement[strings.Index(statement, " WHERE "):strings.Index(statement, " GROUP BY ")]
+
+		if !traceLevel {
+			// both tags must be carried by the same span
+			if strings.Contains(statement, "HAVING") || strings.Contains(where, " OR ") {
+				t.Errorf("span-level matching searched with %q", statement)
+			}
+			if !strings.Contains(where, methodColumn) || !strings.Contains(where, errorColumn) {
+				t.Errorf("search %q doesn't filter the spans by every tag", statement)
+			}
+			continue
+		}
+		// a span carrying either tag is kept, every tag must be carried by some span of the trace
+		if !strings.Contains(where, " OR ") {
+			t.Errorf("search %q filters the spans by every tag", statement)
+		}
+		having := statement[strings.Index(statement, " HAVING "):]
+		for _, column := range []string{methodColumn, errorColumn} {
+			if !strings.Contains(having, "SUM(CASE WHEN "+column+" = ? THEN 1 ELSE 0 END) > 0") {
+				t.Errorf("search %q doesn't count the spans carrying %s", statement, column)
+			}
+		}
+	}
+}
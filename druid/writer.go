@@ -1,47 +1,113 @@
 package druid
 
 import (
+	"context"
+	"fmt"
+	"sync"
+
 	"github.com/Shopify/sarama"
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/rubenvp8510/jaeger-storages/traceid"
 )
 
 type SpanWriter struct {
 	producer   sarama.AsyncProducer
 	topic      string
 	marshaller DruidMarshall
+	tenants    tenancy.Resolver
+
+	// inFlight is the number of spans sent and not acknowledged yet, failed the number of spans
+	// kafka rejected since the last Flush and sendErr the first of their errors. All of them are
+	// guarded by mtx, acked is signaled on every acknowledgment.
+	mtx      sync.Mutex
+	acked    *sync.Cond
+	inFlight int
+	failed   int
+	sendErr  error
 }
-// NewSpanWriter initiates and returns a new kafka spanwriter
-func NewSpanWriter(producer sarama.AsyncProducer, topic string) *SpanWriter {
+
+// NewSpanWriter initiates and returns a new kafka spanwriter, it must be the only consumer of the
+// successes and errors of the producer, which must return successes.
+func NewSpanWriter(producer sarama.AsyncProducer, topic string, tenants tenancy.Resolver, codec *spans.Codec) *SpanWriter {
+	writer := &SpanWriter{
+		producer:   producer,
+		topic:      topic,
+		tenants:    tenants,
+		marshaller: DruidMarshall{codec: codec},
+	}
+	writer.acked = sync.NewCond(&writer.mtx)
 	go func() {
 		for range producer.Successes() {
+			writer.acknowledge(nil)
 		}
 	}()
 	go func() {
 		for e := range producer.Errors() {
 			println(e)
+			writer.acknowledge(e.Err)
 		}
 	}()
-	return &SpanWriter{
-		producer: producer,
-		topic:    topic,
+	return writer
+}
+
+func (w *SpanWriter) acknowledge(err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.inFlight--
+	if err != nil {
+		w.failed++
+		if w.sendErr == nil {
+			w.sendErr = err
+		}
+	}
+	w.acked.Broadcast()
+}
+
+// Flush waits until kafka acknowledged the spans written so far, it returns an error when some of
+// the spans written since the last Flush were rejected.
+func (w *SpanWriter) Flush() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for w.inFlight > 0 {
+		w.acked.Wait()
+	}
+	failed, err := w.failed, w.sendErr
+	w.failed, w.sendErr = 0, nil
+	if failed > 0 {
+		return fmt.Errorf("%d spans were not written to kafka: %w", failed, err)
 	}
+	return nil
 }
 
 // WriteSpan writes the span to kafka.
 func (w *SpanWriter) WriteSpan(span *model.Span) error {
+	return w.WriteSpanContext(context.Background(), span)
+}
+
+// WriteSpanContext writes the span to kafka, on behalf of its tenant when tenancy is enabled.
+func (w *SpanWriter) WriteSpanContext(ctx context.Context, span *model.Span) error {
+	tenant, err := w.tenants.Write(ctx, span)
+	if err != nil {
+		return err
+	}
 	// Need to normalize the span,
-	spanBytes, err := w.marshaller.Marshal(span)
+	spanBytes, err := w.marshaller.Marshal(span, tenant)
 
 	if err != nil {
 		return err
 	}
 
 
+	w.mtx.Lock()
+	w.inFlight++
+	w.mtx.Unlock()
 	// The AsyncProducer accepts messages on a channel and produces them asynchronously
 	// in the background as efficiently as possible
 	w.producer.Input() <- &sarama.ProducerMessage{
 		Topic: w.topic,
-		Key:   sarama.StringEncoder(span.TraceID.String()),
+		Key:   sarama.StringEncoder(traceid.Canonical(span.TraceID)),
 		Value: sarama.ByteEncoder(spanBytes),
 	}
 	return nil
@@ -0,0 +1,90 @@
+package druid
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+)
+
+func newMockProducer(t *testing.T) *mocks.AsyncProducer {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	return mocks.NewAsyncProducer(t, config)
+}
+
+func writerSpan(id uint64) *model.Span {
+	return &model.Span{
+		TraceID:       model.NewTraceID(0, id),
+		SpanID:        model.NewSpanID(id),
+		OperationName: "get",
+		StartTime:     time.Now(),
+		Process:       model.NewProcess("frontend", nil),
+	}
+}
+
+func TestFlushWaitsForAcknowledgments(t *testing.T) {
+	producer := newMockProducer(t)
+	writer := NewSpanWriter(producer, "jaeger-spans", tenancy.Resolver{}, nil)
+	defer writer.Close()
+
+	for i := uint64(1); i <= 3; i++ {
+		producer.ExpectInputAndSucceed()
+		if err := writer.WriteSpan(writerSpan(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	writer.mtx.Lock()
+	defer writer.mtx.Unlock()
+	if writer.inFlight != 0 {
+		t.Errorf("%d spans in flight after the flush", writer.inFlight)
+	}
+}
+
+func TestFlushReportsRejectedSpans(t *testing.T) {
+	producer := newMockProducer(t)
+	writer := NewSpanWriter(producer, "jaeger-spans", tenancy.Resolver{}, nil)
+	defer writer.Close()
+
+	rejected := errors.New("message too large")
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(rejected)
+	producer.ExpectInputAndFail(rejected)
+	for i := uint64(1); i <= 3; i++ {
+		if err := writer.WriteSpan(writerSpan(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Flush()
+	if !errors.Is(err, rejected) {
+		t.Fatalf("flush returned %v, expected the rejection", err)
+	}
+	if expected := "2 spans were not written to kafka: message too large"; err.Error() != expected {
+		t.Errorf("flush returned %q, expected %q", err, expected)
+	}
+
+	// the rejections are reported once
+	producer.ExpectInputAndSucceed()
+	if err := writer.WriteSpan(writerSpan(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Errorf("second flush returned %v", err)
+	}
+}
+
+func TestFlushWithoutSpans(t *testing.T) {
+	producer := newMockProducer(t)
+	writer := NewSpanWriter(producer, "jaeger-spans", tenancy.Resolver{}, nil)
+	defer writer.Close()
+	if err := writer.Flush(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,140 @@
+package fanout
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/jaegertracing/jaeger/pkg/multierror"
+	"github.com/jaegertracing/jaeger/storage/dependencystore"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/jaeger-storages/druid"
+	"github.com/rubenvp8510/jaeger-storages/questbd"
+	"github.com/spf13/viper"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+// StorageFactory is the storage factory implemented by every backend
+type StorageFactory interface {
+	AddFlags(flagSet *flag.FlagSet)
+	InitFromViper(v *viper.Viper)
+	Initialize(metricsFactory metrics.Factory, logger *zap.Logger) error
+	CreateSpanReader() (spanstore.Reader, error)
+	CreateSpanWriter() (spanstore.Writer, error)
+	CreateDependencyReader() (dependencystore.Reader, error)
+	Close() error
+}
+
+// Factory writes spans to several backends and serves reads from the primary one
+type Factory struct {
+	options        Options
+	factories      map[string]StorageFactory
+	metricsFactory metrics.Factory
+	logger         *zap.Logger
+}
+
+func NewFactory() *Factory {
+	return &Factory{
+		factories: map[string]StorageFactory{
+			druidBackend:   druid.NewFactory(),
+			questdbBackend: questbd.NewFactory(),
+		},
+	}
+}
+
+// AddFlags adds the flags of the fan-out and of every backend
+func (f *Factory) AddFlags(flagSet *flag.FlagSet) {
+	f.options.AddFlags(flagSet)
+	for _, factory := range f.factories {
+		factory.AddFlags(flagSet)
+	}
+}
+
+// InitFromViper implements plugin.Configurable
+func (f *Factory) InitFromViper(v *viper.Viper) {
+	f.options.InitFromViper(v)
+	for _, factory := range f.factories {
+		factory.InitFromViper(v)
+	}
+}
+
+func (f *Factory) InitFromOptions(o Options) {
+	f.options = o
+}
+
+func (f *Factory) backend(name string) (StorageFactory, error) {
+	factory, ok := f.factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return factory, nil
+}
+
+func (f *Factory) Initialize(metricsFactory metrics.Factory, zapLogger *zap.Logger) error {
+	if err := f.options.Validate(); err != nil {
+		return err
+	}
+
+	f.metricsFactory = metricsFactory
+	f.logger = zapLogger
+	for _, name := range f.options.Backends {
+		factory, err := f.backend(name)
+		if err != nil {
+			return err
+		}
+		if err := factory.Initialize(metricsFactory.Namespace(metrics.NSOptions{Name: name}), zapLogger.With(zap.String("backend", name))); err != nil {
+			return fmt.Errorf("failed to initialize %s backend: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (f *Factory) CreateSpanReader() (spanstore.Reader, error) {
+	factory, err := f.backend(f.options.Primary)
+	if err != nil {
+		return nil, err
+	}
+	return factory.CreateSpanReader()
+}
+
+func (f *Factory) CreateSpanWriter() (spanstore.Writer, error) {
+	var primary NamedWriter
+	secondaries := make([]NamedWriter, 0, len(f.options.Backends))
+	for _, name := range f.options.Backends {
+		factory, err := f.backend(name)
+		if err != nil {
+			return nil, err
+		}
+		writer, err := factory.CreateSpanWriter()
+		if err != nil {
+			return nil, err
+		}
+		if name == f.options.Primary {
+			primary = NamedWriter{Name: name, Writer: writer}
+		} else {
+			secondaries = append(secondaries, NamedWriter{Name: name, Writer: writer})
+		}
+	}
+	return NewWriter(primary, secondaries, f.options.SecondaryErrors == SecondaryErrorsFail, f.metricsFactory, f.logger), nil
+}
+
+func (f *Factory) CreateDependencyReader() (dependencystore.Reader, error) {
+	factory, err := f.backend(f.options.Primary)
+	if err != nil {
+		return nil, err
+	}
+	return factory.CreateDependencyReader()
+}
+
+// Close closes every backend, even if some of them fail
+func (f *Factory) Close() error {
+	var errs []error
+	for _, name := range f.options.Backends {
+		if factory, ok := f.factories[name]; ok {
+			if err := factory.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return multierror.Wrap(errs)
+}
@@ -0,0 +1,155 @@
+package fanout
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/dependencystore"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/spf13/viper"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+// fakeReader is the span and dependency reader of a fakeFactory, named after its backend
+type fakeReader struct {
+	spanstore.Reader
+	backend string
+}
+
+func (r *fakeReader) GetDependencies(endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
+	return []model.DependencyLink{{Parent: r.backend}}, nil
+}
+
+// fakeFactory is a backend recording its lifecycle
+type fakeFactory struct {
+	name        string
+	writer      *fakeWriter
+	initialized bool
+	closed      bool
+	closeErr    error
+}
+
+func (f *fakeFactory) AddFlags(flagSet *flag.FlagSet) {}
+func (f *fakeFactory) InitFromViper(v *viper.Viper)   {}
+func (f *fakeFactory) Initialize(metricsFactory metrics.Factory, logger *zap.Logger) error {
+	f.initialized = true
+	return nil
+}
+func (f *fakeFactory) CreateSpanReader() (spanstore.Reader, error) {
+	return &fakeReader{backend: f.name}, nil
+}
+func (f *fakeFactory) CreateSpanWriter() (spanstore.Writer, error) {
+	return f.writer, nil
+}
+func (f *fakeFactory) CreateDependencyReader() (dependencystore.Reader, error) {
+	return &fakeReader{backend: f.name}, nil
+}
+func (f *fakeFactory) Close() error {
+	f.closed = true
+	return f.closeErr
+}
+
+func newFakeFactories(options Options) (*Factory, map[string]*fakeFactory) {
+	fakes := map[string]*fakeFactory{
+		druidBackend:   {name: druidBackend, writer: &fakeWriter{}},
+		questdbBackend: {name: questdbBackend, writer: &fakeWriter{}},
+	}
+	factory := &Factory{options: options, factories: map[string]StorageFactory{}}
+	for name, fake := range fakes {
+		factory.factories[name] = fake
+	}
+	return factory, fakes
+}
+
+func TestFactoryServesReadsFromPrimary(t *testing.T) {
+	factory, fakes := newFakeFactories(Options{
+		Backends:        []string{druidBackend, questdbBackend},
+		Primary:         druidBackend,
+		SecondaryErrors: SecondaryErrorsIgnore,
+	})
+	if err := factory.Initialize(metrics.NullFactory, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	for name, fake := range fakes {
+		if !fake.initialized {
+			t.Errorf("%s backend isn't initialized", name)
+		}
+	}
+
+	reader, err := factory.CreateSpanReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend := reader.(*fakeReader).backend; backend != druidBackend {
+		t.Errorf("spans are read from %s, expected the primary", backend)
+	}
+	dependencyReader, err := factory.CreateDependencyReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := dependencyReader.GetDependencies(time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links[0].Parent != druidBackend {
+		t.Errorf("dependencies are read from %s, expected the primary", links[0].Parent)
+	}
+}
+
+func TestFactoryWritesToEveryBackend(t *testing.T) {
+	factory, fakes := newFakeFactories(Options{
+		Backends:        []string{druidBackend, questdbBackend},
+		Primary:         questdbBackend,
+		SecondaryErrors: SecondaryErrorsFail,
+	})
+	fakes[druidBackend].writer.err = errors.New("druid is down")
+	if err := factory.Initialize(metrics.NullFactory, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	writer, err := factory.CreateSpanWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteSpan(&model.Span{}); err == nil || !strings.Contains(err.Error(), "druid is down") {
+		t.Errorf("write returned %v, expected the secondary error in fail mode", err)
+	}
+	for name, fake := range fakes {
+		if len(fake.writer.spans) != 1 {
+			t.Errorf("%s backend received %d spans, expected 1", name, len(fake.writer.spans))
+		}
+	}
+}
+
+func TestFactoryRejectsInvalidOptions(t *testing.T) {
+	factory, fakes := newFakeFactories(Options{
+		Backends:        []string{questdbBackend, "cassandra"},
+		Primary:         questdbBackend,
+		SecondaryErrors: SecondaryErrorsIgnore,
+	})
+	if err := factory.Initialize(metrics.NullFactory, zap.NewNop()); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+	for name, fake := range fakes {
+		if fake.initialized {
+			t.Errorf("%s backend initialized with invalid options", name)
+		}
+	}
+}
+
+func TestFactoryClosesEveryBackend(t *testing.T) {
+	factory, fakes := newFakeFactories(Options{Backends: []string{druidBackend, questdbBackend}, Primary: questdbBackend})
+	fakes[druidBackend].closeErr = errors.New("druid close failed")
+	if err := factory.Close(); err == nil {
+		t.Error("close error isn't returned")
+	}
+	for name, fake := range fakes {
+		if !fake.closed {
+			t.Errorf("%s backend isn't closed", name)
+		}
+	}
+}
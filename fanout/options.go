@@ -0,0 +1,86 @@
+package fanout
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/pkg/multierror"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	configPrefix          = "fanout"
+	suffixBackends        = ".backends"
+	suffixPrimary         = ".primary"
+	suffixSecondaryErrors = ".secondary-errors"
+
+	defaultBackends        = "druid,questdb"
+	defaultPrimary         = "questdb"
+	defaultSecondaryErrors = SecondaryErrorsIgnore
+
+	// SecondaryErrorsIgnore only logs and counts the write errors of the secondary backends
+	SecondaryErrorsIgnore = "ignore"
+	// SecondaryErrorsFail returns the write errors of the secondary backends to the caller
+	SecondaryErrorsFail = "fail"
+
+	druidBackend   = "druid"
+	questdbBackend = "questdb"
+)
+
+// backendNames are the backends spans can be written to
+var backendNames = map[string]bool{druidBackend: true, questdbBackend: true}
+
+type Options struct {
+	// Backends are the names of the backends spans are written to
+	Backends []string
+	// Primary is the backend reads are served from, its write errors are always returned
+	Primary         string
+	SecondaryErrors string
+}
+
+// AddFlags adds flags for Options
+func (opt *Options) AddFlags(flagSet *flag.FlagSet) {
+	flagSet.String(
+		configPrefix+suffixBackends,
+		defaultBackends,
+		"The comma-separated list of backends spans are written to. i.e. 'druid,questdb'")
+	flagSet.String(
+		configPrefix+suffixPrimary,
+		defaultPrimary,
+		"The backend reads are served from, it must be one of the backends")
+	flagSet.String(
+		configPrefix+suffixSecondaryErrors,
+		defaultSecondaryErrors,
+		"What to do when a secondary backend fails to write a span: 'ignore' logs the error, 'fail' returns it")
+}
+
+func (opt *Options) InitFromViper(v *viper.Viper) {
+	opt.Backends = strings.Split(strings.Replace(v.GetString(configPrefix+suffixBackends), " ", "", -1), ",")
+	opt.Primary = v.GetString(configPrefix + suffixPrimary)
+	opt.SecondaryErrors = v.GetString(configPrefix + suffixSecondaryErrors)
+}
+
+// Validate returns an error naming the flag of every invalid option
+func (opt *Options) Validate() error {
+	var errs []error
+	if opt.SecondaryErrors != SecondaryErrorsIgnore && opt.SecondaryErrors != SecondaryErrorsFail {
+		errs = append(errs, fmt.Errorf("%s: unknown mode %q, expected %s or %s",
+			configPrefix+suffixSecondaryErrors, opt.SecondaryErrors, SecondaryErrorsIgnore, SecondaryErrorsFail))
+	}
+	listed := make(map[string]bool, len(opt.Backends))
+	for _, name := range opt.Backends {
+		switch {
+		case !backendNames[name]:
+			errs = append(errs, fmt.Errorf("%s: unknown backend %q", configPrefix+suffixBackends, name))
+		case listed[name]:
+			errs = append(errs, fmt.Errorf("%s: backend %q is listed twice", configPrefix+suffixBackends, name))
+		}
+		listed[name] = true
+	}
+	if !listed[opt.Primary] {
+		errs = append(errs, fmt.Errorf("%s: primary backend %q is not one of the backends", configPrefix+suffixPrimary, opt.Primary))
+	}
+	return multierror.Wrap(errs)
+}
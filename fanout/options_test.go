@@ -0,0 +1,43 @@
+package fanout
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(options *Options)
+		// invalid is the flag reported, empty when the options are valid
+		invalid string
+	}{
+		{name: "defaults", modify: func(*Options) {}},
+		{name: "single backend", modify: func(o *Options) { o.Backends = []string{questdbBackend} }},
+		{name: "fail on secondary errors", modify: func(o *Options) { o.SecondaryErrors = SecondaryErrorsFail }},
+		{name: "unknown backend", modify: func(o *Options) { o.Backends = []string{questdbBackend, "cassandra"} }, invalid: "fanout.backends"},
+		{name: "duplicate backend", modify: func(o *Options) { o.Backends = []string{questdbBackend, druidBackend, questdbBackend} }, invalid: "fanout.backends"},
+		{name: "primary not a backend", modify: func(o *Options) { o.Backends = []string{druidBackend} }, invalid: "fanout.primary"},
+		{name: "no backend", modify: func(o *Options) { o.Backends = nil }, invalid: "fanout.primary"},
+		{name: "unknown secondary errors mode", modify: func(o *Options) { o.SecondaryErrors = "retry" }, invalid: "fanout.secondary-errors"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := Options{
+				Backends:        strings.Split(defaultBackends, ","),
+				Primary:         defaultPrimary,
+				SecondaryErrors: defaultSecondaryErrors,
+			}
+			test.modify(&options)
+			err := options.Validate()
+			switch {
+			case test.invalid == "" && err != nil:
+				t.Errorf("valid options rejected: %v", err)
+			case test.invalid != "" && err == nil:
+				t.Errorf("invalid %s accepted", test.invalid)
+			case test.invalid != "" && !strings.Contains(err.Error(), test.invalid):
+				t.Errorf("error %q doesn't name %s", err, test.invalid)
+			}
+		})
+	}
+}
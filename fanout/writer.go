@@ -0,0 +1,88 @@
+package fanout
+
+import (
+	"fmt"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/pkg/multierror"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+// NamedWriter is a span writer of a backend
+type NamedWriter struct {
+	Name   string
+	Writer spanstore.Writer
+}
+
+type writerMetrics struct {
+	writes metrics.Counter
+	errors metrics.Counter
+}
+
+// Writer writes each span to a primary and any number of secondary writers. A failing writer
+// never prevents the span from being written to the others.
+type Writer struct {
+	primary         NamedWriter
+	secondaries     []NamedWriter
+	failOnSecondary bool
+	logger          *zap.Logger
+	metrics         map[string]writerMetrics
+}
+
+func NewWriter(primary NamedWriter, secondaries []NamedWriter, failOnSecondary bool, metricsFactory metrics.Factory, logger *zap.Logger) *Writer {
+	writerMetrics := make(map[string]writerMetrics, len(secondaries)+1)
+	for _, w := range append([]NamedWriter{primary}, secondaries...) {
+		tags := map[string]string{"backend": w.Name}
+		writerMetrics[w.Name] = newWriterMetrics(metricsFactory, tags)
+	}
+	return &Writer{
+		primary:         primary,
+		secondaries:     secondaries,
+		failOnSecondary: failOnSecondary,
+		logger:          logger,
+		metrics:         writerMetrics,
+	}
+}
+
+func newWriterMetrics(factory metrics.Factory, tags map[string]string) writerMetrics {
+	return writerMetrics{
+		writes: factory.Counter(metrics.Options{Name: "fanout.writes", Tags: tags}),
+		errors: factory.Counter(metrics.Options{Name: "fanout.errors", Tags: tags}),
+	}
+}
+
+// write isolates a backend, a panic is reported as an error of that backend only.
+func (w *Writer) write(writer NamedWriter, span *model.Span) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s writer panic: %v", writer.Name, r)
+		}
+		if err != nil {
+			w.metrics[writer.Name].errors.Inc(1)
+		}
+	}()
+	w.metrics[writer.Name].writes.Inc(1)
+	return writer.Writer.WriteSpan(span)
+}
+
+// WriteSpan writes the span to every backend, the primary error is always returned and secondary
+// errors are only returned when configured to fail on them.
+func (w *Writer) WriteSpan(span *model.Span) error {
+	var errs []error
+	if err := w.write(w.primary, span); err != nil {
+		errs = append(errs, err)
+	}
+	for _, secondary := range w.secondaries {
+		err := w.write(secondary, span)
+		if err == nil {
+			continue
+		}
+		w.logger.Error("Failed to write span to secondary backend", zap.String("backend", secondary.Name), zap.Error(err))
+		if w.failOnSecondary {
+			errs = append(errs, err)
+		}
+	}
+	return multierror.Wrap(errs)
+}
@@ -0,0 +1,102 @@
+package fanout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+	"go.uber.org/zap"
+)
+
+// fakeWriter records the written spans, failing with err or panicking when set
+type fakeWriter struct {
+	spans  []*model.Span
+	err    error
+	panics bool
+}
+
+func (w *fakeWriter) WriteSpan(span *model.Span) error {
+	if w.panics {
+		panic("broken backend")
+	}
+	w.spans = append(w.spans, span)
+	return w.err
+}
+
+func TestWriteSpan(t *testing.T) {
+	primaryErr := errors.New("primary is down")
+	secondaryErr := errors.New("secondary is down")
+	tests := []struct {
+		name            string
+		primary         *fakeWriter
+		secondaries     []*fakeWriter
+		failOnSecondary bool
+		// errs are the errors returned, nil when the write succeeds
+		errs []string
+	}{
+		{name: "all succeed", primary: &fakeWriter{}, secondaries: []*fakeWriter{{}, {}}},
+		{name: "primary fails", primary: &fakeWriter{err: primaryErr}, secondaries: []*fakeWriter{{}}, errs: []string{primaryErr.Error()}},
+		{name: "primary panics", primary: &fakeWriter{panics: true}, secondaries: []*fakeWriter{{}}, errs: []string{"primary writer panic"}},
+		{name: "secondary fails and is ignored", primary: &fakeWriter{}, secondaries: []*fakeWriter{{err: secondaryErr}, {}}},
+		{name: "secondary panics and is ignored", primary: &fakeWriter{}, secondaries: []*fakeWriter{{panics: true}, {}}},
+		{name: "secondary fails", primary: &fakeWriter{}, secondaries: []*fakeWriter{{err: secondaryErr}, {}}, failOnSecondary: true,
+			errs: []string{secondaryErr.Error()}},
+		{name: "primary and secondary fail", primary: &fakeWriter{err: primaryErr}, secondaries: []*fakeWriter{{panics: true}}, failOnSecondary: true,
+			errs: []string{primaryErr.Error(), "secondary-0 writer panic"}},
+		{name: "primary fails and secondary is ignored", primary: &fakeWriter{err: primaryErr}, secondaries: []*fakeWriter{{err: secondaryErr}},
+			errs: []string{primaryErr.Error()}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var secondaries []NamedWriter
+			for i, secondary := range test.secondaries {
+				secondaries = append(secondaries, NamedWriter{Name: fmt.Sprintf("secondary-%d", i), Writer: secondary})
+			}
+			writer := NewWriter(NamedWriter{Name: "primary", Writer: test.primary}, secondaries, test.failOnSecondary, metricstest.NewFactory(0), zap.NewNop())
+
+			span := &model.Span{TraceID: model.NewTraceID(0, 1), SpanID: model.NewSpanID(1)}
+			err := writer.WriteSpan(span)
+			if len(test.errs) == 0 && err != nil {
+				t.Errorf("write failed: %v", err)
+			}
+			if len(test.errs) > 0 && err == nil {
+				t.Errorf("write succeeded, expected %q", test.errs)
+			}
+			for _, expected := range test.errs {
+				if err != nil && !strings.Contains(err.Error(), expected) {
+					t.Errorf("error %q doesn't contain %q", err, expected)
+				}
+			}
+			// every backend that doesn't panic receives the span, whatever the others do
+			for _, backend := range append([]*fakeWriter{test.primary}, test.secondaries...) {
+				if !backend.panics && (len(backend.spans) != 1 || backend.spans[0] != span) {
+					t.Errorf("backend received %v, expected the span", backend.spans)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteSpanMetrics(t *testing.T) {
+	metricsFactory := metricstest.NewFactory(0)
+	writer := NewWriter(NamedWriter{Name: "questdb", Writer: &fakeWriter{}}, []NamedWriter{
+		{Name: "druid", Writer: &fakeWriter{panics: true}},
+	}, false, metricsFactory, zap.NewNop())
+
+	for i := 0; i < 2; i++ {
+		if err := writer.WriteSpan(&model.Span{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metricsFactory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "fanout.writes", Tags: map[string]string{"backend": "questdb"}, Value: 2},
+		metricstest.ExpectedMetric{Name: "fanout.writes", Tags: map[string]string{"backend": "druid"}, Value: 2},
+		metricstest.ExpectedMetric{Name: "fanout.errors", Tags: map[string]string{"backend": "druid"}, Value: 2},
+	)
+	if counters, _ := metricsFactory.Snapshot(); counters["fanout.errors|backend=questdb"] != 0 {
+		t.Errorf("primary errors counted: %v", counters)
+	}
+}
@@ -5,8 +5,11 @@ go 1.13
 require (
 	github.com/Shopify/sarama v1.26.4
 	github.com/gogo/protobuf v1.3.1
+	github.com/golang/snappy v0.0.1
 	github.com/jaegertracing/jaeger v1.18.1
-	github.com/rubenvp8510/godruid v0.0.0-20200706195505-157c09891284 // indirect
+	github.com/klauspost/compress v1.9.8
+	github.com/rubenvp8510/godruid v0.0.0-20200706195505-157c09891284
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.0
 	github.com/uber/jaeger-lib v2.2.0+incompatible
 	go.uber.org/zap v1.15.0
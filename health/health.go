@@ -0,0 +1,115 @@
+// Package health runs the connectivity and schema checks of the storage backends, on demand for
+// readiness probes or once at startup.
+package health
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ModeDisabled doesn't run the checks at startup
+	ModeDisabled = "disabled"
+	// ModeFailFast fails the initialization of the storage when a check fails
+	ModeFailFast = "fail-fast"
+	// ModeDegraded logs the failed checks and starts anyway
+	ModeDegraded = "degraded"
+
+	// startupTimeout bounds the time the startup checks take
+	startupTimeout = 30 * time.Second
+)
+
+// Check verifies a dependency of a storage backend, it returns nil when the dependency is healthy.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name string
+	Err  error
+}
+
+// Report holds the results of the checks of a backend
+type Report []Result
+
+// Healthy returns whether every check passed
+func (r Report) Healthy() bool {
+	return r.Err() == nil
+}
+
+// Err returns an error describing the failed checks, nil when every check passed.
+func (r Report) Err() error {
+	var failures []string
+	for _, result := range r {
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", result.Name, result.Err))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("health checks failed: %s", strings.Join(failures, "; "))
+}
+
+// Run runs the checks in order, a failing check doesn't prevent the next ones from running.
+func Run(ctx context.Context, checks []Check) Report {
+	report := make(Report, 0, len(checks))
+	for _, check := range checks {
+		report = append(report, Result{Name: check.Name, Err: check.Run(ctx)})
+	}
+	return report
+}
+
+// Await returns the error of fn, or the error of ctx when it is done first. It bounds the checks of
+// clients that don't take a context, fn keeps running in the background until it returns.
+func Await(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ValidateMode returns an error when mode is not one of the supported startup modes, empty is disabled.
+func ValidateMode(mode string) error {
+	switch mode {
+	case "", ModeDisabled, ModeFailFast, ModeDegraded:
+		return nil
+	default:
+		return fmt.Errorf("unknown health check mode %q, expected %s, %s or %s", mode, ModeDisabled, ModeFailFast, ModeDegraded)
+	}
+}
+
+// Startup runs the checks according to mode. Only the fail-fast mode returns the failures, the
+// degraded mode logs them.
+func Startup(mode string, checks []Check, logger *zap.Logger) error {
+	if err := ValidateMode(mode); err != nil {
+		return err
+	}
+	if mode == "" || mode == ModeDisabled {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+	report := Run(ctx, checks)
+	for _, result := range report {
+		if result.Err != nil {
+			logger.Warn("Storage health check failed", zap.String("check", result.Name), zap.Error(result.Err))
+		}
+	}
+	if mode == ModeFailFast {
+		return report.Err()
+	}
+	return nil
+}
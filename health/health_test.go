@@ -0,0 +1,42 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAwait(t *testing.T) {
+	failure := errors.New("unreachable")
+	if err := Await(context.Background(), func() error { return failure }); err != failure {
+		t.Errorf("await returned %v, expected the error of the check", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := Await(ctx, func() error {
+		<-release
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("await of a hanging check returned %v, expected the context error", err)
+	}
+}
+
+func TestStartupModes(t *testing.T) {
+	failing := []Check{{Name: "broker", Run: func(context.Context) error { return errors.New("unreachable") }}}
+	if err := Startup(ModeFailFast, failing, zap.NewNop()); err == nil {
+		t.Error("fail-fast startup passed a failing check")
+	}
+	if err := Startup(ModeDisabled, failing, zap.NewNop()); err != nil {
+		t.Errorf("disabled startup returned %v", err)
+	}
+	if err := Startup("eventually", failing, zap.NewNop()); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
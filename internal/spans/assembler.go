@@ -0,0 +1,76 @@
+package spans
+
+import (
+	"sort"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// TraceAssembler groups spans by trace, traces are returned in the order they were first seen.
+type TraceAssembler struct {
+	traces map[model.TraceID]*model.Trace
+	seen   map[model.TraceID]map[model.SpanID]bool
+	order  []model.TraceID
+}
+
+func NewTraceAssembler() *TraceAssembler {
+	return &TraceAssembler{
+		traces: make(map[model.TraceID]*model.Trace),
+		seen:   make(map[model.TraceID]map[model.SpanID]bool),
+	}
+}
+
+// Add adds the span to its trace, a span already added to the trace is ignored.
+func (a *TraceAssembler) Add(span *model.Span) {
+	trace, ok := a.traces[span.TraceID]
+	if !ok {
+		trace = &model.Trace{}
+		a.traces[span.TraceID] = trace
+		a.seen[span.TraceID] = make(map[model.SpanID]bool)
+		a.order = append(a.order, span.TraceID)
+	}
+	if a.seen[span.TraceID][span.SpanID] {
+		return
+	}
+	a.seen[span.TraceID][span.SpanID] = true
+	trace.Spans = append(trace.Spans, span)
+}
+
+// Contains returns whether a span of the trace was added.
+func (a *TraceAssembler) Contains(traceID model.TraceID) bool {
+	_, ok := a.traces[traceID]
+	return ok
+}
+
+// Len returns the number of traces.
+func (a *TraceAssembler) Len() int {
+	return len(a.order)
+}
+
+// Trace returns the trace with its spans sorted by start time, nil if none of its spans was added.
+func (a *TraceAssembler) Trace(traceID model.TraceID) *model.Trace {
+	trace, ok := a.traces[traceID]
+	if !ok {
+		return nil
+	}
+	sortSpans(trace.Spans)
+	return trace
+}
+
+// Traces returns every trace with its spans sorted by start time.
+func (a *TraceAssembler) Traces() []*model.Trace {
+	traces := make([]*model.Trace, 0, len(a.order))
+	for _, traceID := range a.order {
+		traces = append(traces, a.Trace(traceID))
+	}
+	return traces
+}
+
+func sortSpans(spans []*model.Span) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].StartTime.Equal(spans[j].StartTime) {
+			return spans[i].SpanID < spans[j].SpanID
+		}
+		return spans[i].StartTime.Before(spans[j].StartTime)
+	})
+}
@@ -0,0 +1,93 @@
+package spans
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// None stores the raw protobuf span, readable by releases without codecs
+	None = "none"
+	// Snappy compresses the span with snappy, fast with a moderate ratio
+	Snappy = "snappy"
+	// Zstd compresses the span with zstd, slower with a better ratio
+	Zstd = "zstd"
+
+	versionMarker byte = 0x00
+	versionSnappy byte = 0x01
+	versionZstd   byte = 0x02
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Codec encodes spans with the configured compression, a nil Codec stores raw spans.
+//
+// Blobs used to be the base64 of the raw protobuf span. Versioned blobs start with a zero byte,
+// which never starts a protobuf message, followed by the codec version and the payload. Decode
+// detects the version, so spans stored with any codec, or before codecs existed, can be read.
+type Codec struct {
+	compression string
+}
+
+func NewCodec(compression string) (*Codec, error) {
+	switch compression {
+	case "", None:
+		return &Codec{compression: None}, nil
+	case Snappy, Zstd:
+		return &Codec{compression: compression}, nil
+	}
+	return nil, fmt.Errorf("unknown span compression: %s", compression)
+}
+
+// Encode returns the base64 blob of the span.
+func (c *Codec) Encode(span *model.Span) (string, error) {
+	data, err := proto.Marshal(span)
+	if err != nil {
+		return "", err
+	}
+	if c == nil || c.compression == None {
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+
+	var blob []byte
+	switch c.compression {
+	case Snappy:
+		blob = append([]byte{versionMarker, versionSnappy}, snappy.Encode(nil, data)...)
+	case Zstd:
+		blob = zstdEncoder.EncodeAll(data, []byte{versionMarker, versionZstd})
+	}
+	return base64.StdEncoding.EncodeToString(blob), nil
+}
+
+// Decode returns the span of a blob written by any codec.
+func Decode(text string) (*model.Span, error) {
+	blob, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	data := blob
+	if len(blob) >= 2 && blob[0] == versionMarker {
+		switch blob[1] {
+		case versionSnappy:
+			data, err = snappy.Decode(nil, blob[2:])
+		case versionZstd:
+			data, err = zstdDecoder.DecodeAll(blob[2:], nil)
+		default:
+			return nil, fmt.Errorf("unknown span codec version: %d", blob[1])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	span := &model.Span{}
+	err = proto.Unmarshal(data, span)
+	return span, err
+}
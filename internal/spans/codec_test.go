@@ -0,0 +1,172 @@
+package spans
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/jaegertracing/jaeger/model"
+)
+
+var compressions = []string{None, Snappy, Zstd}
+
+// realisticSpan is a server span with the tags and logs instrumentation libraries usually record
+func realisticSpan() *model.Span {
+	start := time.Date(2020, 7, 1, 10, 0, 0, 123456000, time.UTC)
+	traceID := model.NewTraceID(0x4bf92f3577b34da6, 0xa3ce929d0e0e4736)
+	return &model.Span{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(0x00f067aa0ba902b7),
+		OperationName: "HTTP GET /api/v1/customers/{id}",
+		References:    []model.SpanRef{model.NewChildOfRef(traceID, model.NewSpanID(0x53995c3f42cd8ad8))},
+		Flags:         model.SampledFlag,
+		StartTime:     start,
+		Duration:      42 * time.Millisecond,
+		Tags: []model.KeyValue{
+			model.String("span.kind", "server"),
+			model.String("component", "net/http"),
+			model.String("http.method", "GET"),
+			model.String("http.url", "https://frontend.example.com/api/v1/customers/123?expand=orders"),
+			model.Int64("http.status_code", 200),
+			model.String("peer.address", "10.0.12.34:51234"),
+			model.Bool("error", false),
+		},
+		Logs: []model.Log{
+			{Timestamp: start.Add(time.Millisecond), Fields: []model.KeyValue{
+				model.String("event", "cache miss"), model.String("key", "customer:123"),
+			}},
+			{Timestamp: start.Add(40 * time.Millisecond), Fields: []model.KeyValue{
+				model.String("event", "query"), model.String("db.statement", "SELECT * FROM customers WHERE id = $1"),
+				model.Int64("rows", 1),
+			}},
+		},
+		Process: model.NewProcess("frontend", []model.KeyValue{
+			model.String("hostname", "frontend-7d9c6b5f4-x2x9z"),
+			model.String("ip", "10.0.12.7"),
+			model.String("jaeger.version", "Go-2.25.0"),
+		}),
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	span := realisticSpan()
+	for _, compression := range append([]string{""}, compressions...) {
+		t.Run(fmt.Sprintf("%q", compression), func(t *testing.T) {
+			codec, err := NewCodec(compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blob, err := codec.Encode(span)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, span) {
+				t.Errorf("decoded span is %v, expected %v", decoded, span)
+			}
+		})
+	}
+}
+
+func TestNilCodecStoresRawSpans(t *testing.T) {
+	var codec *Codec
+	blob, err := codec.Encode(realisticSpan())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := Decode(blob); err != nil || !reflect.DeepEqual(decoded, realisticSpan()) {
+		t.Errorf("decoded span is %v, %v", decoded, err)
+	}
+}
+
+func TestDecodeLegacyBlob(t *testing.T) {
+	span := realisticSpan()
+	// blobs written before codecs existed are the base64 of the raw protobuf span
+	data, err := proto.Marshal(span)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, span) {
+		t.Errorf("decoded span is %v, expected %v", decoded, span)
+	}
+}
+
+func TestDecodeInvalidBlobs(t *testing.T) {
+	tests := []struct {
+		name string
+		blob string
+	}{
+		{name: "not base64", blob: "not base64!"},
+		{name: "unknown version", blob: base64.StdEncoding.EncodeToString([]byte{versionMarker, 0x7f, 1, 2})},
+		{name: "corrupted snappy", blob: base64.StdEncoding.EncodeToString([]byte{versionMarker, versionSnappy, 0xff, 0xff})},
+		{name: "corrupted zstd", blob: base64.StdEncoding.EncodeToString([]byte{versionMarker, versionZstd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if span, err := Decode(test.blob); err == nil {
+				t.Errorf("decoded %v, expected an error", span)
+			}
+		})
+	}
+}
+
+func TestUnknownCompression(t *testing.T) {
+	if _, err := NewCodec("gzip"); err == nil {
+		t.Error("unknown compression accepted")
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	span := realisticSpan()
+	for _, compression := range compressions {
+		b.Run(compression, func(b *testing.B) {
+			codec, err := NewCodec(compression)
+			if err != nil {
+				b.Fatal(err)
+			}
+			blob, err := codec.Encode(span)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.Encode(span); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(blob)), "bytes/span")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	span := realisticSpan()
+	for _, compression := range compressions {
+		b.Run(compression, func(b *testing.B) {
+			codec, err := NewCodec(compression)
+			if err != nil {
+				b.Fatal(err)
+			}
+			blob, err := codec.Encode(span)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Decode(blob); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
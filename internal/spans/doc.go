@@ -0,0 +1,4 @@
+// Package spans contains what every storage backend needs to store and read spans: the span blob
+// codec, the trace assembler, the tag field names, the validation of search parameters, the shared
+// errors and options.
+package spans
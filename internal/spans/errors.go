@@ -0,0 +1,24 @@
+package spans
+
+import (
+	"errors"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+var (
+	// ErrTraceNotFound is returned by the readers when no span of the trace is found, it is the jaeger
+	// error so the query service reports it as not found.
+	ErrTraceNotFound = spanstore.ErrTraceNotFound
+
+	// ErrMalformedRequestObject is returned when the search parameters are nil.
+	ErrMalformedRequestObject = errors.New("malformed request object")
+	// ErrServiceNameNotSet is returned when an operation is searched without its service.
+	ErrServiceNameNotSet = errors.New("service name must be set")
+	// ErrStartAndEndTimeNotSet is returned when the search time range is missing.
+	ErrStartAndEndTimeNotSet = errors.New("start and end time must be set")
+	// ErrStartTimeMinGreaterThanMax is returned when the search time range is reversed.
+	ErrStartTimeMinGreaterThanMax = errors.New("start time minimum is above maximum")
+	// ErrDurationMinGreaterThanMax is returned when the search duration range is reversed.
+	ErrDurationMinGreaterThanMax = errors.New("duration minimum is above maximum")
+)
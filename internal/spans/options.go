@@ -0,0 +1,110 @@
+package spans
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rubenvp8510/jaeger-storages/health"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/spf13/viper"
+)
+
+const (
+	suffixTraceLevelMatching = ".trace-level-matching"
+	suffixTenancy            = ".tenancy.enabled"
+	suffixDefaultTenant      = ".tenancy.default-tenant"
+	suffixTrustProcessTag    = ".tenancy.trust-process-tag"
+	suffixSpanCompression    = ".span-compression"
+	suffixHealthCheck        = ".health-check"
+
+	defaultSpanCompression = None
+	defaultHealthCheck     = health.ModeDisabled
+)
+
+// Options are the options every backend supports, registered under the prefix of the backend.
+type Options struct {
+	TraceLevelMatching bool   `mapstructure:"trace_level_matching"`
+	Tenancy            bool   `mapstructure:"tenancy"`
+	SpanCompression    string `mapstructure:"span_compression"`
+	// HealthCheck is the health mode the checks of the backend are run with at startup
+	HealthCheck string `mapstructure:"health_check"`
+	// DefaultTenant and TrustProcessTag configure the tenant of requests without one, see tenancy.Resolver
+	DefaultTenant   string `mapstructure:"default_tenant"`
+	TrustProcessTag bool   `mapstructure:"trust_process_tag"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		SpanCompression: defaultSpanCompression,
+		HealthCheck:     defaultHealthCheck,
+	}
+}
+
+// AddFlags adds flags for Options
+func (opt *Options) AddFlags(prefix string, flagSet *flag.FlagSet) {
+	flagSet.Bool(
+		prefix+suffixTraceLevelMatching,
+		false,
+		"(experimental) Match search criteria against the whole trace, each criteria may be satisfied by a different span")
+	flagSet.Bool(
+		prefix+suffixTenancy,
+		false,
+		"Isolate the spans of each tenant. Spans are written with the default tenant or their tenant process tag, one of them is required. Reads use the tenant of the request context, set by servers embedding the storage, or the default tenant")
+	flagSet.String(
+		prefix+suffixDefaultTenant,
+		"",
+		"Tenant of the reads and writes without tenant in their context, for instances dedicated to a tenant")
+	flagSet.Bool(
+		prefix+suffixTrustProcessTag,
+		false,
+		"Take the tenant of spans written without tenant in their context from their tenant process tag. Clients can set it, only enable it when the pipeline sets the tag")
+	flagSet.String(
+		prefix+suffixSpanCompression,
+		defaultSpanCompression,
+		"Compression of the stored span blobs: none, snappy or zstd. Spans written with any of them remain readable")
+	flagSet.String(
+		prefix+suffixHealthCheck,
+		defaultHealthCheck,
+		"Check connectivity and schema at startup: disabled, fail-fast to stop on failures or degraded to only log them")
+}
+
+// InitFromViper initializes Options with properties from viper
+func (opt *Options) InitFromViper(prefix string, v *viper.Viper) {
+	opt.TraceLevelMatching = v.GetBool(prefix + suffixTraceLevelMatching)
+	opt.Tenancy = v.GetBool(prefix + suffixTenancy)
+	opt.DefaultTenant = v.GetString(prefix + suffixDefaultTenant)
+	opt.TrustProcessTag = v.GetBool(prefix + suffixTrustProcessTag)
+	opt.SpanCompression = v.GetString(prefix + suffixSpanCompression)
+	opt.HealthCheck = v.GetString(prefix + suffixHealthCheck)
+}
+
+// Tenants returns the resolver of the tenants of reads and writes
+func (opt *Options) Tenants() tenancy.Resolver {
+	return tenancy.Resolver{
+		Enabled:         opt.Tenancy,
+		Default:         opt.DefaultTenant,
+		TrustProcessTag: opt.TrustProcessTag,
+	}
+}
+
+// Validate returns an error for every invalid option, named after its flag under the prefix.
+func (opt *Options) Validate(prefix string) []error {
+	var errs []error
+	if _, err := NewCodec(opt.SpanCompression); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", prefix+suffixSpanCompression, err))
+	}
+	if err := health.ValidateMode(opt.HealthCheck); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", prefix+suffixHealthCheck, err))
+	}
+	if !opt.Tenancy && opt.DefaultTenant != "" {
+		errs = append(errs, fmt.Errorf("%s requires %s", prefix+suffixDefaultTenant, prefix+suffixTenancy))
+	}
+	if !opt.Tenancy && opt.TrustProcessTag {
+		errs = append(errs, fmt.Errorf("%s requires %s", prefix+suffixTrustProcessTag, prefix+suffixTenancy))
+	}
+	// the span writer has no request context, spans would have no tenant
+	if opt.Tenancy && opt.DefaultTenant == "" && !opt.TrustProcessTag {
+		errs = append(errs, fmt.Errorf("%s requires %s or %s", prefix+suffixTenancy, prefix+suffixDefaultTenant, prefix+suffixTrustProcessTag))
+	}
+	return errs
+}
@@ -0,0 +1,11 @@
+package spans
+
+// TagPrefix starts the names of the fields storing the span tags, so they don't collide with the
+// fields of the span itself.
+const TagPrefix = "__tag"
+
+// TagField returns the name of the field storing a tag. Each backend joins the prefix and the key
+// with the separator its field names allow, the key must already be valid for the backend.
+func TagField(separator, key string) string {
+	return TagPrefix + separator + key
+}
@@ -0,0 +1,21 @@
+package spans
+
+import "testing"
+
+func TestTagField(t *testing.T) {
+	tests := []struct {
+		separator string
+		key       string
+		expected  string
+	}{
+		// the druid dimensions
+		{separator: ".", key: "http.status_code", expected: "__tag.http.status_code"},
+		// the QuestDB columns, their keys are sanitized by the backend
+		{separator: "__prefix_", key: "http#status_code", expected: "__tag__prefix_http#status_code"},
+	}
+	for _, test := range tests {
+		if field := TagField(test.separator, test.key); field != test.expected {
+			t.Errorf("field of %q is %q, expected %q", test.key, field, test.expected)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package spans
+
+import "github.com/jaegertracing/jaeger/storage/spanstore"
+
+// DefaultNumTraces is the number of traces searched when the query doesn't limit them
+const DefaultNumTraces = 100
+
+// ValidateQuery returns an error if the search parameters can't be satisfied by any backend.
+func ValidateQuery(query *spanstore.TraceQueryParameters) error {
+	if query == nil {
+		return ErrMalformedRequestObject
+	}
+	if query.ServiceName == "" && query.OperationName != "" {
+		return ErrServiceNameNotSet
+	}
+	if query.StartTimeMin.IsZero() || query.StartTimeMax.IsZero() {
+		return ErrStartAndEndTimeNotSet
+	}
+	if query.StartTimeMax.Before(query.StartTimeMin) {
+		return ErrStartTimeMinGreaterThanMax
+	}
+	if query.DurationMin != 0 && query.DurationMax != 0 && query.DurationMin > query.DurationMax {
+		return ErrDurationMinGreaterThanMax
+	}
+	return nil
+}
+
+// NumTraces returns the maximum number of traces a search returns.
+func NumTraces(query *spanstore.TraceQueryParameters) int {
+	if query.NumTraces <= 0 {
+		return DefaultNumTraces
+	}
+	return query.NumTraces
+}
@@ -0,0 +1,49 @@
+package migrate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Checkpoint records the progress of a migration so it can be resumed
+type Checkpoint struct {
+	// Window is the end of the last time window fully exported from a reader
+	Window time.Time `json:"window,omitempty"`
+	// Spans is the number of spans imported from a file
+	Spans int64 `json:"spans,omitempty"`
+}
+
+// LoadCheckpoint reads the checkpoint at path, a missing file is an empty checkpoint.
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	if path == "" {
+		return checkpoint, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	err = json.Unmarshal(data, &checkpoint)
+	return checkpoint, err
+}
+
+// Save writes the checkpoint atomically, a crash never leaves a partial checkpoint behind.
+func (c Checkpoint) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
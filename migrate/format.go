@@ -0,0 +1,107 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// FormatJSON stores one jsonpb encoded span per line
+	FormatJSON = "ndjson"
+	// FormatProtobuf stores varint length delimited protobuf encoded spans
+	FormatProtobuf = "protobuf"
+
+	maxLineSize = 16 * 1024 * 1024
+)
+
+// FileWriter writes spans to an intermediate file, it implements spanstore.Writer so any export can
+// be sent to a file instead of a backend.
+type FileWriter struct {
+	writer    *bufio.Writer
+	format    string
+	marshaler jsonpb.Marshaler
+}
+
+func NewFileWriter(w io.Writer, format string) (*FileWriter, error) {
+	if format != FormatJSON && format != FormatProtobuf {
+		return nil, fmt.Errorf("unknown file format: %s", format)
+	}
+	return &FileWriter{writer: bufio.NewWriter(w), format: format}, nil
+}
+
+func (f *FileWriter) WriteSpan(span *model.Span) error {
+	if f.format == FormatJSON {
+		if err := f.marshaler.Marshal(f.writer, span); err != nil {
+			return err
+		}
+		return f.writer.WriteByte('\n')
+	}
+	data, err := span.Marshal()
+	if err != nil {
+		return err
+	}
+	size := make([]byte, binary.MaxVarintLen64)
+	if _, err := f.writer.Write(size[:binary.PutUvarint(size, uint64(len(data)))]); err != nil {
+		return err
+	}
+	_, err = f.writer.Write(data)
+	return err
+}
+
+// Flush writes the buffered spans to the underlying writer
+func (f *FileWriter) Flush() error {
+	return f.writer.Flush()
+}
+
+// FileReader reads spans written by FileWriter
+type FileReader struct {
+	reader  *bufio.Reader
+	scanner *bufio.Scanner
+	format  string
+}
+
+func NewFileReader(r io.Reader, format string) (*FileReader, error) {
+	switch format {
+	case FormatJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &FileReader{scanner: scanner, format: format}, nil
+	case FormatProtobuf:
+		return &FileReader{reader: bufio.NewReader(r), format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown file format: %s", format)
+}
+
+// ReadSpan returns the next span of the file, or io.EOF when there are no more spans.
+func (f *FileReader) ReadSpan() (*model.Span, error) {
+	span := &model.Span{}
+	if f.format == FormatJSON {
+		if !f.scanner.Scan() {
+			if err := f.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if err := jsonpb.UnmarshalString(f.scanner.Text(), span); err != nil {
+			return nil, err
+		}
+		return span, nil
+	}
+	size, err := binary.ReadUvarint(f.reader)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f.reader, data); err != nil {
+		return nil, err
+	}
+	if err := span.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return span, nil
+}
@@ -0,0 +1,47 @@
+package migrate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterUnlimited(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		limiter := newLimiter(rate)
+		start := time.Now()
+		for i := 0; i < 1000; i++ {
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("unlimited calls waited %v with rate %v", elapsed, rate)
+		}
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	limiter := newLimiter(50)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("6 calls in %v at 50 per second, expected at least 100ms", elapsed)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	limiter := newLimiter(0.1)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait returned %v, expected the context error", err)
+	}
+}
@@ -0,0 +1,209 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWindow          = time.Hour
+	defaultMaxTraces       = 10000
+	defaultCheckpointSpans = 1000
+)
+
+// Options configures a migration, the time range only applies to exports from a reader.
+type Options struct {
+	StartTime time.Time
+	EndTime   time.Time
+	// Window is the time range fetched from the reader at once, a checkpoint is saved after each one
+	Window time.Duration
+	// MaxTraces is the maximum number of traces fetched per service and window
+	MaxTraces int
+	// Rate is the maximum number of spans written per second, zero is unlimited
+	Rate float64
+	// Checkpoint is the file the progress is saved to, empty disables checkpoints
+	Checkpoint string
+	// CheckpointSpans is the number of spans imported from a file between checkpoints
+	CheckpointSpans int64
+}
+
+// flusher is implemented by writers buffering spans or writing them asynchronously, they are flushed
+// before saving a checkpoint so a checkpoint never covers spans that were lost.
+type flusher interface {
+	Flush() error
+}
+
+// Migrator copies spans from a reader or a file to a writer.
+type Migrator struct {
+	writer  spanstore.Writer
+	options Options
+	limiter *limiter
+	logger  *zap.Logger
+}
+
+func NewMigrator(writer spanstore.Writer, options Options, logger *zap.Logger) *Migrator {
+	if options.Window <= 0 {
+		options.Window = defaultWindow
+	}
+	if options.MaxTraces <= 0 {
+		options.MaxTraces = defaultMaxTraces
+	}
+	if options.CheckpointSpans <= 0 {
+		options.CheckpointSpans = defaultCheckpointSpans
+	}
+	return &Migrator{
+		writer:  writer,
+		options: options,
+		limiter: newLimiter(options.Rate),
+		logger:  logger,
+	}
+}
+
+// checkCheckpoints refuses checkpoints with writers that can't flush, as they may still be writing
+// the spans a checkpoint covers.
+func (m *Migrator) checkCheckpoints() error {
+	if _, ok := m.writer.(flusher); !ok && m.options.Checkpoint != "" {
+		return fmt.Errorf("checkpoints require a writer that can be flushed, %T can't", m.writer)
+	}
+	return nil
+}
+
+func (m *Migrator) saveCheckpoint(checkpoint Checkpoint) error {
+	if f, ok := m.writer.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return checkpoint.Save(m.options.Checkpoint)
+}
+
+func (m *Migrator) writeSpan(ctx context.Context, span *model.Span) error {
+	if err := m.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return m.writer.WriteSpan(span)
+}
+
+// Export streams the traces of the time range from reader, one window at a time. It resumes after
+// the last window recorded in the checkpoint. Traces crossing windows may be written more than once.
+func (m *Migrator) Export(ctx context.Context, reader spanstore.Reader) error {
+	if err := m.checkCheckpoints(); err != nil {
+		return err
+	}
+	checkpoint, err := LoadCheckpoint(m.options.Checkpoint)
+	if err != nil {
+		return err
+	}
+	start := m.options.StartTime
+	if checkpoint.Window.After(start) {
+		start = checkpoint.Window
+		m.logger.Info("Resuming export", zap.Time("from", start))
+	}
+
+	for start.Before(m.options.EndTime) {
+		end := start.Add(m.options.Window)
+		if end.After(m.options.EndTime) {
+			end = m.options.EndTime
+		}
+		spans, err := m.exportWindow(ctx, reader, start, end)
+		if err != nil {
+			return err
+		}
+		checkpoint.Window = end
+		if err := m.saveCheckpoint(checkpoint); err != nil {
+			return err
+		}
+		m.logger.Info("Exported window", zap.Time("start", start), zap.Time("end", end), zap.Int("spans", spans))
+		start = end
+	}
+	return nil
+}
+
+// exportWindow writes every trace of the window once, a trace is returned for each of its services.
+func (m *Migrator) exportWindow(ctx context.Context, reader spanstore.Reader, start, end time.Time) (int, error) {
+	services, err := reader.GetServices(ctx)
+	if err != nil {
+		return 0, err
+	}
+	written := make(map[model.TraceID]bool)
+	spans := 0
+	for _, service := range services {
+		traces, err := reader.FindTraces(ctx, &spanstore.TraceQueryParameters{
+			ServiceName:  service,
+			StartTimeMin: start,
+			StartTimeMax: end,
+			NumTraces:    m.options.MaxTraces,
+		})
+		if err == spanstore.ErrTraceNotFound {
+			continue
+		}
+		if err != nil {
+			return spans, err
+		}
+		if len(traces) >= m.options.MaxTraces {
+			m.logger.Warn("Window traces limit reached, some traces may be missing, use a smaller window",
+				zap.String("service", service), zap.Time("start", start), zap.Time("end", end))
+		}
+		for _, trace := range traces {
+			if len(trace.Spans) == 0 || written[trace.Spans[0].TraceID] {
+				continue
+			}
+			written[trace.Spans[0].TraceID] = true
+			for _, span := range trace.Spans {
+				if err := m.writeSpan(ctx, span); err != nil {
+					return spans, err
+				}
+				spans++
+			}
+		}
+	}
+	return spans, nil
+}
+
+// Import writes every span of the file, it skips the spans already imported according to the
+// checkpoint, so the file must not change between runs.
+func (m *Migrator) Import(ctx context.Context, reader *FileReader) error {
+	if err := m.checkCheckpoints(); err != nil {
+		return err
+	}
+	checkpoint, err := LoadCheckpoint(m.options.Checkpoint)
+	if err != nil {
+		return err
+	}
+	if checkpoint.Spans > 0 {
+		m.logger.Info("Resuming import", zap.Int64("skipped", checkpoint.Spans))
+	}
+
+	var position int64
+	for {
+		span, err := reader.ReadSpan()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		position++
+		if position <= checkpoint.Spans {
+			continue
+		}
+		if err := m.writeSpan(ctx, span); err != nil {
+			return err
+		}
+		if position%m.options.CheckpointSpans == 0 {
+			checkpoint.Spans = position
+			if err := m.saveCheckpoint(checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+	checkpoint.Spans = position
+	m.logger.Info("Imported spans", zap.Int64("spans", position))
+	return m.saveCheckpoint(checkpoint)
+}
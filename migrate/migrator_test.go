@@ -0,0 +1,306 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"go.uber.org/zap"
+)
+
+var epoch = time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+
+func testSpan(traceID, spanID uint64, service string, start time.Time) *model.Span {
+	return &model.Span{
+		TraceID:       model.NewTraceID(0, traceID),
+		SpanID:        model.NewSpanID(spanID),
+		OperationName: "get",
+		StartTime:     start,
+		Process:       model.NewProcess(service, nil),
+	}
+}
+
+// memoryReader returns the traces starting within the queried window, for every service of the trace
+type memoryReader struct {
+	traces   []*model.Trace
+	searches []spanstore.TraceQueryParameters
+}
+
+func (r *memoryReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	return nil, spanstore.ErrTraceNotFound
+}
+
+func (r *memoryReader) GetServices(ctx context.Context) ([]string, error) {
+	return []string{"backend", "frontend"}, nil
+}
+
+func (r *memoryReader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
+	return nil, nil
+}
+
+func (r *memoryReader) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
+	r.searches = append(r.searches, *query)
+	var traces []*model.Trace
+	for _, trace := range r.traces {
+		for _, span := range trace.Spans {
+			if span.Process.ServiceName == query.ServiceName && !span.StartTime.Before(query.StartTimeMin) && span.StartTime.Before(query.StartTimeMax) {
+				traces = append(traces, trace)
+				break
+			}
+		}
+	}
+	if len(traces) == 0 {
+		return nil, spanstore.ErrTraceNotFound
+	}
+	return traces, nil
+}
+
+func (r *memoryReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	return nil, nil
+}
+
+// memoryWriter records the spans written and how many of them were flushed
+type memoryWriter struct {
+	spans    []*model.Span
+	flushed  int
+	flushErr error
+}
+
+func (w *memoryWriter) WriteSpan(span *model.Span) error {
+	w.spans = append(w.spans, span)
+	return nil
+}
+
+func (w *memoryWriter) Flush() error {
+	if w.flushErr != nil {
+		return w.flushErr
+	}
+	w.flushed = len(w.spans)
+	return nil
+}
+
+// unflushedWriter writes spans without being able to flush them
+type unflushedWriter struct{}
+
+func (unflushedWriter) WriteSpan(span *model.Span) error {
+	return nil
+}
+
+func checkpointPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "checkpoint"), func() { os.RemoveAll(dir) }
+}
+
+func threeHoursOfTraces() *memoryReader {
+	return &memoryReader{traces: []*model.Trace{
+		// a trace is returned for each of its services and written once
+		{Spans: []*model.Span{
+			testSpan(1, 1, "frontend", epoch.Add(10*time.Minute)),
+			testSpan(1, 2, "backend", epoch.Add(11*time.Minute)),
+		}},
+		{Spans: []*model.Span{testSpan(2, 3, "frontend", epoch.Add(70*time.Minute))}},
+		{Spans: []*model.Span{testSpan(3, 4, "backend", epoch.Add(130*time.Minute))}},
+	}}
+}
+
+func TestExport(t *testing.T) {
+	path, cleanup := checkpointPath(t)
+	defer cleanup()
+	reader := threeHoursOfTraces()
+	writer := &memoryWriter{}
+	migrator := NewMigrator(writer, Options{
+		StartTime:  epoch,
+		EndTime:    epoch.Add(3 * time.Hour),
+		Window:     time.Hour,
+		Checkpoint: path,
+	}, zap.NewNop())
+
+	if err := migrator.Export(context.Background(), reader); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.spans) != 4 {
+		t.Errorf("%d spans written, expected 4", len(writer.spans))
+	}
+	if writer.flushed != 4 {
+		t.Errorf("%d spans flushed, expected every span before the last checkpoint", writer.flushed)
+	}
+	if len(reader.searches) != 6 {
+		t.Errorf("%d searches, expected one per service and window", len(reader.searches))
+	}
+	checkpoint, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.Window.Equal(epoch.Add(3 * time.Hour)) {
+		t.Errorf("checkpoint is at %v, expected the end of the time range", checkpoint.Window)
+	}
+}
+
+func TestExportResume(t *testing.T) {
+	path, cleanup := checkpointPath(t)
+	defer cleanup()
+	if err := (Checkpoint{Window: epoch.Add(2 * time.Hour)}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	reader := threeHoursOfTraces()
+	writer := &memoryWriter{}
+	migrator := NewMigrator(writer, Options{
+		StartTime:  epoch,
+		EndTime:    epoch.Add(3 * time.Hour),
+		Window:     time.Hour,
+		Checkpoint: path,
+	}, zap.NewNop())
+
+	if err := migrator.Export(context.Background(), reader); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.spans) != 1 || writer.spans[0].SpanID != 4 {
+		t.Errorf("spans written are %v, expected only the span of the last window", writer.spans)
+	}
+	for _, search := range reader.searches {
+		if search.StartTimeMin.Before(epoch.Add(2 * time.Hour)) {
+			t.Errorf("window starting at %v searched again", search.StartTimeMin)
+		}
+	}
+}
+
+func TestExportFlushFailure(t *testing.T) {
+	path, cleanup := checkpointPath(t)
+	defer cleanup()
+	writer := &memoryWriter{flushErr: errors.New("broker unavailable")}
+	migrator := NewMigrator(writer, Options{
+		StartTime:  epoch,
+		EndTime:    epoch.Add(3 * time.Hour),
+		Checkpoint: path,
+	}, zap.NewNop())
+
+	if err := migrator.Export(context.Background(), threeHoursOfTraces()); err != writer.flushErr {
+		t.Errorf("export returned %v, expected the flush error", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint saved although the spans weren't flushed: %v", err)
+	}
+}
+
+func TestCheckpointRequiresFlush(t *testing.T) {
+	path, cleanup := checkpointPath(t)
+	defer cleanup()
+	migrator := NewMigrator(unflushedWriter{}, Options{
+		StartTime:  epoch,
+		EndTime:    epoch.Add(time.Hour),
+		Checkpoint: path,
+	}, zap.NewNop())
+	if err := migrator.Export(context.Background(), threeHoursOfTraces()); err == nil {
+		t.Error("checkpoints saved with a writer that can't be flushed")
+	}
+
+	// without checkpoints the writer doesn't need to be flushed
+	migrator = NewMigrator(unflushedWriter{}, Options{
+		StartTime: epoch,
+		EndTime:   epoch.Add(time.Hour),
+	}, zap.NewNop())
+	if err := migrator.Export(context.Background(), threeHoursOfTraces()); err != nil {
+		t.Error(err)
+	}
+}
+
+// spansFile writes count spans in the format
+func spansFile(t *testing.T, format string, count int) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	writer, err := NewFileWriter(buffer, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= count; i++ {
+		if err := writer.WriteSpan(testSpan(uint64(i), uint64(i), "frontend", epoch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer
+}
+
+func TestImport(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatProtobuf} {
+		t.Run(format, func(t *testing.T) {
+			path, cleanup := checkpointPath(t)
+			defer cleanup()
+			reader, err := NewFileReader(spansFile(t, format, 5), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer := &memoryWriter{}
+			migrator := NewMigrator(writer, Options{Checkpoint: path, CheckpointSpans: 2}, zap.NewNop())
+
+			if err := migrator.Import(context.Background(), reader); err != nil {
+				t.Fatal(err)
+			}
+			if len(writer.spans) != 5 || writer.flushed != 5 {
+				t.Errorf("%d spans written and %d flushed, expected 5", len(writer.spans), writer.flushed)
+			}
+			for i, span := range writer.spans {
+				if span.SpanID != model.NewSpanID(uint64(i+1)) {
+					t.Errorf("span %d is %v", i, span.SpanID)
+				}
+			}
+			checkpoint, err := LoadCheckpoint(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checkpoint.Spans != 5 {
+				t.Errorf("checkpoint is at span %d, expected 5", checkpoint.Spans)
+			}
+		})
+	}
+}
+
+func TestImportResume(t *testing.T) {
+	path, cleanup := checkpointPath(t)
+	defer cleanup()
+	if err := (Checkpoint{Spans: 3}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewFileReader(spansFile(t, FormatProtobuf, 5), FormatProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &memoryWriter{}
+	migrator := NewMigrator(writer, Options{Checkpoint: path}, zap.NewNop())
+
+	if err := migrator.Import(context.Background(), reader); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.spans) != 2 || writer.spans[0].SpanID != 4 || writer.spans[1].SpanID != 5 {
+		t.Errorf("spans written are %v, expected the spans after the checkpoint", writer.spans)
+	}
+}
+
+func TestImportRate(t *testing.T) {
+	reader, err := NewFileReader(spansFile(t, FormatJSON, 6), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &memoryWriter{}
+	migrator := NewMigrator(writer, Options{Rate: 100}, zap.NewNop())
+
+	start := time.Now()
+	if err := migrator.Import(context.Background(), reader); err != nil {
+		t.Fatal(err)
+	}
+	// the first span is written right away, then one every 10ms
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("6 spans written in %v at 100 spans per second", elapsed)
+	}
+}
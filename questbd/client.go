@@ -3,6 +3,9 @@ package questbd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -38,9 +41,47 @@ type questDBResponse struct {
 	Error   string
 }
 
+// ImportColumn is a column of the schema of a CSV import, Pattern is the format of TIMESTAMP and DATE columns
+type ImportColumn struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// ImportOptions are the parameters of a CSV import
+type ImportOptions struct {
+	Table  string
+	Schema []ImportColumn
+	// Timestamp is the designated timestamp column and PartitionBy the partition unit, both only
+	// used when the import creates the table
+	Timestamp   string
+	PartitionBy string
+}
+
+// ImportResult reports the rows of a CSV import
+type ImportResult struct {
+	Imported int64
+	Rejected int64
+}
+
+type questDBImportResponse struct {
+	Status       string
+	Location     string
+	RowsRejected int64
+	RowsImported int64
+}
+
 type QuestDBRest struct {
-	client  *http.Client
-	baseURL *url.URL
+	client      *http.Client
+	baseURL     *url.URL
+	credentials credentials
+}
+
+// credentials authenticate the requests with a bearer token, or with basic auth when there is no token
+type credentials struct {
+	username string
+	password string
+	token    string
 }
 
 func (q *QuestDBRest) connect() error {
@@ -48,20 +89,54 @@ func (q *QuestDBRest) connect() error {
 	return nil
 }
 
-func NewQuestDBRest(host string) (*QuestDBRest, error) {
-	client := &http.Client{
-		Timeout:time.Duration(60*time.Second),
+// NewQuestDBRest returns the client of the REST API at options.Host, with the credentials, TLS and
+// transport settings of options.
+func NewQuestDBRest(options Options) (*QuestDBRest, error) {
+	baseUrl, err := url.Parse(options.Host)
+	if err != nil {
+		return nil, err
 	}
-	baseUrl, err := url.Parse(host)
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   options.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        options.MaxIdleConns,
+		MaxIdleConnsPerHost: options.MaxIdleConns,
+		IdleConnTimeout:     options.IdleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if baseUrl.Scheme == "https" {
+		if transport.TLSClientConfig, err = options.TLS.Config(); err != nil {
+			return nil, err
+		}
+	}
+	credentials, err := options.credentials()
 	if err != nil {
 		return nil, err
 	}
 	return &QuestDBRest{
-		client:  client,
-		baseURL: baseUrl,
+		client: &http.Client{
+			Timeout:   options.Timeout,
+			Transport: transport,
+		},
+		baseURL:     baseUrl,
+		credentials: credentials,
 	}, nil
 }
 
+// do sends the request with the credentials of the client
+func (q *QuestDBRest) do(req *http.Request) (*http.Response, error) {
+	switch {
+	case q.credentials.token != "":
+		req.Header.Set("Authorization", "Bearer "+q.credentials.token)
+	case q.credentials.username != "":
+		req.SetBasicAuth(q.credentials.username, q.credentials.password)
+	}
+	return q.client.Do(req)
+}
+
 func (q *QuestDBRest) restRequest(query string) (*questDBResponse, error) {
 	execRel := &url.URL{Path: "/exec"}
 	endpoint := q.baseURL.ResolveReference(execRel)
@@ -72,15 +147,17 @@ func (q *QuestDBRest) restRequest(query string) (*questDBResponse, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp, err := q.client.Do(req)
-	defer resp.Body.Close()
-
-	results := questDBResponse{}
+	resp, err := q.do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
+	results := questDBResponse{}
 	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("query failed: %s", resp.Status)
+		}
 		return nil, err
 	}
 
@@ -120,9 +197,75 @@ func (q *QuestDBRest) Exec(query string) (Results, error) {
 	}, nil
 }
 
+// Import appends the CSV data to the table through the /imp endpoint, the first line of data is the
+// header. The data is streamed to QuestDB as it is read.
+func (q *QuestDBRest) Import(options ImportOptions, data io.Reader) (ImportResult, error) {
+	schema, err := json.Marshal(options.Schema)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	endpoint := q.baseURL.ResolveReference(&url.URL{Path: "/imp"})
+	parameters := url.Values{}
+	parameters.Add("name", options.Table)
+	parameters.Add("fmt", "json")
+	parameters.Add("forceHeader", "true")
+	if options.Timestamp != "" {
+		parameters.Add("timestamp", options.Timestamp)
+	}
+	if options.PartitionBy != "" {
+		parameters.Add("partitionBy", options.PartitionBy)
+	}
+	endpoint.RawQuery = parameters.Encode()
+
+	body, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+	go func() {
+		bodyWriter.CloseWithError(writeImportForm(form, schema, options.Table, data))
+	}()
+	req, err := http.NewRequest("POST", endpoint.String(), body)
+	if err != nil {
+		body.Close()
+		return ImportResult{}, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := q.do(req)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer resp.Body.Close()
+
+	results := questDBImportResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return ImportResult{}, fmt.Errorf("import into %s failed with status %d: %w", options.Table, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || results.Status != "OK" {
+		return ImportResult{}, fmt.Errorf("import into %s failed: %s", options.Table, results.Status)
+	}
+	return ImportResult{
+		Imported: results.RowsImported,
+		Rejected: results.RowsRejected,
+	}, nil
+}
+
+// writeImportForm writes the multipart form of an import, the schema must precede the data.
+func writeImportForm(form *multipart.Writer, schema []byte, table string, data io.Reader) error {
+	if err := form.WriteField("schema", string(schema)); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("data", table+".csv")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, data); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// Get returns the current row, nil before Next is called and after the last row.
 func (r *Row) Get() []interface{} {
-	if r.cursor < 0 && len(r.Dataset) != 0{
-		return r.Dataset[0]
+	if r.cursor < 0 || r.cursor >= len(r.Dataset) {
+		return nil
 	}
 	return r.Dataset[r.cursor]
 }
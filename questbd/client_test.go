@@ -0,0 +1,192 @@
+package questbd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile writes the content to a file of dir and returns its path
+func writeFile(t *testing.T, dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serverCA writes the certificate of the TLS server, so clients trust it
+func serverCA(t *testing.T, dir string, server *httptest.Server) string {
+	return writeFile(t, dir, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+// clientCertificate generates a self-signed client certificate, it returns the certificate and the
+// paths of its PEM files.
+func clientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "jaeger-collector"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := writeFile(t, dir, "client.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPath := writeFile(t, dir, "client-key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certificate, certPath, keyPath
+}
+
+// authorizedServer answers the queries sent with the authorization header
+func authorizedServer(t *testing.T, authorization string) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != authorization {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"query": r.URL.Query().Get("query"), "count": 0})
+	}))
+}
+
+func TestClientBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "questdb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// admin:quest
+	server := authorizedServer(t, "Basic YWRtaW46cXVlc3Q=")
+	defer server.Close()
+
+	options := Options{Host: server.URL, Username: "admin", PasswordFile: writeFile(t, dir, "password", []byte("quest\n"))}
+	options.TLS.CAPath = serverCA(t, dir, server)
+	client, err := NewQuestDBRest(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Exec("SELECT 1"); err != nil {
+		t.Errorf("authenticated query failed: %v", err)
+	}
+
+	options.Username = "guest"
+	client, err = NewQuestDBRest(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Exec("SELECT 1"); err == nil {
+		t.Error("query with wrong credentials succeeded")
+	}
+}
+
+func TestClientBearerToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "questdb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := authorizedServer(t, "Bearer s3cr3t")
+	defer server.Close()
+	ca := serverCA(t, dir, server)
+
+	fromFile := Options{Host: server.URL, TokenFile: writeFile(t, dir, "token", []byte("s3cr3t\n"))}
+	fromFile.TLS.CAPath = ca
+	os.Setenv(tokenEnv, "s3cr3t")
+	defer os.Unsetenv(tokenEnv)
+	fromEnv := Options{Host: server.URL}
+	fromEnv.TLS.CAPath = ca
+	for name, options := range map[string]Options{"file": fromFile, "environment": fromEnv} {
+		client, err := NewQuestDBRest(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Exec("SELECT 1"); err != nil {
+			t.Errorf("query with the token from the %s failed: %v", name, err)
+		}
+	}
+}
+
+func TestClientUntrustedServer(t *testing.T) {
+	server := authorizedServer(t, "")
+	defer server.Close()
+
+	client, err := NewQuestDBRest(Options{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Exec("SELECT 1"); err == nil {
+		t.Error("query to a server signed by an unknown authority succeeded")
+	}
+}
+
+func TestClientMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "questdb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certificate, certPath, keyPath := clientCertificate(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+	var subject string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.TLS.PeerCertificates[0].Subject.CommonName
+		json.NewEncoder(w).Encode(map[string]interface{}{"query": r.URL.Query().Get("query"), "count": 0})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	options := Options{Host: server.URL}
+	options.TLS.CAPath = serverCA(t, dir, server)
+	anonymous, err := NewQuestDBRest(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.Exec("SELECT 1"); err == nil {
+		t.Error("query without client certificate succeeded")
+	}
+
+	options.TLS.CertPath = certPath
+	options.TLS.KeyPath = keyPath
+	client, err := NewQuestDBRest(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Exec("SELECT 1"); err != nil {
+		t.Fatalf("query with the client certificate failed: %v", err)
+	}
+	if subject != "jaeger-collector" {
+		t.Errorf("server authenticated %q, expected the client certificate", subject)
+	}
+}
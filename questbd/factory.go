@@ -2,8 +2,16 @@ package questbd
 
 import (
 	"flag"
+	"os"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/pkg/distributedlock"
+	"github.com/jaegertracing/jaeger/pkg/multierror"
 	"github.com/jaegertracing/jaeger/storage/dependencystore"
+	"github.com/jaegertracing/jaeger/storage/samplingstore"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/jaeger-storages/health"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
 	"github.com/spf13/viper"
 	"github.com/uber/jaeger-lib/metrics"
 	"go.uber.org/zap"
@@ -11,15 +19,25 @@ import (
 
 
 type Factory struct {
-	options Options
-	questDB *QuestDBRest
-	writer *Writer
+	options   Options
+	questDB   *QuestDBRest
+	writer    *Writer
+	retention *RetentionJob
 }
 
 func NewFactory() *Factory {
 	return &Factory{
-		options:Options{
-			Host:"http://localhost:9000",
+		options: Options{
+			Host:              "http://localhost:9000",
+			PartitionBy:       defaultPartitionBy,
+			Options:           spans.DefaultOptions(),
+			RetentionInterval: defaultRetentionInterval,
+			BulkThreshold:     defaultBulkThreshold,
+			Timeout:           defaultTimeout,
+			DialTimeout:       defaultDialTimeout,
+			IdleConnTimeout:   defaultIdleConnTimeout,
+			MaxIdleConns:      defaultMaxIdleConns,
+			Migrations:        MigrationsApply,
 		},
 	}
 }
@@ -34,19 +52,54 @@ func (f *Factory) InitFromViper(v *viper.Viper) {
 }
 
 func (f *Factory) Initialize(metricsFactory metrics.Factory, zapLogger *zap.Logger) error {
-	client, err := NewQuestDBRest(f.options.Host)
+	if err := f.options.Validate(); err != nil {
+		return err
+	}
+	client, err := NewQuestDBRest(f.options)
 	f.questDB = client
 
 	if err != nil {
 		return err
 	}
 
-	f.writer = NewWriter(f.questDB)
+	f.options.PartitionBy = strings.ToUpper(f.options.PartitionBy)
+
+	if err := f.migrate(zapLogger); err != nil {
+		return err
+	}
+
+	f.writer = NewWriter(f.questDB, f.options, metricsFactory, zapLogger)
 	f.writer.start()
+	if err := health.Startup(f.options.HealthCheck, f.HealthChecks(), zapLogger); err != nil {
+		return err
+	}
+
+	if f.options.Retention > 0 {
+		f.retention = NewRetentionJob(f.questDB, f.options, metricsFactory, zapLogger)
+		if err := f.retention.Start(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// migrate handles the schema migrations according to the migrations option
+func (f *Factory) migrate(logger *zap.Logger) error {
+	migrator := NewMigrator(f.questDB, f.options)
+	switch f.options.Migrations {
+	case MigrationsApply:
+		applied, err := migrator.Migrate()
+		for _, migration := range applied {
+			logger.Info("Applied schema migration", zap.Int("version", migration.Version), zap.String("description", migration.Description))
+		}
+		return err
+	case MigrationsCheck:
+		return migrator.Check()
+	}
+	return nil
+}
+
 func (f *Factory) CreateSpanReader() (spanstore.Reader, error) {
 	return f.writer, nil
 }
@@ -55,9 +108,49 @@ func (f *Factory) CreateSpanWriter() (spanstore.Writer, error) {
 	return f.writer, nil
 }
 
+// CreateMetricsReader returns the RED metrics API computed from the traces table
+func (f *Factory) CreateMetricsReader() (*MetricsReader, error) {
+	return NewMetricsReader(f.questDB, f.options), nil
+}
+
+// CreateSamplingStore returns the adaptive sampling store, creating its tables if needed
+func (f *Factory) CreateSamplingStore() (samplingstore.Store, error) {
+	store := NewSamplingStore(f.questDB)
+	if err := store.CreateTables(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// CreateLock returns the lock used to elect the collector that computes sampling probabilities
+func (f *Factory) CreateLock() (distributedlock.Lock, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	lock := NewLock(f.questDB, hostname)
+	if err := lock.CreateTable(); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
 func (f *Factory) CreateDependencyReader() (dependencystore.Reader, error) {
 	return nil, nil
 }
+
+// Close stores the buffered spans and stops the retention job
 func (f *Factory) Close() error {
-	return nil
+	var errs []error
+	if f.writer != nil {
+		if err := f.writer.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if f.retention != nil {
+		if err := f.retention.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return multierror.Wrap(errs)
 }
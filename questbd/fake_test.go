@@ -0,0 +1,168 @@
+package questbd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeResult is the answer of fakeQuestDB to a query, the rows are sliced with the limit parameter.
+type fakeResult struct {
+	columns []string
+	dataset [][]interface{}
+	err     string
+}
+
+// fakeImport is a CSV import received by fakeQuestDB
+type fakeImport struct {
+	parameters map[string]string
+	schema     []ImportColumn
+	records    [][]string
+}
+
+// fakeQuestDB serves /exec and /imp, answering the queries with the results of exec and recording
+// the queries and imports. It must be closed.
+type fakeQuestDB struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	queries []string
+	imports []fakeImport
+	exec    func(query string) fakeResult
+}
+
+func newFakeQuestDB(t *testing.T, exec func(query string) fakeResult) *fakeQuestDB {
+	fake := &fakeQuestDB{exec: exec}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/exec", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		fake.mtx.Lock()
+		fake.queries = append(fake.queries, query)
+		fake.mtx.Unlock()
+		result := fakeResult{}
+		if fake.exec != nil {
+			result = fake.exec(query)
+		}
+		if result.err != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"query": query, "error": result.err})
+			return
+		}
+		dataset := result.dataset
+		if dataset == nil {
+			dataset = [][]interface{}{}
+		}
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			dataset = sliceLimit(t, dataset, limit)
+		}
+		columns := make([]map[string]string, len(result.columns))
+		for i, column := range result.columns {
+			columns[i] = map[string]string{"name": column, "type": "STRING"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"query":   query,
+			"columns": columns,
+			"dataset": dataset,
+			"count":   len(dataset),
+		})
+	})
+	mux.HandleFunc("/imp", func(w http.ResponseWriter, r *http.Request) {
+		received := fakeImport{parameters: map[string]string{}}
+		for name := range r.URL.Query() {
+			received.parameters[name] = r.URL.Query().Get(name)
+		}
+		if err := json.Unmarshal([]byte(r.FormValue("schema")), &received.schema); err != nil {
+			t.Errorf("decoding the import schema: %v", err)
+		}
+		file, _, err := r.FormFile("data")
+		if err != nil {
+			t.Errorf("reading the import data: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if received.records, err = csv.NewReader(file).ReadAll(); err != nil {
+			t.Errorf("parsing the import data: %v", err)
+		}
+		fake.mtx.Lock()
+		fake.imports = append(fake.imports, received)
+		fake.mtx.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       "OK",
+			"rowsImported": len(received.records) - 1,
+			"rowsRejected": 0,
+		})
+	})
+	fake.Server = httptest.NewServer(mux)
+	return fake
+}
+
+// sliceLimit returns the rows of dataset within the lo,hi limit parameter
+func sliceLimit(t *testing.T, dataset [][]interface{}, limit string) [][]interface{} {
+	bounds := strings.Split(limit, ",")
+	lo, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		t.Errorf("invalid limit %q", limit)
+	}
+	hi, err := strconv.Atoi(bounds[len(bounds)-1])
+	if err != nil {
+		t.Errorf("invalid limit %q", limit)
+	}
+	if len(bounds) == 1 {
+		lo = 0
+	}
+	if lo > len(dataset) {
+		lo = len(dataset)
+	}
+	if hi > len(dataset) {
+		hi = len(dataset)
+	}
+	return dataset[lo:hi]
+}
+
+// client returns a client of the fake
+func (f *fakeQuestDB) client(t *testing.T) *QuestDBRest {
+	client, err := NewQuestDBRest(Options{Host: f.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// recorded returns the queries received so far
+func (f *fakeQuestDB) recorded() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]string{}, f.queries...)
+}
+
+// received returns the imports received so far
+func (f *fakeQuestDB) received() []fakeImport {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]fakeImport{}, f.imports...)
+}
+
+// matching returns the recorded queries containing the fragment
+func (f *fakeQuestDB) matching(fragment string) []string {
+	var matching []string
+	for _, query := range f.recorded() {
+		if strings.Contains(query, fragment) {
+			matching = append(matching, query)
+		}
+	}
+	return matching
+}
+
+// columnsResult answers table_columns queries with the columns
+func columnsResult(columns ...string) fakeResult {
+	dataset := make([][]interface{}, len(columns))
+	for i, column := range columns {
+		dataset[i] = []interface{}{column}
+	}
+	return fakeResult{columns: []string{"column"}, dataset: dataset}
+}
@@ -0,0 +1,60 @@
+package questbd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rubenvp8510/jaeger-storages/health"
+)
+
+// HealthChecks returns the checks of the connectivity to QuestDB and of the schema of the traces table.
+func (f *Factory) HealthChecks() []health.Check {
+	return []health.Check{
+		{Name: "questdb", Run: func(ctx context.Context) error {
+			return health.Await(ctx, func() error {
+				_, err := f.questDB.Query("SELECT 1")
+				return err
+			})
+		}},
+		{Name: "questdb-schema", Run: func(ctx context.Context) error {
+			return health.Await(ctx, f.writer.mainTable.VerifySchema)
+		}},
+	}
+}
+
+// CheckHealth runs the health checks, for readiness probes.
+func (f *Factory) CheckHealth(ctx context.Context) health.Report {
+	return health.Run(ctx, f.HealthChecks())
+}
+
+// VerifySchema returns an error when a column written by the writer is missing from the table or
+// has another type.
+func (t *Table) VerifySchema() error {
+	rows, err := t.questDB.Query(fmt.Sprintf("SELECT column, type FROM table_columns('%s')", t.name))
+	if err != nil {
+		return err
+	}
+	types := make(map[string]string, rows.Count())
+	for rows.Next() {
+		row := rows.Get()
+		types[fmt.Sprintf("%v", row[0])] = fmt.Sprintf("%v", row[1])
+	}
+	if len(types) == 0 {
+		return fmt.Errorf("table %s doesn't exist", t.name)
+	}
+	var mismatches []string
+	for _, column := range baseColumns {
+		columnType, ok := types[column]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, column+" is missing")
+		case !strings.EqualFold(columnType, baseColumnTypes[column]):
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s instead of %s", column, columnType, baseColumnTypes[column]))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("unexpected schema of table %s: %s", t.name, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
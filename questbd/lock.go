@@ -0,0 +1,173 @@
+package questbd
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	leasesTable = "sampling_leases"
+
+	// leaseHistory is the number of ttls of leases replayed to find the holder of a resource, older
+	// leases expired before any lease that can still be held was written.
+	leaseHistory = 3
+	// maxLeaseHistory is the history replayed by a Forfeit not preceded by an Acquire of this Lock
+	maxLeaseHistory = time.Hour
+)
+
+// Lock implements distributedlock.Lock with a lease table. QuestDB tables are append only, so every
+// acquisition, renewal and release appends a lease, timestamped by the QuestDB clock so the clocks of
+// the collectors don't matter. The holder is found by replaying the leases in order: a lease is
+// granted when the resource isn't held, the previous holder's lease expired or it is written by the
+// holder, the other leases are ignored. Every collector replays the same leases, so two owners
+// racing for a free resource agree that the first one wins.
+type Lock struct {
+	questDB *QuestDBRest
+	owner   string
+	mtx     sync.Mutex
+	// ttls are the lease durations of the acquired resources, they bound the history to replay
+	ttls map[string]time.Duration
+}
+
+// lease is the holder of a resource found by replaying the leases, at the time of the QuestDB clock
+type lease struct {
+	owner     string
+	expiresAt time.Time
+	now       time.Time
+}
+
+// held returns whether the lease is still held by someone
+func (l lease) held() bool {
+	return l.owner != "" && l.now.Before(l.expiresAt)
+}
+
+// NewLock returns a lock acquiring leases on behalf of owner, usually the hostname of the collector.
+func NewLock(questDB *QuestDBRest, owner string) *Lock {
+	return &Lock{
+		questDB: questDB,
+		owner:   owner,
+		ttls:    make(map[string]time.Duration),
+	}
+}
+
+// CreateTable creates the leases table if it doesn't exist yet.
+func (l *Lock) CreateTable() error {
+	const leaseTable = "CREATE TABLE %s ( " +
+		"ts         timestamp," +
+		"resource   symbol," +
+		"owner      string," +
+		"expires_at timestamp" +
+		") timestamp(ts)"
+	table := &Table{name: leasesTable, questDB: l.questDB}
+	exist, err := table.Exist()
+	if err != nil || exist {
+		return err
+	}
+	_, err = l.questDB.Exec(fmt.Sprintf(leaseTable, leasesTable))
+	return err
+}
+
+// history returns the duration of the leases to replay for resource
+func (l *Lock) history(resource string) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if ttl, ok := l.ttls[resource]; ok {
+		return leaseHistory * ttl
+	}
+	return maxLeaseHistory
+}
+
+// holder replays the recent leases of resource and returns the granted one, empty when the resource
+// was never leased.
+func (l *Lock) holder(resource string) (lease, error) {
+	query := fmt.Sprintf("SELECT ts, owner, expires_at, systimestamp() FROM %s "+
+		"WHERE resource = %s AND ts > dateadd('s', %d, systimestamp()) ORDER BY ts, owner",
+		leasesTable, escape(resource), -seconds(l.history(resource)))
+	rows, err := l.questDB.Query(query)
+	if err != nil {
+		return lease{}, err
+	}
+	holder := lease{}
+	for rows.Next() {
+		row := rows.Get()
+		var ts, expiresAt time.Time
+		if err := parseTimestamps(row, []int{0, 2, 3}, &ts, &expiresAt, &holder.now); err != nil {
+			return lease{}, err
+		}
+		owner := fmt.Sprintf("%v", row[1])
+		if holder.owner == "" || !ts.Before(holder.expiresAt) || owner == holder.owner {
+			holder.owner = owner
+			holder.expiresAt = expiresAt
+		}
+	}
+	return holder, nil
+}
+
+// parseTimestamps parses the timestamp columns of row into values
+func parseTimestamps(row []interface{}, columns []int, values ...*time.Time) error {
+	for i, column := range columns {
+		timestamp, err := time.Parse(time.RFC3339Nano, fmt.Sprintf("%v", row[column]))
+		if err != nil {
+			return err
+		}
+		*values[i] = timestamp
+	}
+	return nil
+}
+
+// appendLease appends a lease of resource expiring after ttl, timestamped by QuestDB.
+func (l *Lock) appendLease(resource string, ttl time.Duration) error {
+	query := fmt.Sprintf("INSERT INTO %s ( ts, resource, owner, expires_at ) "+
+		"VALUES ( systimestamp(), %s, %s, dateadd('s', %d, systimestamp()) )",
+		leasesTable, escape(resource), escape(l.owner), seconds(ttl))
+	_, err := l.questDB.Exec(query)
+	return err
+}
+
+// Acquire acquires a lease of duration ttl around resource, it succeeds if the resource is not
+// leased, the lease expired or this owner already holds it. The lease is appended and the leases
+// are replayed again, as another owner may have appended one first.
+func (l *Lock) Acquire(resource string, ttl time.Duration) (bool, error) {
+	l.mtx.Lock()
+	l.ttls[resource] = ttl
+	l.mtx.Unlock()
+
+	holder, err := l.holder(resource)
+	if err != nil {
+		return false, err
+	}
+	if holder.held() && holder.owner != l.owner {
+		return false, nil
+	}
+	if err := l.appendLease(resource, ttl); err != nil {
+		return false, err
+	}
+	holder, err = l.holder(resource)
+	if err != nil {
+		return false, err
+	}
+	return holder.held() && holder.owner == l.owner, nil
+}
+
+// Forfeit releases the lease around resource if this owner holds it, with a lease expiring as soon
+// as it is written.
+func (l *Lock) Forfeit(resource string) (bool, error) {
+	holder, err := l.holder(resource)
+	if err != nil {
+		return false, err
+	}
+	if !holder.held() || holder.owner != l.owner {
+		return false, nil
+	}
+	if err := l.appendLease(resource, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// seconds rounds the duration up to whole seconds
+func seconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
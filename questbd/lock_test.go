@@ -0,0 +1,261 @@
+package questbd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	appendLeaseStatement = regexp.MustCompile(`^INSERT INTO sampling_leases .* VALUES \( systimestamp\(\), '([^']*)', '([^']*)', dateadd\('s', (-?\d+), systimestamp\(\)\) \)$`)
+	selectLeasesQuery    = regexp.MustCompile(`^SELECT ts, owner, expires_at, systimestamp\(\) FROM sampling_leases WHERE resource = '([^']*)' AND ts > dateadd\('s', (-?\d+), systimestamp\(\)\) ORDER BY ts, owner$`)
+)
+
+// leaseLog emulates the leases table of QuestDB, with a clock that ticks a millisecond per statement.
+type leaseLog struct {
+	mtx    sync.Mutex
+	clock  time.Time
+	leases []leaseRow
+	// barrier holds the first queries of the leases until that many were received, so the
+	// acquisitions all see the resource free
+	barrier int
+	arrived int
+	release chan struct{}
+}
+
+type leaseRow struct {
+	ts        time.Time
+	resource  string
+	owner     string
+	expiresAt time.Time
+}
+
+func newLeaseLog(clock time.Time) *leaseLog {
+	return &leaseLog{clock: clock, release: make(chan struct{})}
+}
+
+func formatTimestamp(timestamp time.Time) string {
+	return timestamp.UTC().Format("2006-01-02T15:04:05.000000Z")
+}
+
+// advance moves the clock forward
+func (l *leaseLog) advance(duration time.Duration) {
+	l.mtx.Lock()
+	l.clock = l.clock.Add(duration)
+	l.mtx.Unlock()
+}
+
+// wait blocks the query until the barrier is reached
+func (l *leaseLog) wait() {
+	l.mtx.Lock()
+	if l.arrived >= l.barrier {
+		l.mtx.Unlock()
+		return
+	}
+	l.arrived++
+	if l.arrived == l.barrier {
+		close(l.release)
+	}
+	l.mtx.Unlock()
+	<-l.release
+}
+
+func (l *leaseLog) exec(query string) fakeResult {
+	if query == "SHOW TABLES" {
+		return fakeResult{columns: []string{"table"}, dataset: [][]interface{}{{leasesTable}}}
+	}
+	if match := selectLeasesQuery.FindStringSubmatch(query); match != nil {
+		l.wait()
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		l.clock = l.clock.Add(time.Millisecond)
+		offset, _ := strconv.Atoi(match[2])
+		since := l.clock.Add(time.Duration(offset) * time.Second)
+		var leases []leaseRow
+		for _, lease := range l.leases {
+			if lease.resource == match[1] && lease.ts.After(since) {
+				leases = append(leases, lease)
+			}
+		}
+		sort.SliceStable(leases, func(i, j int) bool {
+			if leases[i].ts.Equal(leases[j].ts) {
+				return leases[i].owner < leases[j].owner
+			}
+			return leases[i].ts.Before(leases[j].ts)
+		})
+		result := fakeResult{columns: []string{"ts", "owner", "expires_at", "systimestamp"}, dataset: [][]interface{}{}}
+		for _, lease := range leases {
+			result.dataset = append(result.dataset, []interface{}{
+				formatTimestamp(lease.ts), lease.owner, formatTimestamp(lease.expiresAt), formatTimestamp(l.clock),
+			})
+		}
+		return result
+	}
+	if match := appendLeaseStatement.FindStringSubmatch(query); match != nil {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		l.clock = l.clock.Add(time.Millisecond)
+		ttl, _ := strconv.Atoi(match[3])
+		l.leases = append(l.leases, leaseRow{
+			ts:        l.clock,
+			resource:  match[1],
+			owner:     match[2],
+			expiresAt: l.clock.Add(time.Duration(ttl) * time.Second),
+		})
+		return fakeResult{}
+	}
+	return fakeResult{err: fmt.Sprintf("unexpected query %q", query)}
+}
+
+func newLeaseFake(t *testing.T, log *leaseLog) (*fakeQuestDB, func(owner string) *Lock) {
+	fake := newFakeQuestDB(t, log.exec)
+	return fake, func(owner string) *Lock {
+		lock := NewLock(fake.client(t), owner)
+		if err := lock.CreateTable(); err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+}
+
+func TestAcquireConcurrently(t *testing.T) {
+	const owners = 8
+	log := newLeaseLog(time.Now())
+	log.barrier = owners
+	fake, newLock := newLeaseFake(t, log)
+	defer fake.Close()
+
+	locks := make([]*Lock, owners)
+	for i := range locks {
+		locks[i] = newLock(fmt.Sprintf("collector-%d", i))
+	}
+	acquired := make([]bool, owners)
+	errs := make([]error, owners)
+	var wg sync.WaitGroup
+	for i := range locks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			acquired[i], errs[i] = locks[i].Acquire("sampling", time.Minute)
+		}(i)
+	}
+	wg.Wait()
+
+	var winners []int
+	for i := range locks {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if acquired[i] {
+			winners = append(winners, i)
+		}
+	}
+	if len(winners) != 1 {
+		t.Fatalf("lock acquired by %v, expected a single owner", winners)
+	}
+	if appended := len(fake.matching("INSERT INTO sampling_leases")); appended != owners {
+		t.Errorf("%d leases appended, expected every owner to race with %d", appended, owners)
+	}
+
+	// the losers keep losing while the winner holds the lease, and it can renew it
+	for i, lock := range locks {
+		acquired, err := lock.Acquire("sampling", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acquired != (i == winners[0]) {
+			t.Errorf("collector-%d acquired the lock: %v", i, acquired)
+		}
+	}
+}
+
+func TestAcquireHeldLock(t *testing.T) {
+	log := newLeaseLog(time.Now())
+	fake, newLock := newLeaseFake(t, log)
+	defer fake.Close()
+	first, second := newLock("first"), newLock("second")
+
+	if acquired, err := first.Acquire("sampling", time.Minute); err != nil || !acquired {
+		t.Fatalf("first acquisition: %v, %v", acquired, err)
+	}
+	if acquired, err := second.Acquire("sampling", time.Minute); err != nil || acquired {
+		t.Fatalf("acquisition of a held lock: %v, %v", acquired, err)
+	}
+	if appended := len(fake.matching("INSERT INTO sampling_leases")); appended != 1 {
+		t.Errorf("%d leases appended, expected none for a held lock", appended)
+	}
+	if acquired, err := first.Acquire("other", time.Minute); err != nil || !acquired {
+		t.Errorf("acquisition of another resource: %v, %v", acquired, err)
+	}
+
+	log.advance(2 * time.Minute)
+	if acquired, err := second.Acquire("sampling", time.Minute); err != nil || !acquired {
+		t.Errorf("acquisition of an expired lock: %v, %v", acquired, err)
+	}
+	if acquired, err := first.Acquire("sampling", time.Minute); err != nil || acquired {
+		t.Errorf("acquisition of a lock taken over: %v, %v", acquired, err)
+	}
+}
+
+func TestAcquireUsesQuestDBClock(t *testing.T) {
+	// leases written with the collector clock would all look expired
+	log := newLeaseLog(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC))
+	fake, newLock := newLeaseFake(t, log)
+	defer fake.Close()
+	first, second := newLock("first"), newLock("second")
+
+	if acquired, err := first.Acquire("sampling", time.Minute); err != nil || !acquired {
+		t.Fatalf("first acquisition: %v, %v", acquired, err)
+	}
+	if acquired, err := second.Acquire("sampling", time.Minute); err != nil || acquired {
+		t.Errorf("acquisition of a held lock: %v, %v", acquired, err)
+	}
+}
+
+func TestForfeit(t *testing.T) {
+	log := newLeaseLog(time.Now())
+	fake, newLock := newLeaseFake(t, log)
+	defer fake.Close()
+	first, second := newLock("first"), newLock("second")
+
+	if forfeited, err := first.Forfeit("sampling"); err != nil || forfeited {
+		t.Errorf("forfeit of a lock never acquired: %v, %v", forfeited, err)
+	}
+	if acquired, err := first.Acquire("sampling", time.Minute); err != nil || !acquired {
+		t.Fatalf("first acquisition: %v, %v", acquired, err)
+	}
+	if forfeited, err := second.Forfeit("sampling"); err != nil || forfeited {
+		t.Errorf("forfeit of a lock held by another owner: %v, %v", forfeited, err)
+	}
+	if forfeited, err := first.Forfeit("sampling"); err != nil || !forfeited {
+		t.Fatalf("forfeit of the held lock: %v, %v", forfeited, err)
+	}
+	if forfeited, err := first.Forfeit("sampling"); err != nil || forfeited {
+		t.Errorf("second forfeit: %v, %v", forfeited, err)
+	}
+	if acquired, err := second.Acquire("sampling", time.Minute); err != nil || !acquired {
+		t.Errorf("acquisition of a forfeited lock: %v, %v", acquired, err)
+	}
+	if acquired, err := first.Acquire("sampling", time.Minute); err != nil || acquired {
+		t.Errorf("acquisition of a lock taken after the forfeit: %v, %v", acquired, err)
+	}
+}
+
+func TestForfeitExpiredLock(t *testing.T) {
+	log := newLeaseLog(time.Now())
+	fake, newLock := newLeaseFake(t, log)
+	defer fake.Close()
+	lock := newLock("first")
+
+	if acquired, err := lock.Acquire("sampling", time.Minute); err != nil || !acquired {
+		t.Fatalf("acquisition: %v, %v", acquired, err)
+	}
+	log.advance(2 * time.Minute)
+	if forfeited, err := lock.Forfeit("sampling"); err != nil || forfeited {
+		t.Errorf("forfeit of an expired lock: %v, %v", forfeited, err)
+	}
+}
@@ -0,0 +1,160 @@
+package questbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+)
+
+var (
+	// ErrInvalidStep is returned by MetricsReader if the query step isn't a whole number of seconds,
+	// the buckets are sampled by second.
+	ErrInvalidStep = errors.New("step must be a whole number of seconds")
+)
+
+var defaultQuantiles = []float64{0.5, 0.95, 0.99}
+
+// MetricsQuery contains the parameters of a RED metrics query, empty service or operation names
+// means all of them.
+type MetricsQuery struct {
+	ServiceName string
+	// GroupByOperation returns metrics per operation instead of per service
+	GroupByOperation bool
+	OperationName    string
+	StartTime        time.Time
+	EndTime          time.Time
+	Step             time.Duration
+	Quantiles        []float64
+}
+
+// MetricPoint contains the metrics of a service or operation within a time bucket.
+type MetricPoint struct {
+	Timestamp     time.Time
+	ServiceName   string
+	OperationName string
+	// CallRate is the number of calls per second
+	CallRate float64
+	// ErrorRate is the fraction of calls with the error tag
+	ErrorRate float64
+	// Latencies maps each requested quantile to its latency
+	Latencies map[float64]time.Duration
+}
+
+// MetricsReader returns call rate, error rate and latency metrics computed from the traces table.
+type MetricsReader struct {
+	questDB *QuestDBRest
+	table   string
+	tenants tenancy.Resolver
+}
+
+func NewMetricsReader(questDB *QuestDBRest, options Options) *MetricsReader {
+	return &MetricsReader{
+		questDB: questDB,
+		table:   "traces",
+		tenants: options.Tenants(),
+	}
+}
+
+// errorCondition returns the condition matching spans with the error tag, or false if the error tag
+// column has never been created.
+func (m *MetricsReader) errorCondition() (string, error) {
+	column := tagColumn("error")
+	query := fmt.Sprintf("SELECT column FROM table_columns('%s') where column = %s", m.table, escape(column))
+	rows, err := m.questDB.Query(query)
+	if err != nil {
+		return "", err
+	}
+	if rows.Count() == 0 {
+		return "false", nil
+	}
+	return column + " = 'true'", nil
+}
+
+func (m *MetricsReader) buildMetricsQuery(query *MetricsQuery, quantiles []float64, errorCondition, tenant string) string {
+	keys := []string{"service_name"}
+	if query.GroupByOperation {
+		keys = append(keys, "operation_name")
+	}
+
+	columns := []string{"start_time"}
+	columns = append(columns, keys...)
+	columns = append(columns, "count() calls", fmt.Sprintf("sum(CASE WHEN %s THEN 1 ELSE 0 END) errors", errorCondition))
+	for i, quantile := range quantiles {
+		columns = append(columns, fmt.Sprintf("approx_percentile(duration, %g) latency_%d", quantile, i))
+	}
+
+	startTimeMax := query.EndTime.UTC().Format("2006-01-02T15:04:05.999Z")
+	startTimeMin := query.StartTime.UTC().Format("2006-01-02T15:04:05.999Z")
+	conditions := []string{"start_time <= " + escape(startTimeMax), "start_time >= " + escape(startTimeMin)}
+	if tenant != "" {
+		conditions = append(conditions, "tenant = "+escape(tenant))
+	}
+	if query.ServiceName != "" {
+		conditions = append(conditions, "service_name = "+escape(query.ServiceName))
+	}
+	if query.OperationName != "" {
+		conditions = append(conditions, "operation_name = "+escape(query.OperationName))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s timestamp(start_time) WHERE %s SAMPLE BY %ds ALIGN TO CALENDAR",
+		strings.Join(columns, ", "), m.table, strings.Join(conditions, " AND "), int64(query.Step.Seconds()))
+}
+
+// GetMetrics returns the metrics of every service, or operation, matching the query, one entry per
+// time bucket of the query step.
+func (m *MetricsReader) GetMetrics(ctx context.Context, query *MetricsQuery) ([]*MetricPoint, error) {
+	if query.Step < time.Second || query.Step%time.Second != 0 {
+		return nil, ErrInvalidStep
+	}
+	quantiles := query.Quantiles
+	if len(quantiles) == 0 {
+		quantiles = defaultQuantiles
+	}
+
+	tenant, err := m.tenants.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	errorCondition, err := m.errorCondition()
+	if err != nil {
+		return nil, err
+	}
+	rows := m.questDB.Stream(m.buildMetricsQuery(query, quantiles, errorCondition, tenant), 0)
+	defer rows.Close()
+
+	points := make([]*MetricPoint, 0)
+	for rows.Next() {
+		column := 0
+		point := &MetricPoint{
+			Timestamp: rows.Timestamp(column),
+			Latencies: make(map[float64]time.Duration, len(quantiles)),
+		}
+		column++
+		point.ServiceName = rows.String(column)
+		column++
+		if query.GroupByOperation {
+			point.OperationName = rows.String(column)
+			column++
+		}
+		calls := rows.Double(column)
+		errorCount := rows.Double(column + 1)
+		column += 2
+		point.CallRate = calls / query.Step.Seconds()
+		if calls > 0 {
+			point.ErrorRate = errorCount / calls
+		}
+		for i, quantile := range quantiles {
+			point.Latencies[quantile] = time.Duration(rows.Double(column+i)) * time.Microsecond
+		}
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
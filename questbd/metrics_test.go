@@ -0,0 +1,129 @@
+package questbd
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+)
+
+// metricsResult answers the error tag column lookup with the column and the metrics query with rows
+func metricsResult(columns []string, dataset ...[]interface{}) func(query string) fakeResult {
+	return func(query string) fakeResult {
+		if strings.Contains(query, "table_columns") {
+			return columnsResult(tagColumn("error"))
+		}
+		return fakeResult{columns: columns, dataset: dataset}
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	fake := newFakeQuestDB(t, metricsResult(
+		[]string{"start_time", "service_name", "operation_name", "calls", "errors", "latency_0", "latency_1"},
+		[]interface{}{"2020-01-01T00:00:00.000000Z", "frontend", "get", 120, 30, 1500, 20000.5},
+		[]interface{}{"2020-01-01T00:01:00.000000Z", "frontend", "get", 0, 0, nil, nil},
+	))
+	defer fake.Close()
+	reader := NewMetricsReader(fake.client(t), Options{Options: spans.Options{Tenancy: true}})
+
+	end := time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)
+	points, err := reader.GetMetrics(tenancy.WithTenant(context.Background(), "acme"), &MetricsQuery{
+		ServiceName:      "frontend",
+		GroupByOperation: true,
+		StartTime:        end.Add(-time.Hour),
+		EndTime:          end,
+		Step:             time.Minute,
+		Quantiles:        []float64{0.5, 0.99},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*MetricPoint{{
+		Timestamp:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ServiceName:   "frontend",
+		OperationName: "get",
+		CallRate:      2,
+		ErrorRate:     0.25,
+		Latencies:     map[float64]time.Duration{0.5: 1500 * time.Microsecond, 0.99: 20 * time.Millisecond},
+	}, {
+		Timestamp:     time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC),
+		ServiceName:   "frontend",
+		OperationName: "get",
+		Latencies:     map[float64]time.Duration{0.5: 0, 0.99: 0},
+	}}
+	if !reflect.DeepEqual(points, expected) {
+		t.Errorf("points are %+v, expected %+v", points, expected)
+	}
+
+	query := fake.matching("SAMPLE BY")
+	if len(query) != 1 {
+		t.Fatalf("queries are %q", fake.recorded())
+	}
+	for _, fragment := range []string{
+		"SELECT start_time, service_name, operation_name, count() calls",
+		"sum(CASE WHEN " + tagColumn("error") + " = 'true' THEN 1 ELSE 0 END) errors",
+		"approx_percentile(duration, 0.5) latency_0, approx_percentile(duration, 0.99) latency_1",
+		"tenant = 'acme' AND service_name = 'frontend'",
+		" SAMPLE BY 60s ALIGN TO CALENDAR",
+	} {
+		if !strings.Contains(query[0], fragment) {
+			t.Errorf("query %q doesn't contain %q", query[0], fragment)
+		}
+	}
+}
+
+func TestGetMetricsWithoutErrorColumn(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		return fakeResult{columns: []string{"column"}}
+	})
+	defer fake.Close()
+	reader := NewMetricsReader(fake.client(t), Options{})
+
+	now := time.Now()
+	if _, err := reader.GetMetrics(context.Background(), &MetricsQuery{StartTime: now.Add(-time.Hour), EndTime: now, Step: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	query := fake.matching("SAMPLE BY")
+	if len(query) != 1 || !strings.Contains(query[0], "sum(CASE WHEN false THEN 1 ELSE 0 END) errors") {
+		t.Errorf("queries are %q, expected no error", fake.recorded())
+	}
+	if strings.Contains(query[0], "operation_name") {
+		t.Errorf("query %q groups by operation", query[0])
+	}
+}
+
+func TestGetMetricsInvalidRow(t *testing.T) {
+	fake := newFakeQuestDB(t, metricsResult(
+		[]string{"start_time", "service_name", "calls", "errors", "latency_0"},
+		[]interface{}{"2020-01-01T00:00:00.000000Z", "frontend", "many", 0, 10},
+	))
+	defer fake.Close()
+	reader := NewMetricsReader(fake.client(t), Options{})
+
+	now := time.Now()
+	_, err := reader.GetMetrics(context.Background(), &MetricsQuery{StartTime: now.Add(-time.Hour), EndTime: now, Step: time.Minute, Quantiles: []float64{0.5}})
+	if err == nil {
+		t.Error("a row with a string count was decoded")
+	}
+}
+
+func TestGetMetricsInvalidStep(t *testing.T) {
+	fake := newFakeQuestDB(t, nil)
+	defer fake.Close()
+	reader := NewMetricsReader(fake.client(t), Options{})
+
+	now := time.Now()
+	for _, step := range []time.Duration{0, 500 * time.Millisecond, 1500 * time.Millisecond} {
+		_, err := reader.GetMetrics(context.Background(), &MetricsQuery{StartTime: now.Add(-time.Hour), EndTime: now, Step: step})
+		if err != ErrInvalidStep {
+			t.Errorf("step of %v returned %v, expected %v", step, err, ErrInvalidStep)
+		}
+	}
+	if queries := fake.recorded(); len(queries) != 0 {
+		t.Errorf("queries %q sent for invalid steps", queries)
+	}
+}
@@ -0,0 +1,236 @@
+package questbd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	migrationsTable = "schema_migrations"
+	// migrationsResource is the lease held while migrations are applied, so collectors starting
+	// together don't apply them twice
+	migrationsResource = "schema-migrations"
+	migrationsLease    = 5 * time.Minute
+	// migrationsRetry is how often a process waiting for the migrations of another one retries
+	migrationsRetry = time.Second
+
+	// MigrationsApply applies the pending migrations at startup
+	MigrationsApply = "apply"
+	// MigrationsCheck fails the startup when migrations are pending
+	MigrationsCheck = "check"
+	// MigrationsDisabled leaves the schema alone, it is managed with the questdb-schema command
+	MigrationsDisabled = "disabled"
+)
+
+var (
+	// ErrSchemaOutdated is returned by Migrator.Check when migrations are pending
+	ErrSchemaOutdated = errors.New("schema is outdated")
+	// ErrMigrationsLocked is returned by Migrator.Migrate when another process held the migrations
+	// lease for longer than the lease duration
+	ErrMigrationsLocked = errors.New("migrations are being applied by another process")
+)
+
+// Migration is a step of the schema of the trace store, Up must succeed on a schema it was already
+// applied to, as a migration interrupted before being recorded is applied again.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(traces *Table) error
+}
+
+// migrations are the steps of the schema, ordered by version. Released versions must not change.
+//
+// The traces tables created by releases without partitioning keep it, QuestDB can't partition an
+// existing table and copying it at startup would lose the spans written meanwhile. The retention
+// job refuses them: export the traces with the migrate command, drop the table and import them back.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create the traces table",
+		Up: func(traces *Table) error {
+			return traces.CreateIfNotExist(true)
+		},
+	},
+	{
+		Version:     2,
+		Description: "add the span_kind and tenant columns to tables created before them",
+		Up: func(traces *Table) error {
+			return traces.addTypedColumns(map[string]string{
+				"span_kind": "SYMBOL",
+				"tenant":    "SYMBOL",
+			})
+		},
+	},
+}
+
+// addTypedColumns adds the columns missing from the table with their types.
+func (t *Table) addTypedColumns(types map[string]string) error {
+	columns := make([]string, 0, len(types))
+	for column := range types {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	missing, err := t.NeedToCreate(columns)
+	if err != nil {
+		return err
+	}
+	for _, column := range missing {
+		if _, err := t.questDB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", t.name, column, types[column])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrator applies the migrations to the trace store and records the applied versions in the
+// schema_migrations table.
+type Migrator struct {
+	questDB    *QuestDBRest
+	traces     *Table
+	migrations []Migration
+	// lockWait is how long Migrate waits for the migrations lease held by another process
+	lockWait  time.Duration
+	lockRetry time.Duration
+}
+
+func NewMigrator(questDB *QuestDBRest, options Options) *Migrator {
+	return &Migrator{
+		questDB: questDB,
+		traces: &Table{
+			name:        "traces",
+			questDB:     questDB,
+			partitionBy: strings.ToUpper(options.PartitionBy),
+		},
+		migrations: migrations,
+		lockWait:   migrationsLease,
+		lockRetry:  migrationsRetry,
+	}
+}
+
+// createTable creates the schema_migrations table if it doesn't exist yet.
+func (m *Migrator) createTable() error {
+	const versionsTable = "CREATE TABLE %s ( " +
+		"version     int," +
+		"description string," +
+		"applied_at  timestamp" +
+		") timestamp(applied_at)"
+	table := &Table{name: migrationsTable, questDB: m.questDB}
+	exist, err := table.Exist()
+	if err != nil || exist {
+		return err
+	}
+	_, err = m.questDB.Exec(fmt.Sprintf(versionsTable, migrationsTable))
+	return err
+}
+
+// Latest returns the version of the last migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version the schema is migrated to, 0 when no migration was applied.
+func (m *Migrator) Version() (int, error) {
+	if err := m.createTable(); err != nil {
+		return 0, err
+	}
+	rows, err := m.questDB.Query(fmt.Sprintf("SELECT max(version) FROM %s", migrationsTable))
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, nil
+	}
+	// null when the table is empty
+	version, _ := rows.Get()[0].(float64)
+	return int(version), nil
+}
+
+// Pending returns the migrations not applied yet, in order.
+func (m *Migrator) Pending() ([]Migration, error) {
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Check returns ErrSchemaOutdated when migrations are pending.
+func (m *Migrator) Check() error {
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d migrations pending, up to version %d", ErrSchemaOutdated, len(pending), m.Latest())
+	}
+	return nil
+}
+
+// Migrate applies the pending migrations in order and returns the applied ones. It stops at the
+// first failing migration, the previous ones remain applied. When another process is applying them,
+// it waits for its lease to be released and only applies the migrations still pending.
+func (m *Migrator) Migrate() ([]Migration, error) {
+	owner, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	lock := NewLock(m.questDB, fmt.Sprintf("%s-%d", owner, os.Getpid()))
+	if err := lock.CreateTable(); err != nil {
+		return nil, err
+	}
+	if err := m.acquire(lock); err != nil {
+		return nil, err
+	}
+	defer lock.Forfeit(migrationsResource)
+
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, migration := range pending {
+		if err := migration.Up(m.traces); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		if err := m.record(migration); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// acquire waits for the migrations lease, ErrMigrationsLocked when it is still held after lockWait.
+func (m *Migrator) acquire(lock *Lock) error {
+	deadline := time.Now().Add(m.lockWait)
+	for {
+		acquired, err := lock.Acquire(migrationsResource, migrationsLease)
+		if err != nil || acquired {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrMigrationsLocked
+		}
+		time.Sleep(m.lockRetry)
+	}
+}
+
+func (m *Migrator) record(migration Migration) error {
+	query := fmt.Sprintf("INSERT INTO %s ( version, description, applied_at ) VALUES ( %s, %s, %s )",
+		migrationsTable, escape(migration.Version), escape(migration.Description), escape(time.Now().UnixNano()/1000))
+	_, err := m.questDB.Exec(query)
+	return err
+}
@@ -0,0 +1,322 @@
+package questbd
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	createTableStatement     = regexp.MustCompile(`^CREATE TABLE (\w+) `)
+	addColumnStatement       = regexp.MustCompile(`^ALTER TABLE traces ADD COLUMN (\w+) \w+$`)
+	recordMigrationStatement = regexp.MustCompile(`^INSERT INTO schema_migrations .* VALUES \( (\d+), `)
+)
+
+// schemaEmulator emulates the tables the migrations and their lock use
+type schemaEmulator struct {
+	mtx          sync.Mutex
+	leases       *leaseLog
+	tables       map[string]bool
+	traceColumns map[string]bool
+	versions     []int
+	// failing is a statement prefix that fails
+	failing string
+}
+
+func newSchemaEmulator() *schemaEmulator {
+	return &schemaEmulator{
+		leases:       newLeaseLog(time.Now()),
+		tables:       map[string]bool{},
+		traceColumns: map[string]bool{},
+	}
+}
+
+// withTraces creates the traces table with the columns, as a previous release did
+func (s *schemaEmulator) withTraces(columns ...string) *schemaEmulator {
+	s.tables["traces"] = true
+	for _, column := range columns {
+		s.traceColumns[column] = true
+	}
+	return s
+}
+
+func (s *schemaEmulator) exec(query string) fakeResult {
+	if strings.Contains(query, leasesTable) {
+		return s.leases.exec(query)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.failing != "" && strings.HasPrefix(query, s.failing) {
+		return fakeResult{err: "table busy"}
+	}
+	switch {
+	case query == "SHOW TABLES":
+		result := columnsResult(leasesTable)
+		for table := range s.tables {
+			result.dataset = append(result.dataset, []interface{}{table})
+		}
+		return result
+	case createTableStatement.MatchString(query):
+		table := createTableStatement.FindStringSubmatch(query)[1]
+		s.tables[table] = true
+		if table == "traces" {
+			for _, column := range baseColumns {
+				s.traceColumns[column] = true
+			}
+		}
+		return fakeResult{}
+	case strings.HasPrefix(query, "SELECT column FROM table_columns('traces') where column IN"):
+		var existing []string
+		for column := range s.traceColumns {
+			if strings.Contains(query, "'"+column+"'") {
+				existing = append(existing, column)
+			}
+		}
+		return columnsResult(existing...)
+	case addColumnStatement.MatchString(query):
+		s.traceColumns[addColumnStatement.FindStringSubmatch(query)[1]] = true
+		return fakeResult{}
+	case query == "SELECT max(version) FROM schema_migrations":
+		var max interface{}
+		for _, version := range s.versions {
+			if max == nil || version > max.(int) {
+				max = version
+			}
+		}
+		return fakeResult{columns: []string{"max"}, dataset: [][]interface{}{{max}}}
+	case recordMigrationStatement.MatchString(query):
+		version, _ := strconv.Atoi(recordMigrationStatement.FindStringSubmatch(query)[1])
+		s.versions = append(s.versions, version)
+		return fakeResult{}
+	}
+	return fakeResult{err: "unexpected query " + query}
+}
+
+func (s *schemaEmulator) recordedVersions() []int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]int{}, s.versions...)
+}
+
+func versions(migrations []Migration) []int {
+	applied := make([]int, len(migrations))
+	for i, migration := range migrations {
+		applied[i] = migration.Version
+	}
+	return applied
+}
+
+func equalVersions(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMigrateFreshSchema(t *testing.T) {
+	schema := newSchemaEmulator()
+	fake := newFakeQuestDB(t, schema.exec)
+	defer fake.Close()
+	migrator := NewMigrator(fake.client(t), Options{PartitionBy: "day"})
+
+	applied, err := migrator.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{1, 2}; !equalVersions(versions(applied), expected) || !equalVersions(schema.recordedVersions(), expected) {
+		t.Errorf("applied %v and recorded %v, expected %v", versions(applied), schema.recordedVersions(), expected)
+	}
+	if creates := fake.matching("CREATE TABLE traces"); len(creates) != 1 || !strings.HasSuffix(creates[0], "PARTITION BY DAY") {
+		t.Errorf("traces created with %q", creates)
+	}
+	// the lease is taken before the migrations and forfeited after them
+	leases := fake.matching("INSERT INTO sampling_leases")
+	if len(leases) != 2 || !strings.Contains(leases[0], "'"+migrationsResource+"'") || !strings.Contains(leases[1], "dateadd('s', 0,") {
+		t.Errorf("leases are %q, expected an acquisition and a forfeit", leases)
+	}
+
+	applied, err = migrator.Migrate()
+	if err != nil || len(applied) != 0 {
+		t.Errorf("migrating an up to date schema applied %v, %v", versions(applied), err)
+	}
+	if err := migrator.Check(); err != nil {
+		t.Errorf("check of an up to date schema returned %v", err)
+	}
+}
+
+func TestMigrateTableOfPreviousRelease(t *testing.T) {
+	schema := newSchemaEmulator().withTraces(legacyColumns...)
+	fake := newFakeQuestDB(t, schema.exec)
+	defer fake.Close()
+	migrator := NewMigrator(fake.client(t), Options{})
+
+	if _, err := migrator.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if creates := fake.matching("CREATE TABLE traces"); len(creates) != 0 {
+		t.Errorf("existing traces table created again with %q", creates)
+	}
+	expected := []string{
+		"ALTER TABLE traces ADD COLUMN span_kind SYMBOL",
+		"ALTER TABLE traces ADD COLUMN tenant SYMBOL",
+	}
+	if added := fake.matching("ADD COLUMN"); strings.Join(added, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("columns added with %q, expected %q", added, expected)
+	}
+}
+
+func TestMigrateStopsAtFailingMigration(t *testing.T) {
+	schema := newSchemaEmulator().withTraces(legacyColumns...)
+	schema.failing = "ALTER TABLE traces"
+	fake := newFakeQuestDB(t, schema.exec)
+	defer fake.Close()
+	migrator := NewMigrator(fake.client(t), Options{})
+
+	applied, err := migrator.Migrate()
+	if err == nil || !strings.Contains(err.Error(), "migration 2") {
+		t.Errorf("failing migration returned %v", err)
+	}
+	if !equalVersions(versions(applied), []int{1}) || !equalVersions(schema.recordedVersions(), []int{1}) {
+		t.Errorf("applied %v and recorded %v, expected the first migration", versions(applied), schema.recordedVersions())
+	}
+	// the lease is forfeited even when a migration fails
+	if leases := fake.matching("INSERT INTO sampling_leases"); len(leases) != 2 {
+		t.Errorf("leases are %q, expected an acquisition and a forfeit", leases)
+	}
+	if version, err := migrator.Version(); err != nil || version != 1 {
+		t.Errorf("version is %d, %v, expected 1", version, err)
+	}
+}
+
+func TestMigrateLockedByAnotherProcess(t *testing.T) {
+	schema := newSchemaEmulator()
+	fake := newFakeQuestDB(t, schema.exec)
+	defer fake.Close()
+	other := NewLock(fake.client(t), "other-collector")
+	if acquired, err := other.Acquire(migrationsResource, migrationsLease); err != nil || !acquired {
+		t.Fatalf("lease of the other process: %v, %v", acquired, err)
+	}
+	migrator := NewMigrator(fake.client(t), Options{})
+	migrator.lockWait = 50 * time.Millisecond
+	migrator.lockRetry = 10 * time.Millisecond
+
+	if applied, err := migrator.Migrate(); err != ErrMigrationsLocked || len(applied) != 0 {
+		t.Errorf("migrations applied under the lease of another process: %v, %v", versions(applied), err)
+	}
+	if creates := fake.matching("CREATE TABLE"); len(creates) != 0 {
+		t.Errorf("schema changed under the lease of another process with %q", creates)
+	}
+}
+
+func TestMigrateWaitsForAnotherProcess(t *testing.T) {
+	schema := newSchemaEmulator()
+	fake := newFakeQuestDB(t, schema.exec)
+	defer fake.Close()
+	other := NewLock(fake.client(t), "other-collector")
+	if acquired, err := other.Acquire(migrationsResource, migrationsLease); err != nil || !acquired {
+		t.Fatalf("lease of the other process: %v, %v", acquired, err)
+	}
+	migrator := NewMigrator(fake.client(t), Options{})
+	migrator.lockRetry = 10 * time.Millisecond
+
+	type result struct {
+		applied []Migration
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		applied, err := migrator.Migrate()
+		done <- result{applied, err}
+	}()
+
+	// the other process applies the first migration and releases its lease
+	time.Sleep(50 * time.Millisecond)
+	first := migrations[0]
+	if err := first.Up(migrator.traces); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.record(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Forfeit(migrationsResource); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case migrated := <-done:
+		if migrated.err != nil {
+			t.Fatal(migrated.err)
+		}
+		// only the migrations still pending once the lease is released are applied
+		if !equalVersions(versions(migrated.applied), []int{2}) {
+			t.Errorf("applied versions are %v, expected 2", versions(migrated.applied))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("migrations still waiting for the released lease")
+	}
+	if !equalVersions(schema.recordedVersions(), []int{1, 2}) {
+		t.Errorf("recorded versions are %v, expected each once", schema.recordedVersions())
+	}
+}
+
+func TestFactoryMigrationModes(t *testing.T) {
+	tests := []struct {
+		mode string
+		// upToDate migrates the schema before the factory handles it
+		upToDate bool
+		outdated bool
+		migrated bool
+	}{
+		{mode: MigrationsApply, migrated: true},
+		{mode: MigrationsCheck, outdated: true},
+		{mode: MigrationsCheck, upToDate: true, migrated: true},
+		{mode: MigrationsDisabled},
+	}
+	for _, test := range tests {
+		name := test.mode
+		if test.upToDate {
+			name += " up to date"
+		}
+		t.Run(name, func(t *testing.T) {
+			schema := newSchemaEmulator()
+			fake := newFakeQuestDB(t, schema.exec)
+			defer fake.Close()
+			options := Options{Migrations: test.mode}
+			if test.upToDate {
+				if _, err := NewMigrator(fake.client(t), options).Migrate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			queried := len(fake.recorded())
+			factory := &Factory{options: options, questDB: fake.client(t)}
+
+			err := factory.migrate(zap.NewNop())
+			if test.outdated != errors.Is(err, ErrSchemaOutdated) || (!test.outdated && err != nil) {
+				t.Errorf("migrate returned %v", err)
+			}
+			if migrated := equalVersions(schema.recordedVersions(), []int{1, 2}); migrated != test.migrated {
+				t.Errorf("recorded versions are %v", schema.recordedVersions())
+			}
+			queries := fake.recorded()[queried:]
+			for _, query := range queries {
+				if test.mode != MigrationsApply && (strings.HasPrefix(query, "CREATE TABLE traces") || strings.HasPrefix(query, "ALTER TABLE")) {
+					t.Errorf("%s mode changed the schema with %q", test.mode, query)
+				}
+			}
+			if test.mode == MigrationsDisabled && len(queries) != 0 {
+				t.Errorf("disabled mode sent %q", queries)
+			}
+		})
+	}
+}
@@ -2,18 +2,77 @@ package questbd
 
 import (
 	"flag"
+	"fmt"
+	"github.com/jaegertracing/jaeger/pkg/config/tlscfg"
+	"github.com/jaegertracing/jaeger/pkg/multierror"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
 	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 const (
 	configPrefix = "questdb"
 	suffixHost   = ".host"
 
-	defaultHost = "http://127.0.0.1:9000"
+	suffixPartitionBy       = ".partition-by"
+	suffixRetention         = ".retention"
+	suffixRetentionInterval = ".retention-interval"
+	suffixRetentionDryRun   = ".retention-dry-run"
+	suffixBulkThreshold     = ".bulk-threshold"
+	suffixBulkLoad          = ".bulk-load"
+	suffixUsername          = ".username"
+	suffixPasswordFile      = ".password-file"
+	suffixTokenFile         = ".token-file"
+	suffixTimeout           = ".timeout"
+	suffixDialTimeout       = ".dial-timeout"
+	suffixIdleConnTimeout   = ".idle-conn-timeout"
+	suffixMaxIdleConns      = ".max-idle-conns"
+	suffixMigrations        = ".migrations"
+
+	// passwordEnv and tokenEnv are read when the password and token files are not set
+	passwordEnv = "QUESTDB_PASSWORD"
+	tokenEnv    = "QUESTDB_TOKEN"
+
+	defaultHost              = "http://127.0.0.1:9000"
+	defaultPartitionBy       = "DAY"
+	defaultRetention         = 0
+	defaultRetentionInterval = time.Hour
+	defaultBulkThreshold     = 10000
+	defaultTimeout           = 60 * time.Second
+	defaultDialTimeout       = 30 * time.Second
+	defaultIdleConnTimeout   = 90 * time.Second
+	defaultMaxIdleConns      = 10
 )
 
 type Options struct {
-	Host string
+	Host              string
+	PartitionBy       string
+	Retention         time.Duration
+	RetentionInterval time.Duration
+	RetentionDryRun   bool
+	// BulkThreshold is the number of spans from which a flush goes through the CSV import, 0 disables it
+	BulkThreshold int
+	// BulkLoad buffers BulkThreshold spans before flushing them, so every flush is a CSV import
+	BulkLoad bool
+	// Username authenticates with basic auth, the password is read from PasswordFile or the
+	// QUESTDB_PASSWORD environment variable. A bearer token, from TokenFile or QUESTDB_TOKEN, is
+	// sent instead when set.
+	Username     string
+	PasswordFile string
+	TokenFile    string
+	// TLS is used when the host is an https URL
+	TLS             tlscfg.Options
+	Timeout         time.Duration
+	DialTimeout     time.Duration
+	IdleConnTimeout time.Duration
+	MaxIdleConns    int
+	// Migrations is how the schema migrations are handled at startup: apply, check or disabled
+	Migrations string
+	spans.Options
 }
 
 // AddFlags adds flags for Options
@@ -22,9 +81,173 @@ func (opt *Options) AddFlags(flagSet *flag.FlagSet) {
 		configPrefix+suffixHost,
 		defaultHost,
 		"Quest database host:port , REST endpoint")
+	flagSet.String(
+		configPrefix+suffixPartitionBy,
+		defaultPartitionBy,
+		"Partition unit of the traces table (HOUR, DAY, MONTH, YEAR), only used when the table is created")
+	flagSet.Duration(
+		configPrefix+suffixRetention,
+		defaultRetention,
+		"How long traces are kept, older partitions are dropped. Zero disables the retention job")
+	flagSet.Duration(
+		configPrefix+suffixRetentionInterval,
+		defaultRetentionInterval,
+		"How often the retention job looks for partitions to drop")
+	flagSet.Bool(
+		configPrefix+suffixRetentionDryRun,
+		false,
+		"Only log and report the spans the retention job would drop, without dropping them")
+	flagSet.Int(
+		configPrefix+suffixBulkThreshold,
+		defaultBulkThreshold,
+		"Flushes of at least this many spans are written with a CSV import instead of INSERTs, 0 disables it")
+	flagSet.Bool(
+		configPrefix+suffixBulkLoad,
+		false,
+		"Buffer bulk-threshold spans before flushing them with a CSV import, for backfills and migrations")
+	flagSet.String(
+		configPrefix+suffixUsername,
+		"",
+		"The user authenticated with basic auth, its password is read from the password file or the "+passwordEnv+" environment variable")
+	flagSet.String(
+		configPrefix+suffixPasswordFile,
+		"",
+		"Path to a file holding the basic auth password")
+	flagSet.String(
+		configPrefix+suffixTokenFile,
+		"",
+		"Path to a file holding the bearer token sent instead of basic auth, also read from the "+tokenEnv+" environment variable")
+	flagSet.Duration(
+		configPrefix+suffixTimeout,
+		defaultTimeout,
+		"Timeout of the requests to QuestDB, including reading the response")
+	flagSet.Duration(
+		configPrefix+suffixDialTimeout,
+		defaultDialTimeout,
+		"Timeout of the connections to QuestDB")
+	flagSet.Duration(
+		configPrefix+suffixIdleConnTimeout,
+		defaultIdleConnTimeout,
+		"How long an idle connection to QuestDB is kept open")
+	flagSet.Int(
+		configPrefix+suffixMaxIdleConns,
+		defaultMaxIdleConns,
+		"Maximum number of idle connections to QuestDB kept open")
+	flagSet.String(
+		configPrefix+suffixMigrations,
+		MigrationsApply,
+		"Schema migrations at startup: apply the pending ones, check fails when some are pending, disabled leaves the schema to the questdb-schema command")
+	tlsFlagsConfig().AddFlags(flagSet)
+	opt.Options.AddFlags(configPrefix, flagSet)
+}
+
+func tlsFlagsConfig() tlscfg.ClientFlagsConfig {
+	return tlscfg.ClientFlagsConfig{
+		Prefix:         configPrefix,
+		ShowServerName: true,
+	}
 }
 
 func (opt *Options) InitFromViper(v *viper.Viper) {
 	opt.Host = v.GetString(configPrefix + suffixHost)
+	opt.PartitionBy = v.GetString(configPrefix + suffixPartitionBy)
+	opt.Retention = v.GetDuration(configPrefix + suffixRetention)
+	opt.RetentionInterval = v.GetDuration(configPrefix + suffixRetentionInterval)
+	opt.RetentionDryRun = v.GetBool(configPrefix + suffixRetentionDryRun)
+	opt.BulkThreshold = v.GetInt(configPrefix + suffixBulkThreshold)
+	opt.BulkLoad = v.GetBool(configPrefix + suffixBulkLoad)
+	opt.Username = v.GetString(configPrefix + suffixUsername)
+	opt.PasswordFile = v.GetString(configPrefix + suffixPasswordFile)
+	opt.TokenFile = v.GetString(configPrefix + suffixTokenFile)
+	opt.Timeout = v.GetDuration(configPrefix + suffixTimeout)
+	opt.DialTimeout = v.GetDuration(configPrefix + suffixDialTimeout)
+	opt.IdleConnTimeout = v.GetDuration(configPrefix + suffixIdleConnTimeout)
+	opt.MaxIdleConns = v.GetInt(configPrefix + suffixMaxIdleConns)
+	opt.TLS = tlsFlagsConfig().InitFromViper(v)
+	opt.Migrations = v.GetString(configPrefix + suffixMigrations)
+	opt.Options.InitFromViper(configPrefix, v)
+
+}
+
+// Validate returns an error reporting every invalid option, named after its flag.
+func (opt *Options) Validate() error {
+	var errs []error
+	if host, err := url.Parse(opt.Host); err != nil || (host.Scheme != "http" && host.Scheme != "https") || host.Host == "" {
+		errs = append(errs, fmt.Errorf("%s: invalid URL %q, expected http(s)://host:port", configPrefix+suffixHost, opt.Host))
+	}
+	if _, ok := partitionUnits[strings.ToUpper(opt.PartitionBy)]; !ok {
+		errs = append(errs, fmt.Errorf("%s: unknown partition unit %s", configPrefix+suffixPartitionBy, opt.PartitionBy))
+	}
+	if opt.Retention < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative retention %v", configPrefix+suffixRetention, opt.Retention))
+	}
+	if opt.Retention > 0 && opt.RetentionInterval <= 0 {
+		errs = append(errs, fmt.Errorf("%s: the interval must be positive", configPrefix+suffixRetentionInterval))
+	}
+	if opt.BulkThreshold < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative threshold %d", configPrefix+suffixBulkThreshold, opt.BulkThreshold))
+	}
+	if opt.BulkLoad && opt.BulkThreshold <= 0 {
+		errs = append(errs, fmt.Errorf("%s: bulk load requires a positive %s", configPrefix+suffixBulkLoad, configPrefix+suffixBulkThreshold))
+	}
+	if opt.Timeout < 0 || opt.DialTimeout < 0 || opt.IdleConnTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s, %s and %s must not be negative",
+			configPrefix+suffixTimeout, configPrefix+suffixDialTimeout, configPrefix+suffixIdleConnTimeout))
+	}
+	if opt.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("%s: negative number of connections %d", configPrefix+suffixMaxIdleConns, opt.MaxIdleConns))
+	}
+	if opt.PasswordFile != "" && opt.Username == "" {
+		errs = append(errs, fmt.Errorf("%s: a password requires %s", configPrefix+suffixPasswordFile, configPrefix+suffixUsername))
+	}
+	if opt.TokenFile != "" && opt.Username != "" {
+		errs = append(errs, fmt.Errorf("%s: basic auth and a bearer token can't be both used", configPrefix+suffixTokenFile))
+	}
+	if (opt.TLS.CertPath == "") != (opt.TLS.KeyPath == "") {
+		errs = append(errs, fmt.Errorf("%s: a client certificate and its key must be both set", configPrefix+".tls"))
+	}
+	if host, err := url.Parse(opt.Host); err == nil && host.Scheme != "https" &&
+		(opt.TLS.CAPath != "" || opt.TLS.CertPath != "" || opt.TLS.SkipHostVerify) {
+		errs = append(errs, fmt.Errorf("%s: TLS settings require an https host", configPrefix+".tls"))
+	}
+	switch opt.Migrations {
+	case MigrationsApply, MigrationsCheck, MigrationsDisabled:
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown mode %q, expected %s, %s or %s", configPrefix+suffixMigrations,
+			opt.Migrations, MigrationsApply, MigrationsCheck, MigrationsDisabled))
+	}
+	errs = append(errs, opt.Options.Validate(configPrefix)...)
+	return multierror.Wrap(errs)
+}
+
+// credentials reads the secrets of the client from their files or environment variables
+func (opt *Options) credentials() (credentials, error) {
+	token, err := secret(opt.TokenFile, tokenEnv)
+	if err != nil {
+		return credentials{}, err
+	}
+	if token != "" {
+		return credentials{token: token}, nil
+	}
+	if opt.Username == "" {
+		return credentials{}, nil
+	}
+	password, err := secret(opt.PasswordFile, passwordEnv)
+	if err != nil {
+		return credentials{}, err
+	}
+	return credentials{username: opt.Username, password: password}, nil
+}
 
+// secret returns the content of file without its trailing newline, or the environment variable when
+// there is no file.
+func secret(file, env string) (string, error) {
+	if file == "" {
+		return os.Getenv(env), nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
 }
@@ -0,0 +1,81 @@
+package questbd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(options *Options)
+		// invalid is the flag reported, empty when the options are valid
+		invalid string
+	}{
+		{name: "defaults", modify: func(*Options) {}},
+		{name: "https host", modify: func(o *Options) { o.Host = "https://questdb:9000" }},
+		{name: "host without scheme", modify: func(o *Options) { o.Host = "questdb:9000" }, invalid: "questdb.host"},
+		{name: "partition unit in lower case", modify: func(o *Options) { o.PartitionBy = "hour" }},
+		{name: "unknown partition unit", modify: func(o *Options) { o.PartitionBy = "WEEK" }, invalid: "questdb.partition-by"},
+		{name: "negative retention", modify: func(o *Options) { o.Retention = -time.Hour }, invalid: "questdb.retention"},
+		{name: "retention without interval", modify: func(o *Options) {
+			o.Retention = time.Hour
+			o.RetentionInterval = 0
+		}, invalid: "questdb.retention-interval"},
+		{name: "negative bulk threshold", modify: func(o *Options) { o.BulkThreshold = -1 }, invalid: "questdb.bulk-threshold"},
+		{name: "bulk load without threshold", modify: func(o *Options) {
+			o.BulkLoad = true
+			o.BulkThreshold = 0
+		}, invalid: "questdb.bulk-load"},
+		{name: "negative timeout", modify: func(o *Options) { o.DialTimeout = -time.Second }, invalid: "questdb.dial-timeout"},
+		{name: "negative idle connections", modify: func(o *Options) { o.MaxIdleConns = -1 }, invalid: "questdb.max-idle-conns"},
+		{name: "password without username", modify: func(o *Options) { o.PasswordFile = "/secrets/password" }, invalid: "questdb.password-file"},
+		{name: "basic auth and token", modify: func(o *Options) {
+			o.Username = "admin"
+			o.TokenFile = "/secrets/token"
+		}, invalid: "questdb.token-file"},
+		{name: "certificate without key", modify: func(o *Options) {
+			o.Host = "https://questdb:9000"
+			o.TLS.CertPath = "/certs/client.pem"
+		}, invalid: "questdb.tls"},
+		{name: "TLS over http", modify: func(o *Options) { o.TLS.CAPath = "/certs/ca.pem" }, invalid: "questdb.tls"},
+		{name: "unknown migrations mode", modify: func(o *Options) { o.Migrations = "auto" }, invalid: "questdb.migrations"},
+		{name: "unknown span compression", modify: func(o *Options) { o.SpanCompression = "gzip" }, invalid: "questdb.span-compression"},
+		{name: "default tenant without tenancy", modify: func(o *Options) { o.DefaultTenant = "acme" }, invalid: "questdb.tenancy.default-tenant"},
+		{name: "tenancy without write tenant", modify: func(o *Options) { o.Tenancy = true }, invalid: "questdb.tenancy.enabled"},
+		{name: "tenancy with default tenant", modify: func(o *Options) {
+			o.Tenancy = true
+			o.DefaultTenant = "acme"
+		}},
+		{name: "tenancy with trusted process tag", modify: func(o *Options) {
+			o.Tenancy = true
+			o.TrustProcessTag = true
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := NewFactory().options
+			test.modify(&options)
+			err := options.Validate()
+			switch {
+			case test.invalid == "" && err != nil:
+				t.Errorf("valid options rejected: %v", err)
+			case test.invalid != "" && err == nil:
+				t.Errorf("invalid %s accepted", test.invalid)
+			case test.invalid != "" && !strings.Contains(err.Error(), test.invalid):
+				t.Errorf("error %q doesn't name %s", err, test.invalid)
+			}
+		})
+	}
+}
+
+func TestOptionsValidateReportsEveryError(t *testing.T) {
+	options := NewFactory().options
+	options.PartitionBy = "WEEK"
+	options.Migrations = "auto"
+	err := options.Validate()
+	if err == nil || !strings.Contains(err.Error(), "questdb.partition-by") || !strings.Contains(err.Error(), "questdb.migrations") {
+		t.Errorf("error %v doesn't report both invalid options", err)
+	}
+}
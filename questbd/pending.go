@@ -0,0 +1,166 @@
+package questbd
+
+import (
+	"sync"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+type pendingSpan struct {
+	span   *model.Span
+	tenant string
+}
+
+// pendingIndex indexes the spans written but not yet flushed to QuestDB, so readers see them right
+// after they are written. Each flush rotates a generation, which is released once it is stored.
+type pendingIndex struct {
+	sync.RWMutex
+	generation  uint64
+	generations map[uint64]map[model.TraceID][]pendingSpan
+}
+
+func newPendingIndex() *pendingIndex {
+	return &pendingIndex{
+		generations: map[uint64]map[model.TraceID][]pendingSpan{0: {}},
+	}
+}
+
+func (p *pendingIndex) add(span *model.Span, tenant string) {
+	p.Lock()
+	defer p.Unlock()
+	current := p.generations[p.generation]
+	current[span.TraceID] = append(current[span.TraceID], pendingSpan{span: span, tenant: tenant})
+}
+
+// rotate starts a new generation and returns the one being flushed.
+func (p *pendingIndex) rotate() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	flushed := p.generation
+	p.generation++
+	p.generations[p.generation] = map[model.TraceID][]pendingSpan{}
+	return flushed
+}
+
+// release drops a flushed generation, its spans are served by QuestDB from now on.
+func (p *pendingIndex) release(generation uint64) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.generations, generation)
+}
+
+// spans returns the pending spans of a trace visible to the tenant, empty when tenancy is disabled.
+func (p *pendingIndex) spans(traceID model.TraceID, tenant string) []*model.Span {
+	p.RLock()
+	defer p.RUnlock()
+	var spans []*model.Span
+	for _, traces := range p.generations {
+		for _, pending := range traces[traceID] {
+			if tenant == "" || pending.tenant == tenant {
+				spans = append(spans, pending.span)
+			}
+		}
+	}
+	return spans
+}
+
+// find returns the IDs of the traces whose pending spans match the query. With trace-level matching,
+// like the stored traces, each search criteria may be satisfied by a different span of the trace.
+func (p *pendingIndex) find(query *spanstore.TraceQueryParameters, tenant string, traceLevel bool) []model.TraceID {
+	p.RLock()
+	defer p.RUnlock()
+	criteria := queryCriteria(query)
+	candidates := make(map[model.TraceID][]*model.Span)
+	var order []model.TraceID
+	for _, traces := range p.generations {
+		for traceID, spans := range traces {
+			for _, pending := range spans {
+				if (tenant != "" && pending.tenant != tenant) || !inTimeRange(pending.span, query) {
+					continue
+				}
+				if _, ok := candidates[traceID]; !ok {
+					order = append(order, traceID)
+				}
+				candidates[traceID] = append(candidates[traceID], pending.span)
+			}
+		}
+	}
+	var traceIDs []model.TraceID
+	for _, traceID := range order {
+		if matchesCriteria(candidates[traceID], criteria, traceLevel) {
+			traceIDs = append(traceIDs, traceID)
+		}
+	}
+	return traceIDs
+}
+
+// matchesCriteria tells whether a span satisfies every criteria or, with trace-level matching, whether
+// every criteria is satisfied by any of the spans.
+func matchesCriteria(spans []*model.Span, criteria []func(*model.Span) bool, traceLevel bool) bool {
+	if !traceLevel {
+		for _, span := range spans {
+			if matchesAll(span, criteria) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, criterion := range criteria {
+		matched := false
+		for _, span := range spans {
+			if criterion(span) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAll(span *model.Span, criteria []func(*model.Span) bool) bool {
+	for _, criterion := range criteria {
+		if !criterion(span) {
+			return false
+		}
+	}
+	return true
+}
+
+func inTimeRange(span *model.Span, query *spanstore.TraceQueryParameters) bool {
+	if !query.StartTimeMin.IsZero() && span.StartTime.Before(query.StartTimeMin) {
+		return false
+	}
+	return query.StartTimeMax.IsZero() || !span.StartTime.After(query.StartTimeMax)
+}
+
+// queryCriteria returns one predicate per search criteria, split as in buildPredicates: the duration
+// bounds, the service and operation together, and each tag.
+func queryCriteria(query *spanstore.TraceQueryParameters) []func(*model.Span) bool {
+	var criteria []func(*model.Span) bool
+	if query.DurationMin != 0 || query.DurationMax != 0 {
+		criteria = append(criteria, func(span *model.Span) bool {
+			return (query.DurationMin == 0 || span.Duration >= query.DurationMin) &&
+				(query.DurationMax == 0 || span.Duration <= query.DurationMax)
+		})
+	}
+	if query.ServiceName != "" || query.OperationName != "" {
+		criteria = append(criteria, func(span *model.Span) bool {
+			if query.ServiceName != "" && (span.Process == nil || span.Process.ServiceName != query.ServiceName) {
+				return false
+			}
+			return query.OperationName == "" || span.OperationName == query.OperationName
+		})
+	}
+	for key, value := range query.Tags {
+		key, value := key, value
+		criteria = append(criteria, func(span *model.Span) bool {
+			tag, ok := model.KeyValues(span.Tags).FindByKey(key)
+			return ok && tag.AsString() == value
+		})
+	}
+	return criteria
+}
@@ -2,27 +2,111 @@ package questbd
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/traceid"
 	"strings"
 )
 
 var (
 	// ErrTraceNotFound is returned by Reader's GetTrace if no data is found for given trace ID.
-	ErrTraceNotFound = errors.New("trace not found")
+	ErrTraceNotFound = spans.ErrTraceNotFound
 )
 
 const getServicesQuery = "SELECT DISTINCT service_name from traces"
-const getOperationsQuery = "SELECT DISTINCT operation_name from traces"
+const getOperationsQuery = "SELECT DISTINCT operation_name, span_kind from traces"
 
-func (w *Writer) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+// spansPageSize is the number of span blobs fetched from QuestDB at once
+const spansPageSize = 1000
+
+// tenant returns the tenant of ctx, empty when tenancy is disabled.
+func (w *Writer) tenant(ctx context.Context) (string, error) {
+	return w.tenants.Read(ctx)
+}
+
+// tenantCondition returns the condition that restricts a query to the tenant of ctx, empty when tenancy is disabled.
+func (w *Writer) tenantCondition(ctx context.Context) (string, error) {
+	tenant, err := w.tenant(ctx)
+	return tenantFilter(tenant), err
+}
+
+func tenantFilter(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+	return " tenant = " + escape(tenant)
+}
 
-	return nil, nil
+// where appends the non empty conditions to query
+func where(query string, conditions ...string) string {
+	var nonEmpty []string
+	for _, condition := range conditions {
+		if condition != "" {
+			nonEmpty = append(nonEmpty, condition)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return query
+	}
+	return query + " WHERE " + strings.Join(nonEmpty, " AND ")
+}
+
+// storedTraces adds the spans stored in QuestDB matching the condition to the assembler. The spans
+// are fetched by pages, the condition must match the same spans each time it is run.
+func (w *Writer) storedTraces(assembler *spans.TraceAssembler, condition, tenantCondition string) error {
+	// pages are slices of the results, they need a stable order
+	query := where("SELECT span FROM traces", condition, tenantCondition) + " ORDER BY trace_id, span_id, start_time"
+	rows := w.questDB.Stream(query, spansPageSize)
+	defer rows.Close()
+	for rows.Next() {
+		span, err := spans.Decode(rows.String(0))
+		if err != nil {
+			return err
+		}
+		assembler.Add(span)
+	}
+	return rows.Err()
+}
+
+// traceIDCondition matches the spans of the traces, whatever the form their IDs were stored in.
+func traceIDCondition(traceIDs ...model.TraceID) string {
+	var forms []string
+	for _, traceID := range traceIDs {
+		for _, form := range traceid.Forms(traceID) {
+			forms = append(forms, escape(form))
+		}
+	}
+	return " trace_id IN (" + strings.Join(forms, ",") + ")"
+}
+
+func (w *Writer) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	tenant, err := w.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assembler := spans.NewTraceAssembler()
+	if err := w.storedTraces(assembler, traceIDCondition(traceID), tenantFilter(tenant)); err != nil {
+		return nil, err
+	}
+	// a span may be both pending and stored while its generation is being flushed
+	for _, span := range w.pending.spans(traceID, tenant) {
+		assembler.Add(span)
+	}
+	trace := assembler.Trace(traceID)
+	if trace == nil {
+		return nil, ErrTraceNotFound
+	}
+	return trace, nil
 }
 
 func (w *Writer) GetServices(ctx context.Context) ([]string, error) {
-	rows, err := w.questDB.Query(getServicesQuery)
+	tenantCondition, err := w.tenantCondition(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := w.questDB.Query(where(getServicesQuery, tenantCondition))
 	if err != nil {
 		return nil, err
 	}
@@ -39,8 +123,23 @@ func (w *Writer) GetServices(ctx context.Context) ([]string, error) {
 
 }
 
+func buildOperationsQuery(query spanstore.OperationQueryParameters, tenantCondition string) string {
+	conditions := []string{tenantCondition}
+	if query.ServiceName != "" {
+		conditions = append(conditions, " service_name = "+escape(query.ServiceName))
+	}
+	if query.SpanKind != "" {
+		conditions = append(conditions, " span_kind = "+escape(query.SpanKind))
+	}
+	return where(getOperationsQuery, conditions...)
+}
+
 func (w *Writer) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
-	rows, err := w.questDB.Query(getOperationsQuery)
+	tenantCondition, err := w.tenantCondition(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := w.questDB.Query(buildOperationsQuery(query, tenantCondition))
 	if err != nil {
 		return nil, err
 	}
@@ -49,74 +148,131 @@ func (w *Writer) GetOperations(ctx context.Context, query spanstore.OperationQue
 	for rows.Next() {
 		row := rows.Get()
 		operations[count].Name = (row[0]).(string)
+		if spanKind, ok := row[1].(string); ok {
+			operations[count].SpanKind = spanKind
+		}
 		count++
 	}
 	return operations, nil
 }
 
-func (w *Writer) buildQueryCondition(query *spanstore.TraceQueryParameters) (string, bool) {
+// buildPredicates returns the time range condition and one condition per search criteria, service and
+// operation are kept together as operations are listed per service. It returns false when a searched
+// tag has never been written, so no trace can match.
+func (w *Writer) buildPredicates(query *spanstore.TraceQueryParameters, tenantCondition string) (string, []string, bool, error) {
 	var conditions []string
 	if query.DurationMax != 0 || query.DurationMin != 0 {
-		max := query.DurationMax.Microseconds()
-		min := query.DurationMin.Microseconds()
-		conditions = append(conditions, " duration <= "+string(max)+" AND duration >=  "+string(min))
+		var bounds []string
+		if query.DurationMin != 0 {
+			bounds = append(bounds, "duration >= "+escape(query.DurationMin.Microseconds()))
+		}
+		if query.DurationMax != 0 {
+			bounds = append(bounds, "duration <= "+escape(query.DurationMax.Microseconds()))
+		}
+		conditions = append(conditions, " "+strings.Join(bounds, " AND "))
 	}
 	startTimeMax := query.StartTimeMax.UTC().Format("2006-01-02T15:04:05.999Z")
 	startTimeMin := query.StartTimeMin.UTC().Format("2006-01-02T15:04:05.999Z")
 
-	conditions = append(conditions, " start_time <= "+escape(startTimeMax)+" AND start_time >= "+escape(startTimeMin))
+	timeCondition := " start_time <= " + escape(startTimeMax) + " AND start_time >= " + escape(startTimeMin)
+	if tenantCondition != "" {
+		timeCondition += " AND" + tenantCondition
+	}
 
+	var serviceConditions []string
 	if query.OperationName != "" {
-		conditions = append(conditions, " operation_name = "+escape(query.OperationName))
+		serviceConditions = append(serviceConditions, " operation_name = "+escape(query.OperationName))
 	}
 
 	if query.ServiceName != "" {
-		conditions = append(conditions, " service_name = "+escape(query.ServiceName))
+		serviceConditions = append(serviceConditions, " service_name = "+escape(query.ServiceName))
+	}
+	if len(serviceConditions) > 0 {
+		conditions = append(conditions, strings.Join(serviceConditions, " AND "))
 	}
 
 	if len(query.Tags) > 0 {
 		var tags []string
 		tagMap := make(map[string]string, len(query.Tags))
 		for key, value := range query.Tags {
-			tags = append(tags, escape(sanitizeTagKey(tagPrefix+key)))
-			tagMap[key] = escape(value)
+			column := tagColumn(key)
+			tags = append(tags, escape(column))
+			tagMap[column] = escape(value)
 		}
 
 		tagsQuery := "SELECT column FROM table_columns('traces') where column IN ( " + strings.Join(tags, ",") + " )"
 
 		tagRows, err := w.questDB.Query(tagsQuery)
-
 		if err != nil {
-			println(err.Error())
+			return "", nil, false, err
 		}
-		if tagRows.Count() == 0 {
-			// No results, so we return false indicating premature results will be empty
-			return strings.Join(conditions, " AND "), false
+		if tagRows.Count() < len(tagMap) {
+			// Some tag was never written, so we return false indicating premature results will be empty
+			return timeCondition, conditions, false, nil
 		}
 		for tagRows.Next() {
 			row := (tagRows.Get()[0]).(string)
-			conditions = append(conditions, row+"="+tagMap[row])
+			conditions = append(conditions, " "+row+" = "+tagMap[row])
 		}
 	}
-	return strings.Join(conditions, " AND "), true
+	return timeCondition, conditions, true, nil
 }
 
-func (w *Writer) findTraceIdsQuery(query *spanstore.TraceQueryParameters) string {
-	condition, hasResults := w.buildQueryCondition(query)
-	if !hasResults {
-		return ""
+func (w *Writer) buildQueryCondition(query *spanstore.TraceQueryParameters, tenantCondition string) (string, bool, error) {
+	timeCondition, conditions, hasResults, err := w.buildPredicates(query, tenantCondition)
+	return strings.Join(append([]string{timeCondition}, conditions...), " AND "), hasResults, err
+}
+
+// traceLevelQuery groups the spans by trace counting the spans that match each search criteria, a trace
+// matches when every criteria is satisfied by any of its spans.
+func (w *Writer) traceLevelQuery(query *spanstore.TraceQueryParameters, tenantCondition string) (string, error) {
+	timeCondition, conditions, hasResults, err := w.buildPredicates(query, tenantCondition)
+	if err != nil || !hasResults {
+		return "", err
 	}
-	selectQuery := "SELECT DISTINCT trace_id FROM traces timestamp(start_time) WHERE " + condition
-	return selectQuery
+	if len(conditions) == 0 {
+		return "SELECT DISTINCT trace_id FROM traces timestamp(start_time) WHERE " + timeCondition, nil
+	}
+	counts := make([]string, len(conditions))
+	matches := make([]string, len(conditions))
+	for i, condition := range conditions {
+		counts[i] = fmt.Sprintf("sum(CASE WHEN %s THEN 1 ELSE 0 END) match_%d", condition, i)
+		matches[i] = fmt.Sprintf("match_%d > 0", i)
+	}
+	return fmt.Sprintf("SELECT trace_id FROM ( SELECT trace_id, %s FROM traces timestamp(start_time) WHERE %s GROUP BY trace_id ) WHERE %s",
+		strings.Join(counts, ", "), timeCondition, strings.Join(matches, " AND ")), nil
 }
 
-func (w *Writer) findTraceIds(query *spanstore.TraceQueryParameters) ([]string, error) {
-	condition, hasResults := w.buildQueryCondition(query)
-	if !hasResults {
-		return []string{}, nil
+// findTraceIdsQuery returns the query of the IDs of the traces matching the search, limited to the
+// number of traces searched. It is empty when no stored trace can match.
+func (w *Writer) findTraceIdsQuery(query *spanstore.TraceQueryParameters, tenantCondition string) (string, error) {
+	var selectQuery string
+	if w.traceLevel {
+		var err error
+		if selectQuery, err = w.traceLevelQuery(query, tenantCondition); err != nil {
+			return "", err
+		}
+	} else {
+		condition, hasResults, err := w.buildQueryCondition(query, tenantCondition)
+		if err != nil {
+			return "", err
+		}
+		if hasResults {
+			selectQuery = "SELECT DISTINCT trace_id FROM traces timestamp(start_time) WHERE " + condition
+		}
+	}
+	if selectQuery == "" {
+		return "", nil
+	}
+	return selectQuery + " LIMIT " + escape(spans.NumTraces(query)), nil
+}
+
+func (w *Writer) findTraceIds(query *spanstore.TraceQueryParameters, tenantCondition string) ([]string, error) {
+	selectQuery, err := w.findTraceIdsQuery(query, tenantCondition)
+	if err != nil || selectQuery == "" {
+		return []string{}, err
 	}
 
-	selectQuery := "SELECT DISTINCT trace_id FROM traces timestamp(start_time) WHERE " + condition
 	rows, err := w.questDB.Query(selectQuery)
 	if err != nil {
 		return []string{}, err
@@ -133,56 +289,82 @@ func (w *Writer) findTraceIds(query *spanstore.TraceQueryParameters) ([]string,
 }
 
 func (w *Writer) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
-
-	subQuery := w.findTraceIdsQuery(query)
-	if subQuery == "" {
-		return []*model.Trace{}, nil
+	if err := spans.ValidateQuery(query); err != nil {
+		return nil, err
 	}
-
-	selectQuery := "SELECT  trace_id, span FROM traces WHERE trace_id IN (" + subQuery + " )"
-	rows, err := w.questDB.Query(selectQuery)
-
+	tenant, err := w.tenant(ctx)
 	if err != nil {
 		return nil, err
 	}
-	tracesMap := make(map[string]*model.Trace, 50)
+	tenantCondition := tenantFilter(tenant)
 
-	for rows.Next() {
-		traceRow := rows.Get()
-		traceId := traceRow[0].(string)
-		spanString := traceRow[1].(string)
-		span, err := unmarshallSpan(spanString)
-		if err != nil {
+	// the IDs are searched once, a subquery would be run again for every page of spans and its
+	// results are not ordered
+	storedIDs, err := w.findTraceIds(query, tenantCondition)
+	if err != nil {
+		return nil, err
+	}
+	traceIDs := make([]model.TraceID, len(storedIDs))
+	for i, storedID := range storedIDs {
+		if traceIDs[i], err = traceid.Parse(storedID); err != nil {
 			return nil, err
 		}
-		trace, ok := tracesMap[traceId]
-		if !ok {
-			trace = &model.Trace{}
+	}
+	assembler := spans.NewTraceAssembler()
+	// no ID is found when a tag column doesn't exist yet, only pending spans can match
+	if len(traceIDs) > 0 {
+		if err := w.storedTraces(assembler, traceIDCondition(traceIDs...), tenantCondition); err != nil {
+			return nil, err
 		}
-		trace.Spans = append(trace.Spans, span)
 	}
-	traces := make([]*model.Trace, len(tracesMap))
 
-	count := 0
-	for _, trace := range tracesMap {
-		traces[count] = trace
-		count++
+	numTraces := spans.NumTraces(query)
+	for _, traceID := range w.pending.find(query, tenant, w.traceLevel) {
+		if !assembler.Contains(traceID) {
+			if assembler.Len() >= numTraces {
+				continue
+			}
+			if err := w.storedTraces(assembler, traceIDCondition(traceID), tenantCondition); err != nil {
+				return nil, err
+			}
+		}
+		for _, span := range w.pending.spans(traceID, tenant) {
+			assembler.Add(span)
+		}
 	}
-	return traces, nil
+	return assembler.Traces(), nil
 }
 
 func (w *Writer) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
-	traceIdsStr, err := w.findTraceIds(query)
+	if err := spans.ValidateQuery(query); err != nil {
+		return []model.TraceID{}, err
+	}
+	tenant, err := w.tenant(ctx)
+	if err != nil {
+		return []model.TraceID{}, err
+	}
+	traceIdsStr, err := w.findTraceIds(query, tenantFilter(tenant))
 	if err != nil {
 		return []model.TraceID{}, err
 	}
 	traceids := make([]model.TraceID, len(traceIdsStr))
+	found := make(map[model.TraceID]bool, len(traceIdsStr))
 	for index, traceId := range traceIdsStr {
-		traceId, err := model.TraceIDFromString(traceId)
+		traceId, err := traceid.Parse(traceId)
 		if err != nil {
 			return []model.TraceID{}, err
 		}
 		traceids[index] = traceId
+		found[traceId] = true
+	}
+	numTraces := spans.NumTraces(query)
+	for _, traceId := range w.pending.find(query, tenant, w.traceLevel) {
+		if len(traceids) >= numTraces {
+			break
+		}
+		if !found[traceId] {
+			traceids = append(traceids, traceId)
+		}
 	}
 	return traceids, nil
 
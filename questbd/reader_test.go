@@ -0,0 +1,364 @@
+package questbd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+func TestGetOperationsSpanKind(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		return fakeResult{
+			columns: []string{"operation_name", "span_kind"},
+			dataset: [][]interface{}{{"get", "server"}},
+		}
+	})
+	defer fake.Close()
+	writer := NewWriter(fake.client(t), Options{}, metrics.NullFactory, zap.NewNop())
+
+	operations, err := writer.GetOperations(context.Background(), spanstore.OperationQueryParameters{
+		ServiceName: "frontend",
+		SpanKind:    "server",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []spanstore.Operation{{Name: "get", SpanKind: "server"}}; !reflect.DeepEqual(operations, expected) {
+		t.Errorf("operations are %v, expected %v", operations, expected)
+	}
+	expected := getOperationsQuery + " WHERE  service_name = 'frontend' AND  span_kind = 'server'"
+	if queries := fake.recorded(); len(queries) != 1 || queries[0] != expected {
+		t.Errorf("queries are %q, expected %q", queries, expected)
+	}
+}
+
+func TestGetOperationsWithoutSpanKind(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		return fakeResult{
+			columns: []string{"operation_name", "span_kind"},
+			dataset: [][]interface{}{{"get", "server"}, {"get", "client"}, {"legacy", nil}},
+		}
+	})
+	defer fake.Close()
+	writer := NewWriter(fake.client(t), Options{}, metrics.NullFactory, zap.NewNop())
+
+	operations, err := writer.GetOperations(context.Background(), spanstore.OperationQueryParameters{ServiceName: "frontend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []spanstore.Operation{{Name: "get", SpanKind: "server"}, {Name: "get", SpanKind: "client"}, {Name: "legacy"}}
+	if !reflect.DeepEqual(operations, expected) {
+		t.Errorf("operations are %v, expected %v", operations, expected)
+	}
+	if query := fake.recorded()[0]; strings.Contains(query, "span_kind =") {
+		t.Errorf("query %q filters on the span kind", query)
+	}
+}
+
+func newTenancyWriter(t *testing.T, fake *fakeQuestDB, options spans.Options) *Writer {
+	options.Tenancy = true
+	return NewWriter(fake.client(t), Options{Options: options}, metrics.NullFactory, zap.NewNop())
+}
+
+func tenantSpan(traceID uint64, tenant string) *model.Span {
+	return &model.Span{
+		TraceID:       model.NewTraceID(0, traceID),
+		SpanID:        model.NewSpanID(traceID),
+		OperationName: "get",
+		StartTime:     time.Now(),
+		Process:       model.NewProcess("frontend", []model.KeyValue{model.String(tenancy.ProcessTag, tenant)}),
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	fake := newFakeQuestDB(t, nil)
+	defer fake.Close()
+	writer := newTenancyWriter(t, fake, spans.Options{})
+	acme := tenancy.WithTenant(context.Background(), "acme")
+	umbrella := tenancy.WithTenant(context.Background(), "umbrella")
+
+	if err := writer.WriteSpanContext(acme, tenantSpan(1, "umbrella")); err != nil {
+		t.Fatal(err)
+	}
+	if trace, err := writer.GetTrace(acme, model.NewTraceID(0, 1)); err != nil || len(trace.Spans) != 1 {
+		t.Errorf("trace of the tenant is %v, %v", trace, err)
+	}
+	if _, err := writer.GetTrace(umbrella, model.NewTraceID(0, 1)); err != ErrTraceNotFound {
+		t.Errorf("trace of another tenant returned %v, expected not found", err)
+	}
+	for _, query := range fake.matching("SELECT span FROM traces") {
+		if !strings.Contains(query, " tenant = 'acme'") && !strings.Contains(query, " tenant = 'umbrella'") {
+			t.Errorf("query %q isn't restricted to the tenant", query)
+		}
+	}
+	if len(fake.matching("tenant = 'umbrella'")) != 1 {
+		t.Errorf("queries of umbrella are %q", fake.matching("tenant = 'umbrella'"))
+	}
+}
+
+func TestTenantRequired(t *testing.T) {
+	fake := newFakeQuestDB(t, nil)
+	defer fake.Close()
+	writer := newTenancyWriter(t, fake, spans.Options{})
+
+	// any client can set the process tag, it isn't trusted by default
+	if err := writer.WriteSpanContext(context.Background(), tenantSpan(1, "acme")); err != tenancy.ErrMissingTenant {
+		t.Errorf("span written without tenant: %v", err)
+	}
+	if _, err := writer.GetServices(context.Background()); err != tenancy.ErrMissingTenant {
+		t.Errorf("services read without tenant: %v", err)
+	}
+	if _, err := writer.GetTrace(context.Background(), model.NewTraceID(0, 1)); err != tenancy.ErrMissingTenant {
+		t.Errorf("trace read without tenant: %v", err)
+	}
+	if len(fake.recorded()) != 0 {
+		t.Errorf("queries %q sent without tenant", fake.recorded())
+	}
+}
+
+func TestTenantFromTrustedProcessTag(t *testing.T) {
+	fake := newFakeQuestDB(t, nil)
+	defer fake.Close()
+	writer := newTenancyWriter(t, fake, spans.Options{TrustProcessTag: true})
+
+	if err := writer.WriteSpanContext(context.Background(), tenantSpan(1, "acme")); err != nil {
+		t.Fatal(err)
+	}
+	records := writer.mainTable.swapBuffer()
+	if len(records) != 1 || records[0].tenant != "acme" {
+		t.Errorf("span written on behalf of %v, expected acme", records)
+	}
+}
+
+func TestDefaultTenant(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		return fakeResult{columns: []string{"service_name"}, dataset: [][]interface{}{{"frontend"}}}
+	})
+	defer fake.Close()
+	writer := newTenancyWriter(t, fake, spans.Options{DefaultTenant: "acme"})
+
+	if _, err := writer.GetServices(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := getServicesQuery + " WHERE  tenant = 'acme'"; fake.recorded()[0] != expected {
+		t.Errorf("query is %q, expected %q", fake.recorded()[0], expected)
+	}
+	if err := writer.WriteSpanContext(context.Background(), tenantSpan(1, "umbrella")); err != nil {
+		t.Fatal(err)
+	}
+	if records := writer.mainTable.swapBuffer(); records[0].tenant != "acme" {
+		t.Errorf("span written on behalf of %s, expected the default tenant", records[0].tenant)
+	}
+}
+
+func TestFindTracesLimit(t *testing.T) {
+	fake := newFakeQuestDB(t, nil)
+	defer fake.Close()
+	writer := NewWriter(fake.client(t), Options{}, metrics.NullFactory, zap.NewNop())
+	for id := uint64(1); id <= 3; id++ {
+		if err := writer.WriteSpan(tenantSpan(id, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: time.Now().Add(-time.Hour),
+		StartTimeMax: time.Now().Add(time.Hour),
+		NumTraces:    2,
+	}
+
+	ids, err := writer.FindTraceIDs(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Errorf("%d trace IDs found, expected 2", len(ids))
+	}
+	traces, err := writer.FindTraces(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 2 {
+		t.Errorf("%d traces found, expected 2", len(traces))
+	}
+	searches := fake.matching("SELECT DISTINCT trace_id")
+	if len(searches) != 2 {
+		t.Fatalf("searches are %q", fake.recorded())
+	}
+	for _, search := range searches {
+		if !strings.Contains(search, " LIMIT 2") {
+			t.Errorf("search %q isn't limited to the number of traces", search)
+		}
+	}
+}
+
+func TestFindTracesSearchesTraceIDsOnce(t *testing.T) {
+	traceID := model.NewTraceID(0, 0xa)
+	// more spans than a page, the spans are fetched with several queries
+	blobs := make([][]interface{}, spansPageSize+10)
+	for i := range blobs {
+		span := tenantSpan(0xa, "")
+		span.SpanID = model.NewSpanID(uint64(i + 1))
+		blob, err := (*spans.Codec)(nil).Encode(span)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs[i] = []interface{}{blob}
+	}
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		switch {
+		case strings.HasPrefix(query, "SELECT DISTINCT trace_id"):
+			return fakeResult{columns: []string{"trace_id"}, dataset: [][]interface{}{{traceID.String()}}}
+		case strings.HasPrefix(query, "SELECT span"):
+			return fakeResult{columns: []string{"span"}, dataset: blobs}
+		}
+		return fakeResult{}
+	})
+	defer fake.Close()
+	writer := NewWriter(fake.client(t), Options{}, metrics.NullFactory, zap.NewNop())
+
+	traces, err := writer.FindTraces(context.Background(), &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: time.Now().Add(-time.Hour),
+		StartTimeMax: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 || len(traces[0].Spans) != len(blobs) {
+		t.Fatalf("traces are %v, expected one with every span", traces)
+	}
+	if searches := fake.matching("SELECT DISTINCT trace_id"); len(searches) != 1 {
+		t.Errorf("trace IDs searched with %q, expected once", searches)
+	}
+	pages := fake.matching("SELECT span FROM traces")
+	if len(pages) != 2 {
+		t.Fatalf("spans fetched with %q, expected 2 pages", pages)
+	}
+	for _, page := range pages {
+		if strings.Contains(page, "SELECT DISTINCT") || !strings.HasSuffix(page, " ORDER BY trace_id, span_id, start_time") {
+			t.Errorf("page query %q isn't ordered over the found trace IDs", page)
+		}
+	}
+}
+
+// splitTagsTrace writes a trace whose two spans each carry one of the searched tags.
+func splitTagsTrace(t *testing.T, writer *Writer) model.TraceID {
+	first, second := tenantSpan(0xb, ""), tenantSpan(0xb, "")
+	second.SpanID = model.NewSpanID(0xc)
+	first.Tags = []model.KeyValue{model.String("http.method", "GET")}
+	second.Tags = []model.KeyValue{model.String("error", "true")}
+	for _, span := range []*model.Span{first, second} {
+		if err := writer.WriteSpan(span); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return first.TraceID
+}
+
+func splitTagsQuery() *spanstore.TraceQueryParameters {
+	return &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		Tags:         map[string]string{"http.method": "GET", "error": "true"},
+		StartTimeMin: time.Now().Add(-time.Hour),
+		StartTimeMax: time.Now().Add(time.Hour),
+	}
+}
+
+func TestPendingTraceLevelMatching(t *testing.T) {
+	for _, traceLevel := range []bool{false, true} {
+		fake := newFakeQuestDB(t, nil)
+		writer := NewWriter(fake.client(t), Options{Options: spans.Options{TraceLevelMatching: traceLevel}}, metrics.NullFactory, zap.NewNop())
+		traceID := splitTagsTrace(t, writer)
+
+		ids, err := writer.FindTraceIDs(context.Background(), splitTagsQuery())
+		fake.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if traceLevel && !reflect.DeepEqual(ids, []model.TraceID{traceID}) {
+			t.Errorf("trace-level matching found %v, expected %v", ids, traceID)
+		}
+		if !traceLevel && len(ids) != 0 {
+			t.Errorf("span-level matching found %v, expected none", ids)
+		}
+	}
+}
+
+func TestStoredTraceLevelMatching(t *testing.T) {
+	columns := []interface{}{tagColumn("http.method"), tagColumn("error")}
+	for _, traceLevel := range []bool{false, true} {
+		fake := newFakeQuestDB(t, func(query string) fakeResult {
+			if strings.Contains(query, "table_columns") {
+				return fakeResult{columns: []string{"column"}, dataset: [][]interface{}{columns[:1], columns[1:]}}
+			}
+			return fakeResult{columns: []string{"trace_id"}}
+		})
+		writer := NewWriter(fake.client(t), Options{Options: spans.Options{TraceLevelMatching: traceLevel}}, metrics.NullFactory, zap.NewNop())
+		_, err := writer.FindTraceIDs(context.Background(), splitTagsQuery())
+		searches := fake.matching("SELECT trace_id FROM ( SELECT trace_id")
+		spanSearches := fake.matching("SELECT DISTINCT trace_id")
+		fake.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !traceLevel {
+			// every tag must be matched by the same span
+			if len(searches) != 0 || len(spanSearches) != 1 {
+				t.Fatalf("span-level matching searched with %q", fake.recorded())
+			}
+			for _, column := range columns {
+				if !strings.Contains(spanSearches[0], fmt.Sprintf(" AND  %s = ", column)) {
+					t.Errorf("search %q doesn't filter the spans by %s", spanSearches[0], column)
+				}
+			}
+			continue
+		}
+		if len(searches) != 1 {
+			t.Fatalf("trace-level matching searched with %q", searches)
+		}
+		// each tag is counted on its own over the spans of the trace, the spans aren't filtered by tag
+		search := searches[0]
+		for _, column := range columns {
+			if !strings.Contains(search, fmt.Sprintf("sum(CASE WHEN  %s = ", column)) {
+				t.Errorf("search %q doesn't count the spans matching %s", search, column)
+			}
+		}
+		where := search[strings.Index(search, " WHERE "):strings.Index(search, " GROUP BY ")]
+		if strings.Contains(where, columns[0].(string)) || strings.Contains(where, columns[1].(string)) {
+			t.Errorf("search %q filters the spans by tag", search)
+		}
+		if !strings.Contains(search, "match_0 > 0 AND match_1 > 0 AND match_2 > 0") {
+			t.Errorf("search %q doesn't require every criteria", search)
+		}
+	}
+}
+
+func TestFindTraceIDsTagColumnsError(t *testing.T) {
+	for _, traceLevel := range []bool{false, true} {
+		fake := newFakeQuestDB(t, func(query string) fakeResult {
+			if strings.Contains(query, "table_columns") {
+				return fakeResult{err: "table does not exist"}
+			}
+			return fakeResult{columns: []string{"trace_id"}}
+		})
+		writer := NewWriter(fake.client(t), Options{Options: spans.Options{TraceLevelMatching: traceLevel}}, metrics.NullFactory, zap.NewNop())
+		_, err := writer.FindTraceIDs(context.Background(), splitTagsQuery())
+		fake.Close()
+		// a failing lookup isn't mistaken for tags that were never written
+		if err == nil || !strings.Contains(err.Error(), "table does not exist") {
+			t.Errorf("trace-level matching %v returned %v, expected the lookup error", traceLevel, err)
+		}
+	}
+}
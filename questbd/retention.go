@@ -0,0 +1,170 @@
+package questbd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+// partitionUnits are the partition units supported by QuestDB tables with a designated timestamp
+var partitionUnits = map[string]struct{}{
+	"NONE":  {},
+	"HOUR":  {},
+	"DAY":   {},
+	"MONTH": {},
+	"YEAR":  {},
+}
+
+type retentionMetrics struct {
+	// Runs is the number of times the retention job ran
+	Runs metrics.Counter `metric:"retention.runs"`
+	// Errors is the number of retention runs that failed
+	Errors metrics.Counter `metric:"retention.errors"`
+	// ExpiredSpans is the number of spans older than the retention window in the last run
+	ExpiredSpans metrics.Gauge `metric:"retention.expired-spans"`
+}
+
+// RetentionJob periodically drops the partitions of a table that are older than the retention window.
+type RetentionJob struct {
+	questDB     *QuestDBRest
+	table       string
+	partitionBy string
+	retention   time.Duration
+	interval    time.Duration
+	dryRun      bool
+	logger      *zap.Logger
+	metrics     retentionMetrics
+	close       chan struct{}
+}
+
+func NewRetentionJob(questDB *QuestDBRest, options Options, metricsFactory metrics.Factory, logger *zap.Logger) *RetentionJob {
+	job := &RetentionJob{
+		questDB:     questDB,
+		table:       "traces",
+		partitionBy: strings.ToUpper(options.PartitionBy),
+		retention:   options.Retention,
+		interval:    options.RetentionInterval,
+		dryRun:      options.RetentionDryRun,
+		logger:      logger,
+		close:       make(chan struct{}),
+	}
+	metrics.MustInit(&job.metrics, metricsFactory, nil)
+	return job
+}
+
+// cutoff returns the start of the partition containing now minus the retention window, every
+// partition before it only holds expired spans.
+func (r *RetentionJob) cutoff(now time.Time) time.Time {
+	cutoff := now.Add(-r.retention).UTC()
+	switch r.partitionBy {
+	case "HOUR":
+		return cutoff.Truncate(time.Hour)
+	case "DAY":
+		return time.Date(cutoff.Year(), cutoff.Month(), cutoff.Day(), 0, 0, 0, 0, time.UTC)
+	case "MONTH":
+		return time.Date(cutoff.Year(), cutoff.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "YEAR":
+		return time.Date(cutoff.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return cutoff
+}
+
+func (r *RetentionJob) run() error {
+	cutoff := escape(r.cutoff(time.Now()).Format("2006-01-02T15:04:05.999Z"))
+	rows, err := r.questDB.Query(fmt.Sprintf("SELECT count() FROM %s WHERE start_time < %s", r.table, cutoff))
+	if err != nil {
+		return err
+	}
+	var expired float64
+	if rows.Next() {
+		expired, _ = rows.Get()[0].(float64)
+	}
+	r.metrics.ExpiredSpans.Update(int64(expired))
+	if expired == 0 {
+		return nil
+	}
+	if r.dryRun {
+		r.logger.Info("Retention dry run, partitions not dropped",
+			zap.String("table", r.table), zap.String("before", cutoff), zap.Int64("spans", int64(expired)))
+		return nil
+	}
+	_, err = r.questDB.Exec(fmt.Sprintf("ALTER TABLE %s DROP PARTITION WHERE start_time < %s", r.table, cutoff))
+	if err != nil {
+		return err
+	}
+	r.logger.Info("Dropped expired partitions",
+		zap.String("table", r.table), zap.String("before", cutoff), zap.Int64("spans", int64(expired)))
+	return nil
+}
+
+func (r *RetentionJob) tick() {
+	r.metrics.Runs.Inc(1)
+	if err := r.run(); err != nil {
+		r.metrics.Errors.Inc(1)
+		r.logger.Error("Retention job failed", zap.Error(err))
+	}
+}
+
+// loop runs the job right away, then every interval
+func (r *RetentionJob) loop() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.tick()
+	for {
+		select {
+		case <-ticker.C:
+			r.tick()
+		case <-r.close:
+			return
+		}
+	}
+}
+
+// tablePartitionBy returns the partition unit of the existing table, QuestDB can't drop the
+// partitions of a table created before it was partitioned.
+func (r *RetentionJob) tablePartitionBy() (string, error) {
+	rows, err := r.questDB.Query(fmt.Sprintf("SELECT partitionBy FROM tables() WHERE name = %s", escape(r.table)))
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() {
+		return "", fmt.Errorf("table %s doesn't exist", r.table)
+	}
+	partitionBy, _ := rows.Get()[0].(string)
+	return strings.ToUpper(partitionBy), nil
+}
+
+// Start runs the retention job in background until Close is called. It fails when the table isn't
+// partitioned, as dropping its partitions would fail every interval.
+func (r *RetentionJob) Start() error {
+	if _, ok := partitionUnits[r.partitionBy]; !ok || r.partitionBy == "NONE" {
+		return fmt.Errorf("retention requires a partitioned table, partition unit is '%s'", r.partitionBy)
+	}
+	if r.interval <= 0 {
+		return fmt.Errorf("retention interval must be positive, got %v", r.interval)
+	}
+	partitionBy, err := r.tablePartitionBy()
+	if err != nil {
+		return err
+	}
+	if partitionBy == "" || partitionBy == "NONE" {
+		return fmt.Errorf("retention requires a partitioned table, %s was created without partitions, "+
+			"export it with the migrate command, drop it and import it back, or disable the retention", r.table)
+	}
+	if partitionBy != r.partitionBy {
+		r.logger.Warn("Table partitioned by another unit than configured, partitions are dropped by its unit",
+			zap.String("table", r.table), zap.String("configured", r.partitionBy), zap.String("partitionBy", partitionBy))
+		r.partitionBy = partitionBy
+	}
+	go r.loop()
+	return nil
+}
+
+func (r *RetentionJob) Close() error {
+	close(r.close)
+	return nil
+}
@@ -0,0 +1,106 @@
+package questbd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+// retentionFake answers the partition unit of the traces table and the count of expired spans
+func retentionFake(t *testing.T, partitionBy string, expired float64) *fakeQuestDB {
+	return newFakeQuestDB(t, func(query string) fakeResult {
+		switch {
+		case strings.Contains(query, "FROM tables()"):
+			return fakeResult{columns: []string{"partitionBy"}, dataset: [][]interface{}{{partitionBy}}}
+		case strings.HasPrefix(query, "SELECT count()"):
+			return fakeResult{columns: []string{"count"}, dataset: [][]interface{}{{expired}}}
+		}
+		return fakeResult{}
+	})
+}
+
+func newTestRetentionJob(questDB *QuestDBRest, dryRun bool) *RetentionJob {
+	return NewRetentionJob(questDB, Options{
+		PartitionBy:       "day",
+		Retention:         48 * time.Hour,
+		RetentionInterval: time.Hour,
+		RetentionDryRun:   dryRun,
+	}, metrics.NullFactory, zap.NewNop())
+}
+
+func TestRetentionStartRunsRightAway(t *testing.T) {
+	fake := retentionFake(t, "DAY", 10)
+	defer fake.Close()
+	job := newTestRetentionJob(fake.client(t), false)
+	if err := job.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.matching("DROP PARTITION")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("partitions not dropped at start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	drop := fake.matching("DROP PARTITION")[0]
+	if !strings.HasPrefix(drop, "ALTER TABLE traces DROP PARTITION WHERE start_time < '") || !strings.Contains(drop, "T00:00:00") {
+		t.Errorf("partitions dropped with %q, expected a cutoff at the start of a day", drop)
+	}
+}
+
+func TestRetentionStartUnpartitionedTable(t *testing.T) {
+	for _, partitionBy := range []string{"NONE", ""} {
+		fake := retentionFake(t, partitionBy, 10)
+		job := newTestRetentionJob(fake.client(t), false)
+		if err := job.Start(); err == nil {
+			job.Close()
+			t.Errorf("retention started on a table partitioned by %q", partitionBy)
+		}
+		if queries := fake.matching("SELECT count()"); len(queries) != 0 {
+			t.Errorf("retention ran on a table partitioned by %q", partitionBy)
+		}
+		fake.Close()
+	}
+}
+
+func TestRetentionStartOtherPartitionUnit(t *testing.T) {
+	fake := retentionFake(t, "HOUR", 10)
+	defer fake.Close()
+	job := newTestRetentionJob(fake.client(t), false)
+	if err := job.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+	if job.partitionBy != "HOUR" {
+		t.Errorf("partitions dropped by %s, expected the unit of the table", job.partitionBy)
+	}
+}
+
+func TestRetentionDryRun(t *testing.T) {
+	fake := retentionFake(t, "DAY", 10)
+	defer fake.Close()
+	job := newTestRetentionJob(fake.client(t), true)
+	if err := job.run(); err != nil {
+		t.Fatal(err)
+	}
+	if drops := fake.matching("DROP PARTITION"); len(drops) != 0 {
+		t.Errorf("dry run dropped partitions with %q", drops)
+	}
+}
+
+func TestRetentionNothingExpired(t *testing.T) {
+	fake := retentionFake(t, "DAY", 0)
+	defer fake.Close()
+	job := newTestRetentionJob(fake.client(t), false)
+	if err := job.run(); err != nil {
+		t.Fatal(err)
+	}
+	if drops := fake.matching("DROP PARTITION"); len(drops) != 0 {
+		t.Errorf("partitions dropped with %q without expired spans", drops)
+	}
+}
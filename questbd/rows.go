@@ -0,0 +1,264 @@
+package questbd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Rows iterates over the results of a query, decoding the dataset of the /exec response row by row.
+// When the page size is positive the query is run once per page with the limit parameter, so each
+// response holds at most a page. Errors stop the iteration and are returned by Err.
+//
+//	rows := questDB.Stream("SELECT span FROM traces", 1000)
+//	defer rows.Close()
+//	for rows.Next() {
+//		blob := rows.String(0)
+//	}
+//	if err := rows.Err(); err != nil {
+type Rows struct {
+	questDB  *QuestDBRest
+	query    string
+	pageSize int
+	// offset is the index of the first row of the current page
+	offset   int
+	pageRows int
+	columns  []string
+	body     io.ReadCloser
+	decoder  *json.Decoder
+	row      []interface{}
+	done     bool
+	err      error
+}
+
+// Stream returns the iterator over the results of the query, fetched by pages of pageSize rows or
+// all at once when pageSize isn't positive. Paged queries should have a stable order.
+func (q *QuestDBRest) Stream(query string, pageSize int) *Rows {
+	return &Rows{
+		questDB:  q,
+		query:    query,
+		pageSize: pageSize,
+	}
+}
+
+// openPage runs the query for the page at offset and positions the decoder on its first row.
+func (r *Rows) openPage() error {
+	endpoint := r.questDB.baseURL.ResolveReference(&url.URL{Path: "/exec"})
+	parameters := url.Values{}
+	parameters.Add("query", r.query)
+	if r.pageSize > 0 {
+		parameters.Add("limit", fmt.Sprintf("%d,%d", r.offset, r.offset+r.pageSize))
+	}
+	endpoint.RawQuery = parameters.Encode()
+	req, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.questDB.do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		results := questDBResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil || results.Error == "" {
+			return fmt.Errorf("query failed with status %d", resp.StatusCode)
+		}
+		return fmt.Errorf(results.Error)
+	}
+
+	r.body = resp.Body
+	r.decoder = json.NewDecoder(resp.Body)
+	// keeps longs exact, they would be rounded as float64
+	r.decoder.UseNumber()
+	r.pageRows = 0
+	if err := r.expect(json.Delim('{')); err != nil {
+		return err
+	}
+	for r.decoder.More() {
+		key, err := r.decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "columns":
+			var columns []struct {
+				Name string
+			}
+			if err := r.decoder.Decode(&columns); err != nil {
+				return err
+			}
+			r.columns = make([]string, len(columns))
+			for i, column := range columns {
+				r.columns[i] = column.Name
+			}
+		case "dataset":
+			return r.expect(json.Delim('['))
+		case "error":
+			var message string
+			if err := r.decoder.Decode(&message); err != nil {
+				return err
+			}
+			return fmt.Errorf(message)
+		default:
+			var skipped json.RawMessage
+			if err := r.decoder.Decode(&skipped); err != nil {
+				return err
+			}
+		}
+	}
+	// statements without a dataset
+	r.closePage()
+	r.done = true
+	return nil
+}
+
+func (r *Rows) expect(delim json.Delim) error {
+	token, err := r.decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("unexpected %v in the response, expected %v", token, delim)
+	}
+	return nil
+}
+
+func (r *Rows) closePage() {
+	if r.body != nil {
+		r.body.Close()
+	}
+	r.body = nil
+	r.decoder = nil
+}
+
+// Next moves to the next row, it returns false at the end of the results or on error.
+func (r *Rows) Next() bool {
+	for r.err == nil && !r.done {
+		if r.decoder == nil {
+			r.err = r.openPage()
+			continue
+		}
+		if r.decoder.More() {
+			var row []interface{}
+			if r.err = r.decoder.Decode(&row); r.err == nil {
+				r.row = row
+				r.pageRows++
+				return true
+			}
+			continue
+		}
+		r.closePage()
+		// a partial page is the last one
+		if r.pageSize <= 0 || r.pageRows < r.pageSize {
+			r.done = true
+		}
+		r.offset += r.pageRows
+	}
+	r.row = nil
+	r.closePage()
+	return false
+}
+
+// Err returns the error that stopped the iteration, nil when all the rows were read.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close stops the iteration, it is safe to call it more than once.
+func (r *Rows) Close() error {
+	r.done = true
+	r.row = nil
+	r.closePage()
+	return nil
+}
+
+// Columns returns the column names, known once Next has been called.
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Values returns the raw values of the current row, nil before Next and after the last row.
+func (r *Rows) Values() []interface{} {
+	return r.row
+}
+
+func (r *Rows) setErr(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *Rows) value(column int) interface{} {
+	if column < 0 || column >= len(r.row) {
+		r.setErr(fmt.Errorf("column %d out of range of a row of %d", column, len(r.row)))
+		return nil
+	}
+	return r.row[column]
+}
+
+// String returns the value of the column in the current row as a string, empty when it is null.
+func (r *Rows) String(column int) string {
+	switch value := r.value(column).(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// Long returns the value of an integer column in the current row, 0 when it is null.
+func (r *Rows) Long(column int) int64 {
+	switch value := r.value(column).(type) {
+	case nil:
+		return 0
+	case json.Number:
+		long, err := value.Int64()
+		if err != nil {
+			r.setErr(fmt.Errorf("column %d: %w", column, err))
+		}
+		return long
+	default:
+		r.setErr(fmt.Errorf("column %d is not a long: %v", column, value))
+		return 0
+	}
+}
+
+// Double returns the value of a numeric column in the current row, 0 when it is null.
+func (r *Rows) Double(column int) float64 {
+	switch value := r.value(column).(type) {
+	case nil:
+		return 0
+	case json.Number:
+		double, err := value.Float64()
+		if err != nil {
+			r.setErr(fmt.Errorf("column %d: %w", column, err))
+		}
+		return double
+	default:
+		r.setErr(fmt.Errorf("column %d is not a number: %v", column, value))
+		return 0
+	}
+}
+
+// Timestamp returns the value of a timestamp column in the current row, the zero time when it is null.
+func (r *Rows) Timestamp(column int) time.Time {
+	switch value := r.value(column).(type) {
+	case nil:
+		return time.Time{}
+	case string:
+		timestamp, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			r.setErr(fmt.Errorf("column %d: %w", column, err))
+		}
+		return timestamp
+	default:
+		r.setErr(fmt.Errorf("column %d is not a timestamp: %v", column, value))
+		return time.Time{}
+	}
+}
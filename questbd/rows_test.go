@@ -0,0 +1,156 @@
+package questbd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// letters returns a dataset of n rows of one string column
+func letters(n int) fakeResult {
+	dataset := make([][]interface{}, n)
+	for i := range dataset {
+		dataset[i] = []interface{}{string(rune('a' + i))}
+	}
+	return fakeResult{columns: []string{"letter"}, dataset: dataset}
+}
+
+func readAll(rows *Rows) string {
+	var read []string
+	for rows.Next() {
+		read = append(read, rows.String(0))
+	}
+	return strings.Join(read, "")
+}
+
+func TestRowsPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     int
+		pageSize int
+		requests int
+	}{
+		{name: "partial last page", rows: 5, pageSize: 2, requests: 3},
+		{name: "full last page", rows: 4, pageSize: 2, requests: 3},
+		{name: "empty", rows: 0, pageSize: 2, requests: 1},
+		{name: "unpaged", rows: 5, pageSize: 0, requests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeQuestDB(t, func(query string) fakeResult {
+				return letters(test.rows)
+			})
+			defer fake.Close()
+
+			rows := fake.client(t).Stream("SELECT letter FROM letters", test.pageSize)
+			defer rows.Close()
+			read := readAll(rows)
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if expected := "abcde"[:test.rows]; read != expected {
+				t.Errorf("read %q, expected %q", read, expected)
+			}
+			if requests := len(fake.recorded()); requests != test.requests {
+				t.Errorf("%d requests, expected %d", requests, test.requests)
+			}
+		})
+	}
+}
+
+func TestRowsErrorOnLaterPage(t *testing.T) {
+	pages := 0
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		pages++
+		if pages == 2 {
+			return fakeResult{err: "out of memory"}
+		}
+		return letters(5)
+	})
+	defer fake.Close()
+
+	rows := fake.client(t).Stream("SELECT letter FROM letters", 2)
+	defer rows.Close()
+	if read := readAll(rows); read != "ab" {
+		t.Errorf("read %q, expected the first page", read)
+	}
+	if err := rows.Err(); err == nil || err.Error() != "out of memory" {
+		t.Errorf("error is %v, expected the error of the second page", err)
+	}
+	if rows.Next() {
+		t.Error("iteration resumed after an error")
+	}
+}
+
+func TestRowsTruncatedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the connection is closed in the middle of the dataset
+		fmt.Fprint(w, `{"query":"SELECT letter FROM letters","columns":[{"name":"letter","type":"STRING"}],"dataset":[["a"],["b"],["c`)
+	}))
+	defer server.Close()
+	client, err := NewQuestDBRest(Options{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := client.Stream("SELECT letter FROM letters", 0)
+	defer rows.Close()
+	if read := readAll(rows); read != "ab" {
+		t.Errorf("read %q, expected the complete rows", read)
+	}
+	if rows.Err() == nil {
+		t.Error("truncated response read without error")
+	}
+	if rows.Values() != nil {
+		t.Errorf("values are %v after an error", rows.Values())
+	}
+}
+
+func TestRowsValuesOutsideIteration(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		return letters(1)
+	})
+	defer fake.Close()
+
+	rows := fake.client(t).Stream("SELECT letter FROM letters", 2)
+	defer rows.Close()
+	if rows.Values() != nil || rows.String(0) != "" {
+		t.Errorf("values are %v before Next", rows.Values())
+	}
+	if rows.Err() == nil {
+		t.Error("reading a column before Next isn't reported")
+	}
+
+	rows = fake.client(t).Stream("SELECT letter FROM letters", 2)
+	defer rows.Close()
+	readAll(rows)
+	if rows.Values() != nil {
+		t.Errorf("values are %v after the last row", rows.Values())
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRowGetOutsideIteration(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		return letters(1)
+	})
+	defer fake.Close()
+
+	row, err := fake.client(t).Query("SELECT letter FROM letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := row.Get(); values != nil {
+		t.Errorf("values are %v before Next", values)
+	}
+	if !row.Next() || row.Get()[0] != "a" {
+		t.Errorf("first row is %v", row.Get())
+	}
+	if row.Next() || row.Get() != nil {
+		t.Errorf("values are %v after the last row", row.Get())
+	}
+}
@@ -0,0 +1,179 @@
+package questbd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/cmd/collector/app/sampling/model"
+)
+
+const (
+	throughputTable    = "sampling_throughput"
+	probabilitiesTable = "sampling_probabilities"
+)
+
+// SamplingStore implements samplingstore.Store on top of QuestDB, throughput and probabilities are
+// appended to their own tables and read back by time range.
+type SamplingStore struct {
+	questDB *QuestDBRest
+}
+
+func NewSamplingStore(questDB *QuestDBRest) *SamplingStore {
+	return &SamplingStore{
+		questDB: questDB,
+	}
+}
+
+// CreateTables creates the sampling tables if they don't exist yet.
+func (s *SamplingStore) CreateTables() error {
+	tables := map[string]string{
+		throughputTable: "CREATE TABLE %s ( " +
+			"ts            timestamp," +
+			"service       symbol," +
+			"operation     string," +
+			"count         long," +
+			"probabilities string" +
+			") timestamp(ts)",
+		probabilitiesTable: "CREATE TABLE %s ( " +
+			"ts       timestamp," +
+			"hostname symbol," +
+			"data     string" +
+			") timestamp(ts)",
+	}
+	for name, statement := range tables {
+		table := &Table{name: name, questDB: s.questDB}
+		exist, err := table.Exist()
+		if err != nil {
+			return err
+		}
+		if exist {
+			continue
+		}
+		if _, err := s.questDB.Exec(fmt.Sprintf(statement, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func timeRangeCondition(start, end time.Time) string {
+	return "ts >= " + escape(start.UTC().Format("2006-01-02T15:04:05.999Z")) +
+		" AND ts <= " + escape(end.UTC().Format("2006-01-02T15:04:05.999Z"))
+}
+
+// InsertThroughput inserts aggregated throughput for operations into storage.
+func (s *SamplingStore) InsertThroughput(throughput []*model.Throughput) error {
+	if len(throughput) == 0 {
+		return nil
+	}
+	now := time.Now().UnixNano() / 1000
+	rows := make([]string, len(throughput))
+	for i, t := range throughput {
+		probabilities := make([]string, 0, len(t.Probabilities))
+		for probability := range t.Probabilities {
+			probabilities = append(probabilities, probability)
+		}
+		rows[i] = fmt.Sprintf("( %s, %s, %s, %s, %s )",
+			escape(now), escape(t.Service), escape(t.Operation), escape(t.Count), escape(strings.Join(probabilities, ",")))
+	}
+	query := fmt.Sprintf("INSERT INTO %s ( ts, service, operation, count, probabilities ) VALUES %s",
+		throughputTable, strings.Join(rows, ","))
+	_, err := s.questDB.Exec(query)
+	return err
+}
+
+// GetThroughput retrieves aggregated throughput for operations within a time range.
+func (s *SamplingStore) GetThroughput(start, end time.Time) ([]*model.Throughput, error) {
+	query := fmt.Sprintf("SELECT service, operation, count, probabilities FROM %s WHERE %s",
+		throughputTable, timeRangeCondition(start, end))
+	rows, err := s.questDB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	throughput := make([]*model.Throughput, 0, rows.Count())
+	for rows.Next() {
+		row := rows.Get()
+		count, _ := row[2].(float64)
+		t := &model.Throughput{
+			Service:       fmt.Sprintf("%v", row[0]),
+			Operation:     fmt.Sprintf("%v", row[1]),
+			Count:         int64(count),
+			Probabilities: make(map[string]struct{}),
+		}
+		if probabilities, ok := row[3].(string); ok && probabilities != "" {
+			for _, probability := range strings.Split(probabilities, ",") {
+				t.Probabilities[probability] = struct{}{}
+			}
+		}
+		throughput = append(throughput, t)
+	}
+	return throughput, nil
+}
+
+// InsertProbabilitiesAndQPS inserts calculated sampling probabilities and measured qps into storage.
+func (s *SamplingStore) InsertProbabilitiesAndQPS(hostname string, probabilities model.ServiceOperationProbabilities, qps model.ServiceOperationQPS) error {
+	data := make(model.ServiceOperationData, len(probabilities))
+	for service, operations := range probabilities {
+		data[service] = make(map[string]*model.ProbabilityAndQPS, len(operations))
+		for operation, probability := range operations {
+			data[service][operation] = &model.ProbabilityAndQPS{
+				Probability: probability,
+				QPS:         qps[service][operation],
+			}
+		}
+	}
+	serialized, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s ( ts, hostname, data ) VALUES ( %s, %s, %s )",
+		probabilitiesTable, escape(time.Now().UnixNano()/1000), escape(hostname), escape(string(serialized)))
+	_, err = s.questDB.Exec(query)
+	return err
+}
+
+// GetProbabilitiesAndQPS retrieves the sampling probabilities and measured qps per host within a time range.
+func (s *SamplingStore) GetProbabilitiesAndQPS(start, end time.Time) (map[string][]model.ServiceOperationData, error) {
+	query := fmt.Sprintf("SELECT hostname, data FROM %s WHERE %s", probabilitiesTable, timeRangeCondition(start, end))
+	rows, err := s.questDB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	hostsData := make(map[string][]model.ServiceOperationData)
+	for rows.Next() {
+		row := rows.Get()
+		hostname := fmt.Sprintf("%v", row[0])
+		data := model.ServiceOperationData{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf("%v", row[1])), &data); err != nil {
+			return nil, err
+		}
+		hostsData[hostname] = append(hostsData[hostname], data)
+	}
+	return hostsData, nil
+}
+
+// GetLatestProbabilities retrieves the latest sampling probabilities.
+func (s *SamplingStore) GetLatestProbabilities() (model.ServiceOperationProbabilities, error) {
+	query := fmt.Sprintf("SELECT data FROM %s ORDER BY ts DESC LIMIT 1", probabilitiesTable)
+	rows, err := s.questDB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	probabilities := model.ServiceOperationProbabilities{}
+	if !rows.Next() {
+		return probabilities, nil
+	}
+	data := model.ServiceOperationData{}
+	if err := json.Unmarshal([]byte(fmt.Sprintf("%v", rows.Get()[0])), &data); err != nil {
+		return nil, err
+	}
+	for service, operations := range data {
+		probabilities[service] = make(map[string]float64, len(operations))
+		for operation, value := range operations {
+			probabilities[service][operation] = value.Probability
+		}
+	}
+	return probabilities, nil
+}
@@ -0,0 +1,197 @@
+package questbd
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/cmd/collector/app/sampling/model"
+)
+
+// samplingTables emulates the sampling tables: inserted rows are parsed and returned by the selects
+// in insertion order, the latest first when ordered by descending ts.
+type samplingTables struct {
+	t    *testing.T
+	mtx  sync.Mutex
+	rows map[string][]map[string]interface{}
+}
+
+func newSamplingStore(t *testing.T) (*SamplingStore, *fakeQuestDB) {
+	tables := &samplingTables{t: t, rows: map[string][]map[string]interface{}{}}
+	fake := newFakeQuestDB(t, tables.exec)
+	return NewSamplingStore(fake.client(t)), fake
+}
+
+func (s *samplingTables) exec(query string) fakeResult {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if strings.HasPrefix(query, "INSERT INTO ") {
+		fields := strings.Fields(query)
+		table := fields[2]
+		columns := strings.Split(query[strings.Index(query, "( ")+2:strings.Index(query, " )")], ", ")
+		for _, values := range parseValues(s.t, query[strings.Index(query, " VALUES ")+8:]) {
+			if len(values) != len(columns) {
+				s.t.Errorf("%d values inserted in %d columns: %q", len(values), len(columns), query)
+				continue
+			}
+			row := map[string]interface{}{}
+			for i, column := range columns {
+				row[column] = values[i]
+			}
+			s.rows[table] = append(s.rows[table], row)
+		}
+		return fakeResult{}
+	}
+	if !strings.HasPrefix(query, "SELECT ") {
+		s.t.Errorf("unexpected query %q", query)
+		return fakeResult{}
+	}
+	columns := strings.Split(query[len("SELECT "):strings.Index(query, " FROM ")], ", ")
+	table := strings.Fields(query[strings.Index(query, " FROM ")+6:])[0]
+	rows := s.rows[table]
+	if strings.HasSuffix(query, " ORDER BY ts DESC LIMIT 1") && len(rows) > 0 {
+		rows = rows[len(rows)-1:]
+	}
+	result := fakeResult{columns: columns}
+	for _, row := range rows {
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		result.dataset = append(result.dataset, values)
+	}
+	return result
+}
+
+// parseValues parses the ( value, ... ), ... tuples of an insert, strings are quoted and numbers aren't.
+func parseValues(t *testing.T, tuples string) [][]interface{} {
+	var rows [][]interface{}
+	var row []interface{}
+	for i := 0; i < len(tuples); i++ {
+		switch c := tuples[i]; {
+		case c == '(':
+			row = []interface{}{}
+		case c == ')':
+			rows = append(rows, row)
+		case c == '\'':
+			var value strings.Builder
+			for i++; i < len(tuples); i++ {
+				if tuples[i] == '\'' {
+					if i+1 < len(tuples) && tuples[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+				value.WriteByte(tuples[i])
+			}
+			row = append(row, value.String())
+		case c >= '0' && c <= '9' || c == '-':
+			end := i
+			for end < len(tuples) && strings.IndexByte("-0123456789", tuples[end]) >= 0 {
+				end++
+			}
+			number, err := strconv.ParseInt(tuples[i:end], 10, 64)
+			if err != nil {
+				t.Errorf("invalid number in %q: %v", tuples, err)
+			}
+			row = append(row, number)
+			i = end - 1
+		}
+	}
+	return rows
+}
+
+func TestSamplingThroughput(t *testing.T) {
+	store, fake := newSamplingStore(t)
+	defer fake.Close()
+
+	throughput := []*model.Throughput{
+		{Service: "frontend", Operation: "get", Count: 42, Probabilities: map[string]struct{}{"0.5": {}, "0.25": {}}},
+		{Service: "o'brien", Operation: "post", Count: 1, Probabilities: map[string]struct{}{}},
+	}
+	if err := store.InsertThroughput(throughput); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertThroughput(nil); err != nil {
+		t.Fatal(err)
+	}
+	if inserts := fake.matching("INSERT INTO"); len(inserts) != 1 {
+		t.Errorf("inserts are %q, expected one for all the operations", inserts)
+	}
+
+	now := time.Now()
+	stored, err := store.GetThroughput(now.Add(-time.Minute), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, throughput) {
+		t.Errorf("throughput is %+v, expected %+v", stored, throughput)
+	}
+	if selects := fake.matching("SELECT service"); len(selects) != 1 || !strings.Contains(selects[0], "WHERE ts >= ") {
+		t.Errorf("throughput read with %q, expected a time range", selects)
+	}
+}
+
+func TestSamplingProbabilities(t *testing.T) {
+	store, fake := newSamplingStore(t)
+	defer fake.Close()
+
+	probabilities, err := store.GetLatestProbabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probabilities) != 0 {
+		t.Errorf("probabilities are %v before any insert", probabilities)
+	}
+
+	first := model.ServiceOperationProbabilities{"frontend": {"get": 0.5}}
+	latest := model.ServiceOperationProbabilities{"frontend": {"get": 0.1, "post": 1}, "o'brien": {"get": 0.75}}
+	qps := model.ServiceOperationQPS{"frontend": {"get": 12.5}}
+	if err := store.InsertProbabilitiesAndQPS("collector-1", first, qps); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertProbabilitiesAndQPS("collector-2", latest, qps); err != nil {
+		t.Fatal(err)
+	}
+
+	probabilities, err = store.GetLatestProbabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(probabilities, latest) {
+		t.Errorf("latest probabilities are %v, expected %v", probabilities, latest)
+	}
+
+	now := time.Now()
+	hosts, err := store.GetProbabilitiesAndQPS(now.Add(-time.Minute), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := model.ServiceOperationData{"frontend": {"get": {Probability: 0.5, QPS: 12.5}}}
+	if len(hosts) != 2 || !reflect.DeepEqual(hosts["collector-1"], []model.ServiceOperationData{expected}) {
+		t.Errorf("probabilities per host are %v, expected %v for collector-1", hosts, expected)
+	}
+}
+
+func TestSamplingReadErrors(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		return fakeResult{err: "table does not exist"}
+	})
+	defer fake.Close()
+	store := NewSamplingStore(fake.client(t))
+
+	now := time.Now()
+	if _, err := store.GetThroughput(now.Add(-time.Minute), now); err == nil {
+		t.Error("throughput read from a failing query")
+	}
+	if _, err := store.GetLatestProbabilities(); err == nil {
+		t.Error("probabilities read from a failing query")
+	}
+	if err := store.InsertThroughput([]*model.Throughput{{Service: "frontend"}}); err == nil {
+		t.Error("throughput inserted with a failing query")
+	}
+}
@@ -1,36 +1,144 @@
 package questbd
 
 import (
-	"bytes"
+	"encoding/csv"
 	"fmt"
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/pkg/multierror"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/traceid"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
 var baseColumns = []string{
-	"trace_id", "span_id", "parent_id", "operation_name", "flags", "start_time", "duration", "service_name", "span",
+	"trace_id", "span_id", "parent_id", "operation_name", "flags", "start_time", "duration", "service_name", "span_kind", "tenant", "span",
 }
 
-var periodPerBlock = time.Second.Nanoseconds() * 60
-
-var bufPool = sync.Pool{
-	New: func() interface{} {
-		return new(bytes.Buffer)
-	},
+// baseColumnTypes are the types of the base columns in the schema of CSV imports, tag columns are strings
+var baseColumnTypes = map[string]string{
+	"trace_id":       "SYMBOL",
+	"span_id":        "LONG",
+	"parent_id":      "LONG",
+	"operation_name": "STRING",
+	"flags":          "INT",
+	"start_time":     "TIMESTAMP",
+	"duration":       "INT",
+	"service_name":   "SYMBOL",
+	"span_kind":      "SYMBOL",
+	"tenant":         "SYMBOL",
+	"span":           "STRING",
 }
 
+var periodPerBlock = time.Second.Nanoseconds() * 60
+
 const (
-	tagPrefix = "__tag__prefix"
+	// tagSeparator joins the tag prefix and the sanitized tag key in the column names
+	tagSeparator = "__prefix_"
+
+	// maxRowsPerInsert is the maximum number of rows written by a single INSERT statement
+	maxRowsPerInsert = 100
+
+	// importTimeLayout formats start_time in CSV imports, importTimePattern is the same format for QuestDB
+	importTimeLayout  = "2006-01-02T15:04:05.000000Z"
+	importTimePattern = "yyyy-MM-ddTHH:mm:ss.SSSUUUZ"
 )
 
+// spanRecord is a buffered row of the traces table, values are escaped when the row is inserted.
+type spanRecord struct {
+	traceID       string
+	spanID        int64
+	parentID      int64
+	operationName string
+	flags         int32
+	startTime     int64
+	duration      int64
+	serviceName   string
+	spanKind      string
+	tenant        string
+	span          string
+	// tags maps tag columns to their values
+	tags map[string]string
+}
+
+// tagColumns returns the sorted tag columns of the record, records sharing them are inserted together.
+func (r *spanRecord) tagColumns() []string {
+	columns := make([]string, 0, len(r.tags))
+	for column := range r.tags {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func (r *spanRecord) values(tagColumns []string) string {
+	values := []string{
+		escape(r.traceID),
+		escape(r.spanID),
+		escape(r.parentID),
+		escape(r.operationName),
+		escape(r.flags),
+		escape(r.startTime),
+		escape(r.duration),
+		escape(r.serviceName),
+		escape(r.spanKind),
+		escape(r.tenant),
+		escape(r.span),
+	}
+	for _, column := range tagColumns {
+		values = append(values, escape(r.tags[column]))
+	}
+	return "( " + strings.Join(values, ",") + " )"
+}
+
+// csvValues returns the CSV fields of the record in the order of columns, empty for the tags it doesn't have.
+func (r *spanRecord) csvValues(columns []string) []string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "trace_id":
+			values[i] = r.traceID
+		case "span_id":
+			values[i] = strconv.FormatInt(r.spanID, 10)
+		case "parent_id":
+			values[i] = strconv.FormatInt(r.parentID, 10)
+		case "operation_name":
+			values[i] = r.operationName
+		case "flags":
+			values[i] = strconv.FormatInt(int64(r.flags), 10)
+		case "start_time":
+			values[i] = time.Unix(0, r.startTime*1000).UTC().Format(importTimeLayout)
+		case "duration":
+			values[i] = strconv.FormatInt(r.duration, 10)
+		case "service_name":
+			values[i] = r.serviceName
+		case "span_kind":
+			values[i] = r.spanKind
+		case "tenant":
+			values[i] = r.tenant
+		case "span":
+			values[i] = r.span
+		default:
+			values[i] = r.tags[column]
+		}
+	}
+	return values
+}
+
 type Table struct {
 	sync.RWMutex
-	questDB *QuestDBRest
-	name    string
-	lock    sync.Mutex
-	buffer  *bytes.Buffer
+	questDB     *QuestDBRest
+	name        string
+	partitionBy string
+	lock        sync.Mutex
+	buffer      []*spanRecord
+	codec       *spans.Codec
+	// baseColumns is set once the base columns are known to exist, guarded by lock
+	baseColumns bool
 }
 
 func (t *Table) Columns() ([]string, error) {
@@ -95,6 +203,9 @@ func (t *Table) createColumns(columns [] string) error {
 }
 
 func (t *Table) updateColumns(columns [] string) error {
+	if len(columns) == 0 {
+		return nil
+	}
 	newColumns, err := t.NeedToCreate(columns)
 	if err != nil {
 		return err
@@ -102,6 +213,19 @@ func (t *Table) updateColumns(columns [] string) error {
 	return t.createColumns(newColumns)
 }
 
+// ensureBaseColumns adds the base columns missing from a table created before them, such as
+// span_kind and tenant, before the first write. t.lock must be held.
+func (t *Table) ensureBaseColumns() error {
+	if t.baseColumns {
+		return nil
+	}
+	if err := t.addTypedColumns(baseColumnTypes); err != nil {
+		return err
+	}
+	t.baseColumns = true
+	return nil
+}
+
 func (t *Table) getLatest() *time.Time {
 	rows, _ := t.questDB.Query(fmt.Sprintf("select start_time from %s order by start_time desc limit 1", t.name))
 	if rows.Next() {
@@ -119,6 +243,8 @@ func (t *Table) Create(designated bool) error {
 		"parent_id      long," +
 		"operation_name string," +
 		"service_name   symbol," +
+		"span_kind      symbol," +
+		"tenant         symbol," +
 		"flags          int," +
 		"start_time     timestamp," +
 		"duration       int,  " +
@@ -128,6 +254,10 @@ func (t *Table) Create(designated bool) error {
 	timestamp := ""
 	if designated {
 		timestamp = " timestamp(start_time)"
+		// Partitions need a designated timestamp
+		if t.partitionBy != "" {
+			timestamp += " PARTITION BY " + t.partitionBy
+		}
 	}
 
 	_, err := t.questDB.Exec(fmt.Sprintf(traceTable, t.name, timestamp))
@@ -152,97 +282,170 @@ func (t *Table) Truncate() error {
 	return nil
 }
 
-func (t *Table) Flush() {
+func (t *Table) swapBuffer() []*spanRecord {
 	t.Lock()
-	oldBuf := t.buffer
-	t.buffer = bufPool.Get().(*bytes.Buffer)
-	t.buffer.Reset()
+	records := t.buffer
+	t.buffer = nil
 	t.Unlock()
-	go t.writeToStorage(oldBuf)
+	return records
 }
 
-func (t *Table) writeToStorage(buf *bytes.Buffer) {
+func (t *Table) Flush() {
+	go t.writeToStorage(t.swapBuffer())
+}
 
-	content := string(buf.Bytes())
-	items := strings.Split(content, "\n")
-	for _, item := range items {
-		item_parts := strings.Split(item, ";")
-		tagsKeys := strings.Split(item_parts[0], ",")
-		values := item_parts[1]
+// writeToStorage inserts the records grouped by tag columns, with multi-row INSERTs. A failing group
+// doesn't prevent the others from being inserted.
+func (t *Table) writeToStorage(records []*spanRecord) error {
+	groups := make(map[string][]*spanRecord)
+	groupColumns := make(map[string][]string)
+	for _, record := range records {
+		tagColumns := record.tagColumns()
+		key := strings.Join(tagColumns, ",")
+		groups[key] = append(groups[key], record)
+		groupColumns[key] = tagColumns
+	}
 
-		baseColumnsCount := len(baseColumns)
-		columns := make([]string, baseColumnsCount+len(tagsKeys))
+	var errs []error
+	for key, group := range groups {
+		if err := t.insert(groupColumns[key], group); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return multierror.Wrap(errs)
+}
 
-		for i, col := range baseColumns {
-			columns[i] = col
+func (t *Table) insert(tagColumns []string, records []*spanRecord) error {
+	columns := append(append([]string{}, baseColumns...), tagColumns...)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err := t.ensureBaseColumns(); err != nil {
+		return err
+	}
+	if err := t.updateColumns(tagColumns); err != nil {
+		return err
+	}
+	for start := 0; start < len(records); start += maxRowsPerInsert {
+		end := start + maxRowsPerInsert
+		if end > len(records) {
+			end = len(records)
 		}
-		for i := 0; i < len(tagsKeys); i ++ {
-			columns[baseColumnsCount+i] = tagsKeys[i]
+		rows := make([]string, 0, end-start)
+		for _, record := range records[start:end] {
+			rows = append(rows, record.values(tagColumns))
 		}
-		t.lock.Lock()
-		err := t.updateColumns(tagsKeys)
-		if err != nil {
-			t.lock.Unlock()
-			continue
+		query := fmt.Sprintf("INSERT INTO %s ( %s ) VALUES %s",
+			t.name, strings.Join(columns, ","), strings.Join(rows, ","))
+		if _, err := t.questDB.Exec(query); err != nil {
+			return err
 		}
-
-		query := fmt.Sprintf("INSERT INTO %s ( %s ) VALUES ( %s )",
-			t.name, strings.Join(columns, ","), values)
-
-		_, err = t.questDB.Exec(query)
-		t.lock.Unlock()
 	}
-
-	buf.Reset()
-	bufPool.Put(buf)
+	return nil
 }
 
-func (t *Table) WriteSpan(span *model.Span) error {
-
-	// deduplication.
-	tagsMap := make(map[string]string, len(span.Tags))
-	for _, tag := range span.Tags {
-		key := fmt.Sprintf("%s_%s", tagPrefix, sanitizeTagKey(tag.Key))
-		tagsMap[key] = escape(tag.AsString())
+// importRecords appends the records with a single CSV import, much faster than INSERTs for large
+// batches. The CSV is generated while it is sent to QuestDB.
+func (t *Table) importRecords(records []*spanRecord) error {
+	tagColumns := make(map[string]struct{})
+	for _, record := range records {
+		for column := range record.tags {
+			tagColumns[column] = struct{}{}
+		}
 	}
+	newColumns := make([]string, 0, len(tagColumns))
+	for column := range tagColumns {
+		newColumns = append(newColumns, column)
+	}
+	sort.Strings(newColumns)
 
-	tagsKeys := make([]string, len(tagsMap))
-	tagValues := make([]string, len(tagsMap))
-
-	// remove duplitaced keys
-
-	counter := 0
-	for key, value := range tagsMap {
-		tagsKeys[counter] = key
-		tagValues[counter] = value
-		counter++
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err := t.ensureBaseColumns(); err != nil {
+		return err
+	}
+	if err := t.updateColumns(newColumns); err != nil {
+		return err
+	}
+	// the CSV columns follow the order of the table, it is appended to
+	columns, err := t.Columns()
+	if err != nil {
+		return err
+	}
+	schema := make([]ImportColumn, len(columns))
+	for i, column := range columns {
+		schema[i] = ImportColumn{Name: column, Type: "STRING"}
+		if columnType, ok := baseColumnTypes[column]; ok {
+			schema[i].Type = columnType
+		}
+		if column == "start_time" {
+			schema[i].Pattern = importTimePattern
+		}
 	}
 
-	var values []string
-	serializedSpan, err := marshallSpan(span)
+	data, dataWriter := io.Pipe()
+	// unblocks the CSV writer when the import fails before reading the whole data
+	defer data.Close()
+	go func() {
+		dataWriter.CloseWithError(writeCSV(dataWriter, columns, records))
+	}()
+	result, err := t.questDB.Import(ImportOptions{
+		Table:       t.name,
+		Schema:      schema,
+		Timestamp:   "start_time",
+		PartitionBy: t.partitionBy,
+	}, data)
 	if err != nil {
 		return err
 	}
+	if result.Rejected > 0 {
+		return fmt.Errorf("%d of %d spans rejected by the import into %s", result.Rejected, len(records), t.name)
+	}
+	return nil
+}
 
-	values = append(values,
-		escape(span.TraceID.String()),
-		escape(int64(span.SpanID)),
-		escape(int64(span.ParentSpanID())),
-		escape(span.OperationName),
-		escape(int32(span.Flags)),
-		escape(span.StartTime.UnixNano()/1000),
-		escape(span.Duration.Microseconds()),
-		escape(span.Process.ServiceName),
-		escape(serializedSpan),
-	)
-	values = append(values, tagValues...)
-	buff := []byte(fmt.Sprintf("%s;%s\n", strings.Join(tagsKeys, ","), strings.Join(values, ",")))
-	t.Lock()
-	_, err = t.buffer.Write(buff)
+func writeCSV(w io.Writer, columns []string, records []*spanRecord) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := csvWriter.Write(record.csvValues(columns)); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func (t *Table) WriteSpan(span *model.Span, tenant string) error {
+	serializedSpan, err := t.codec.Encode(span)
 	if err != nil {
-		t.Unlock()
 		return err
 	}
+	spanKind, _ := span.GetSpanKind()
+
+	record := &spanRecord{
+		traceID:       traceid.Canonical(span.TraceID),
+		spanID:        int64(span.SpanID),
+		parentID:      int64(span.ParentSpanID()),
+		operationName: span.OperationName,
+		flags:         int32(span.Flags),
+		startTime:     span.StartTime.UnixNano() / 1000,
+		duration:      span.Duration.Microseconds(),
+		serviceName:   span.Process.ServiceName,
+		spanKind:      spanKind,
+		tenant:        tenant,
+		span:          serializedSpan,
+		tags:          make(map[string]string, len(span.Tags)),
+	}
+	// the last value of duplicated keys wins
+	for _, tag := range span.Tags {
+		record.tags[tagColumn(tag.Key)] = tag.AsString()
+	}
+
+	t.Lock()
+	t.buffer = append(t.buffer, record)
 	t.Unlock()
 	return nil
 }
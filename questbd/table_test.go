@@ -0,0 +1,188 @@
+package questbd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// legacyColumns are the columns of a traces table created before span_kind and tenant
+var legacyColumns = []string{
+	"trace_id", "span_id", "parent_id", "operation_name", "service_name", "flags", "start_time", "duration", "span",
+}
+
+func TestInsertAddsMissingBaseColumns(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		if strings.Contains(query, "table_columns") {
+			return columnsResult(legacyColumns...)
+		}
+		return fakeResult{}
+	})
+	defer fake.Close()
+	table := &Table{name: "traces", questDB: fake.client(t)}
+
+	record := &spanRecord{traceID: "1", spanKind: "server", tenant: "acme"}
+	for i := 0; i < 2; i++ {
+		if err := table.insert(nil, []*spanRecord{record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	added := fake.matching("ADD COLUMN")
+	expected := []string{
+		"ALTER TABLE traces ADD COLUMN span_kind SYMBOL",
+		"ALTER TABLE traces ADD COLUMN tenant SYMBOL",
+	}
+	if strings.Join(added, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("columns added with %q, expected %q", added, expected)
+	}
+	if inserts := fake.matching("INSERT INTO traces"); len(inserts) != 2 {
+		t.Errorf("%d inserts, expected 2", len(inserts))
+	}
+}
+
+func TestImportAddsMissingBaseColumns(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		if strings.Contains(query, "table_columns") {
+			return columnsResult(legacyColumns...)
+		}
+		return fakeResult{}
+	})
+	defer fake.Close()
+	table := &Table{name: "traces", questDB: fake.client(t)}
+
+	if err := table.importRecords([]*spanRecord{{traceID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if added := fake.matching("ADD COLUMN"); len(added) != 2 {
+		t.Errorf("columns added with %q, expected span_kind and tenant", added)
+	}
+}
+
+func TestInsertKeepsExistingBaseColumns(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		if strings.Contains(query, "table_columns") {
+			return columnsResult(baseColumns...)
+		}
+		return fakeResult{}
+	})
+	defer fake.Close()
+	table := &Table{name: "traces", questDB: fake.client(t)}
+
+	if err := table.insert(nil, []*spanRecord{{traceID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if added := fake.matching("ALTER TABLE"); len(added) != 0 {
+		t.Errorf("unexpected %q on an up to date table", added)
+	}
+}
+
+// TestAdversarialTagValues writes tags whose values broke the former line encoding of the buffer,
+// with INSERTs and with CSV imports.
+func TestAdversarialTagValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{name: "semicolon", key: "query", value: "a;b"},
+		{name: "comma", key: "query", value: "a,b"},
+		{name: "newline", key: "query", value: "first\nsecond"},
+		{name: "single quote", key: "query", value: "it's"},
+		{name: "double quote", key: "query", value: `say "hi"`},
+		{name: "separators in the key", key: "a;b,c\nd", value: "value"},
+		{name: "dash in the key", key: "x-request-id", value: "4bf92f35"},
+		{name: "empty value", key: "query", value: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			column := tagColumn(test.key)
+			if strings.ContainsAny(column, ".;,-\n '\"") {
+				t.Errorf("column %q isn't a plain identifier", column)
+			}
+			columns := append(append([]string{}, baseColumns...), column)
+			fake := newFakeQuestDB(t, func(query string) fakeResult {
+				if strings.Contains(query, "table_columns") {
+					return columnsResult(columns...)
+				}
+				return fakeResult{}
+			})
+			defer fake.Close()
+			table := &Table{name: "traces", questDB: fake.client(t)}
+
+			tagged := &model.Span{
+				TraceID:       model.NewTraceID(0, 1),
+				SpanID:        model.NewSpanID(1),
+				OperationName: "get",
+				StartTime:     time.Now(),
+				Process:       model.NewProcess("frontend", nil),
+				Tags:          []model.KeyValue{model.String(test.key, test.value)},
+			}
+			// an empty record last, the former encoding ended with an empty line for it
+			empty := &model.Span{TraceID: model.NewTraceID(0, 2), Process: model.NewProcess("", nil)}
+			for _, span := range []*model.Span{tagged, empty} {
+				if err := table.WriteSpan(span, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			records := table.swapBuffer()
+
+			if err := table.writeToStorage(records); err != nil {
+				t.Fatal(err)
+			}
+			inserts := fake.matching("INSERT INTO traces")
+			if len(inserts) != 2 {
+				t.Fatalf("inserts are %q, expected one per tag columns", inserts)
+			}
+			var taggedInsert string
+			for _, insert := range inserts {
+				if strings.Contains(insert, column) {
+					taggedInsert = insert
+				}
+			}
+			if !strings.HasSuffix(taggedInsert, ","+escape(test.value)+" )") {
+				t.Errorf("insert %q doesn't end with the escaped value %s", taggedInsert, escape(test.value))
+			}
+
+			if err := table.importRecords(records); err != nil {
+				t.Fatal(err)
+			}
+			imports := fake.received()
+			if len(imports) != 1 || len(imports[0].records) != 3 {
+				t.Fatalf("imports are %v, expected a header and 2 records", imports)
+			}
+			csvRecords := imports[0].records
+			if csvRecords[0][len(columns)-1] != column {
+				t.Fatalf("imported columns are %q, expected %s last", csvRecords[0], column)
+			}
+			if value := csvRecords[1][len(columns)-1]; value != test.value {
+				t.Errorf("imported value is %q, expected %q", value, test.value)
+			}
+			if value := csvRecords[2][len(columns)-1]; value != "" {
+				t.Errorf("imported value of the empty record is %q", value)
+			}
+		})
+	}
+}
+
+func TestStoreEmptyBuffer(t *testing.T) {
+	fake := newFakeQuestDB(t, nil)
+	defer fake.Close()
+	table := &Table{name: "traces", questDB: fake.client(t)}
+
+	if err := table.writeToStorage(table.swapBuffer()); err != nil {
+		t.Fatal(err)
+	}
+	if queries := fake.recorded(); len(queries) != 0 {
+		t.Errorf("queries %q sent for an empty buffer", queries)
+	}
+}
+
+func TestTagColumnKeepsStoredNames(t *testing.T) {
+	// columns written by previous releases must still be found
+	if column := tagColumn("http.status_code"); column != "__tag__prefix_http#status_code" {
+		t.Errorf("column is %q, expected __tag__prefix_http#status_code", column)
+	}
+}
@@ -1,9 +1,13 @@
 package questbd
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/rubenvp8510/jaeger-storages/internal/spans"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
 	"time"
 
 	"sync"
@@ -19,17 +23,46 @@ type Writer struct {
 	numSpansMtx     sync.Mutex
 	numSpans        int
 	close           chan struct{}
+	traceLevel      bool
+	tenants         tenancy.Resolver
+	pending         *pendingIndex
+	// flushSize is the number of buffered spans that triggers a flush
+	flushSize     int
+	bulkThreshold int
+	logger        *zap.Logger
+	metrics       writerMetrics
 }
 
-func NewWriter(questDB *QuestDBRest) *Writer {
+type writerMetrics struct {
+	// StoreErrors is the number of flushes triggered by the buffer size that failed, their spans are lost
+	StoreErrors metrics.Counter `metric:"writer.store-errors"`
+}
+
+// defaultFlushSize is the number of buffered spans that triggers a flush out of bulk load mode
+const defaultFlushSize = 1024
+
+func NewWriter(questDB *QuestDBRest, options Options, metricsFactory metrics.Factory, logger *zap.Logger) *Writer {
+	// the compression is validated by the factory, a nil codec stores raw spans
+	codec, _ := spans.NewCodec(options.SpanCompression)
 	writer := &Writer{
-		questDB: questDB,
+		questDB:    questDB,
+		traceLevel: options.TraceLevelMatching,
+		tenants:    options.Tenants(),
+		pending:    newPendingIndex(),
+		flushSize:  defaultFlushSize,
+		logger:     logger,
 		mainTable: &Table{
-			name:    "traces",
-			questDB: questDB,
-			buffer:  bytes.NewBuffer(nil),
+			name:        "traces",
+			questDB:     questDB,
+			partitionBy: options.PartitionBy,
+			codec:       codec,
 		},
 	}
+	metrics.MustInit(&writer.metrics, metricsFactory, nil)
+	writer.bulkThreshold = options.BulkThreshold
+	if options.BulkLoad {
+		writer.flushSize = options.BulkThreshold
+	}
 	return writer
 }
 
@@ -96,8 +129,8 @@ func (w *Writer) loop() {
 	}
 }
 
+// start creates the partition of the current block, the traces table is created by the migrations
 func (w *Writer) start() {
-	w.mainTable.CreateIfNotExist(false)
 	blockIndex := int(time.Now().UnixNano() / periodPerBlock)
 	w.partitions = make(map[int]*Table)
 	w.partitions[blockIndex] = &Table{
@@ -120,15 +153,62 @@ func (w *Writer) start() {
 }
 
 func (w *Writer) WriteSpan(span *model.Span) error {
-	if err := w.mainTable.WriteSpan(span); err != nil {
+	return w.WriteSpanContext(context.Background(), span)
+}
+
+// WriteSpanContext writes the span on behalf of its tenant when tenancy is enabled.
+func (w *Writer) WriteSpanContext(ctx context.Context, span *model.Span) error {
+	tenant, err := w.tenants.Write(ctx, span)
+	if err != nil {
 		return err
 	}
 	w.numSpansMtx.Lock()
+	defer w.numSpansMtx.Unlock()
+	if err := w.mainTable.WriteSpan(span, tenant); err != nil {
+		return err
+	}
+	w.pending.add(span, tenant)
 	w.numSpans++
-	if w.numSpans >= 1024 {
-		w.mainTable.Flush()
-		w.numSpans = 0
+	if w.numSpans >= w.flushSize {
+		records, generation := w.swap()
+		go w.storeBackground(records, generation)
 	}
-	w.numSpansMtx.Unlock()
 	return nil
 }
+
+// swap takes the buffered spans along with their pending generation, numSpansMtx must be held so no
+// span is buffered in one and indexed in the other.
+func (w *Writer) swap() ([]*spanRecord, uint64) {
+	w.numSpans = 0
+	return w.mainTable.swapBuffer(), w.pending.rotate()
+}
+
+// store writes the records with a CSV import when there are at least bulkThreshold of them, with
+// INSERTs otherwise.
+func (w *Writer) store(records []*spanRecord, generation uint64) error {
+	var err error
+	if w.bulkThreshold > 0 && len(records) >= w.bulkThreshold {
+		err = w.mainTable.importRecords(records)
+	} else {
+		err = w.mainTable.writeToStorage(records)
+	}
+	w.pending.release(generation)
+	return err
+}
+
+// storeBackground stores the records of a flush triggered by the buffer size, nobody waits for it so
+// its error is logged and counted.
+func (w *Writer) storeBackground(records []*spanRecord, generation uint64) {
+	if err := w.store(records, generation); err != nil {
+		w.metrics.StoreErrors.Inc(1)
+		w.logger.Error("Failed to store the buffered spans", zap.Int("spans", len(records)), zap.Error(err))
+	}
+}
+
+// Flush writes the buffered spans to QuestDB before returning
+func (w *Writer) Flush() error {
+	w.numSpansMtx.Lock()
+	records, generation := w.swap()
+	w.numSpansMtx.Unlock()
+	return w.store(records, generation)
+}
@@ -0,0 +1,65 @@
+package questbd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber/jaeger-lib/metrics"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+	"go.uber.org/zap"
+)
+
+func TestBackgroundStoreErrorCounted(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		if strings.HasPrefix(query, "INSERT") {
+			return fakeResult{err: "table is locked"}
+		}
+		return fakeResult{}
+	})
+	defer fake.Close()
+	metricsFactory := metricstest.NewFactory(0)
+	writer := NewWriter(fake.client(t), Options{}, metricsFactory, zap.NewNop())
+	writer.flushSize = 1
+
+	if err := writer.WriteSpanContext(context.Background(), tenantSpan(1, "")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counters, _ := metricsFactory.Snapshot()
+		if counters["writer.store-errors"] == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("store error not counted, counters are %v", counters)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFactoryCloseFlushes(t *testing.T) {
+	fake := newFakeQuestDB(t, func(query string) fakeResult {
+		if strings.Contains(query, "table_columns") {
+			return columnsResult(baseColumns...)
+		}
+		return fakeResult{}
+	})
+	defer fake.Close()
+	factory := NewFactory()
+	factory.writer = NewWriter(fake.client(t), factory.options, metrics.NullFactory, zap.NewNop())
+
+	if err := factory.writer.WriteSpan(tenantSpan(1, "")); err != nil {
+		t.Fatal(err)
+	}
+	if inserts := fake.matching("INSERT INTO traces"); len(inserts) != 0 {
+		t.Fatalf("span stored before close: %q", inserts)
+	}
+	if err := factory.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if inserts := fake.matching("INSERT INTO traces"); len(inserts) != 1 {
+		t.Errorf("inserts are %q, expected the buffered span on close", inserts)
+	}
+}
@@ -0,0 +1,192 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rubenvp8510/jaeger-storages/druid"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.uber.org/zap"
+)
+
+// SpanWriter writes spans on behalf of the tenant of ctx, implemented by questbd.Writer
+type SpanWriter interface {
+	WriteSpanContext(ctx context.Context, span *model.Span) error
+}
+
+// OffsetGetter resolves the offset of a partition for a timestamp in milliseconds or for
+// sarama.OffsetOldest and sarama.OffsetNewest, implemented by sarama.Client.
+type OffsetGetter interface {
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+type flusher interface {
+	Flush() error
+}
+
+type Options struct {
+	Topic string
+	// Offset is the first offset replayed from every partition, defaults to sarama.OffsetOldest
+	Offset int64
+	// Since replays the messages published after it instead of starting from Offset
+	Since time.Time
+}
+
+type replayMetrics struct {
+	Spans          metrics.Counter `metric:"replay.spans"`
+	DecodeErrors   metrics.Counter `metric:"replay.decode-errors"`
+	PartitionsDone metrics.Counter `metric:"replay.partitions-done"`
+}
+
+// Replayer writes the spans published to the topic by druid.SpanWriter, up to the newest offsets
+// found when it starts, so a replay always ends.
+type Replayer struct {
+	consumer  sarama.Consumer
+	offsets   OffsetGetter
+	writer    SpanWriter
+	options   Options
+	marshaler druid.DruidMarshall
+	metrics   *replayMetrics
+	logger    *zap.Logger
+}
+
+func NewReplayer(consumer sarama.Consumer, offsets OffsetGetter, writer SpanWriter, options Options, metricsFactory metrics.Factory, logger *zap.Logger) *Replayer {
+	if options.Offset == 0 && options.Since.IsZero() {
+		options.Offset = sarama.OffsetOldest
+	}
+	replayMetrics := &replayMetrics{}
+	metrics.MustInit(replayMetrics, metricsFactory, nil)
+	return &Replayer{
+		consumer: consumer,
+		offsets:  offsets,
+		writer:   writer,
+		options:  options,
+		metrics:  replayMetrics,
+		logger:   logger,
+	}
+}
+
+// offsetRange returns the first offset to replay and the offset the replay of the partition ends at.
+func (r *Replayer) offsetRange(partition int32) (int64, int64, error) {
+	end, err := r.offsets.GetOffset(r.options.Topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := r.options.Offset
+	switch {
+	case !r.options.Since.IsZero():
+		start, err = r.offsets.GetOffset(r.options.Topic, partition, r.options.Since.UnixNano()/int64(time.Millisecond))
+	case start < 0:
+		start, err = r.offsets.GetOffset(r.options.Topic, partition, start)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	// no message was published after since
+	if start < 0 {
+		start = end
+	}
+	return start, end, nil
+}
+
+// Run replays every partition of the topic concurrently and returns the first write error.
+func (r *Replayer) Run(ctx context.Context) error {
+	partitions, err := r.consumer.Partitions(r.options.Topic)
+	if err != nil {
+		return err
+	}
+
+	ranges := make(map[int32][2]int64, len(partitions))
+	for _, partition := range partitions {
+		start, end, err := r.offsetRange(partition)
+		if err != nil {
+			return err
+		}
+		if start < end {
+			ranges[partition] = [2]int64{start, end}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ranges))
+	for partition, offsets := range ranges {
+		wg.Add(1)
+		go func(partition int32, start, end int64) {
+			defer wg.Done()
+			if err := r.replayPartition(ctx, partition, start, end); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(partition, offsets[0], offsets[1])
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	if f, ok := r.writer.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (r *Replayer) replayPartition(ctx context.Context, partition int32, start, end int64) error {
+	consumer, err := r.consumer.ConsumePartition(r.options.Topic, partition, start)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+	r.logger.Info("Replaying partition", zap.Int32("partition", partition), zap.Int64("start", start), zap.Int64("end", end))
+
+	consumerErrors := consumer.Errors()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case consumerErr, ok := <-consumerErrors:
+			if ok {
+				return consumerErr
+			}
+			consumerErrors = nil
+		case message, ok := <-consumer.Messages():
+			if !ok {
+				return fmt.Errorf("partition %d consumer closed at offset %d before %d", partition, start, end)
+			}
+			start = message.Offset
+			if err := r.replayMessage(ctx, message); err != nil {
+				return err
+			}
+			if message.Offset >= end-1 {
+				r.metrics.PartitionsDone.Inc(1)
+				return nil
+			}
+		}
+	}
+}
+
+// replayMessage writes the span of a message, undecodable messages are skipped so they don't block
+// the replay.
+func (r *Replayer) replayMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
+	span, tenant, err := r.marshaler.Unmarshal(message.Value)
+	if err != nil {
+		r.metrics.DecodeErrors.Inc(1)
+		r.logger.Warn("Skipping undecodable message", zap.Int32("partition", message.Partition),
+			zap.Int64("offset", message.Offset), zap.Error(err))
+		return nil
+	}
+	if tenant != "" {
+		ctx = tenancy.WithTenant(ctx, tenant)
+	}
+	if err := r.writer.WriteSpanContext(ctx, span); err != nil {
+		return err
+	}
+	r.metrics.Spans.Inc(1)
+	return nil
+}
@@ -0,0 +1,223 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rubenvp8510/jaeger-storages/druid"
+	"github.com/rubenvp8510/jaeger-storages/tenancy"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+	"go.uber.org/zap"
+)
+
+const topic = "jaeger-spans"
+
+// offsets resolves the offsets of the partitions per time, sarama.OffsetOldest or sarama.OffsetNewest
+type offsets map[int32]map[int64]int64
+
+func (o offsets) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	offset, ok := o[partition][time]
+	if !ok {
+		return 0, errors.New("unexpected offset request")
+	}
+	return offset, nil
+}
+
+// written is a span written by the replay with its tenant
+type written struct {
+	span   *model.Span
+	tenant string
+}
+
+type recordingWriter struct {
+	mtx     sync.Mutex
+	spans   []written
+	flushes int
+	err     error
+}
+
+func (w *recordingWriter) WriteSpanContext(ctx context.Context, span *model.Span) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.spans = append(w.spans, written{span: span, tenant: tenancy.GetTenant(ctx)})
+	return nil
+}
+
+func (w *recordingWriter) Flush() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.flushes++
+	return nil
+}
+
+func message(t *testing.T, id uint64, tenant string) *sarama.ConsumerMessage {
+	marshaler := druid.DruidMarshall{}
+	value, err := marshaler.Marshal(&model.Span{
+		TraceID:       model.NewTraceID(0, id),
+		SpanID:        model.NewSpanID(id),
+		OperationName: "get",
+		StartTime:     time.Now(),
+		Process:       model.NewProcess("frontend", nil),
+	}, tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sarama.ConsumerMessage{Value: value}
+}
+
+func newConsumer(t *testing.T, partitions ...int32) *mocks.Consumer {
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	consumer.SetTopicMetadata(map[string][]int32{topic: partitions})
+	return consumer
+}
+
+func TestReplayOldest(t *testing.T) {
+	consumer := newConsumer(t, 0, 1)
+	for _, partition := range []int32{0, 1} {
+		partitionConsumer := consumer.ExpectConsumePartition(topic, partition, 1)
+		// the mock numbers the messages from offset 1, the replay stops before the newest offset 4
+		for i := uint64(1); i <= 3; i++ {
+			partitionConsumer.YieldMessage(message(t, uint64(partition)*10+i, ""))
+		}
+	}
+	newest := map[int64]int64{sarama.OffsetOldest: 1, sarama.OffsetNewest: 4}
+	writer := &recordingWriter{}
+	metricsFactory := metricstest.NewFactory(0)
+	replayer := NewReplayer(consumer, offsets{0: newest, 1: newest}, writer, Options{Topic: topic}, metricsFactory, zap.NewNop())
+
+	if err := replayer.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.spans) != 6 {
+		t.Errorf("%d spans replayed, expected 6", len(writer.spans))
+	}
+	if writer.flushes != 1 {
+		t.Errorf("writer flushed %d times, expected once at the end", writer.flushes)
+	}
+	metricsFactory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "replay.spans", Value: 6},
+		metricstest.ExpectedMetric{Name: "replay.partitions-done", Value: 2},
+	)
+}
+
+func TestReplaySince(t *testing.T) {
+	since := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	millis := since.UnixNano() / int64(time.Millisecond)
+	consumer := newConsumer(t, 0, 1)
+	partitionConsumer := consumer.ExpectConsumePartition(topic, 0, 2)
+	partitionConsumer.YieldMessage(message(t, 1, ""))
+	partitionConsumer.YieldMessage(message(t, 2, ""))
+	writer := &recordingWriter{}
+	replayer := NewReplayer(consumer, offsets{
+		0: {millis: 2, sarama.OffsetNewest: 3},
+		// nothing was published to partition 1 after since, it isn't consumed
+		1: {millis: -1, sarama.OffsetNewest: 7},
+	}, writer, Options{Topic: topic, Since: since}, metricstest.NewFactory(0), zap.NewNop())
+
+	if err := replayer.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.spans) != 2 {
+		t.Errorf("%d spans replayed, expected 2", len(writer.spans))
+	}
+}
+
+func TestReplayOffset(t *testing.T) {
+	consumer := newConsumer(t, 0, 1)
+	partitionConsumer := consumer.ExpectConsumePartition(topic, 0, 1)
+	for i := uint64(1); i <= 3; i++ {
+		partitionConsumer.YieldMessage(message(t, i, ""))
+	}
+	writer := &recordingWriter{}
+	replayer := NewReplayer(consumer, offsets{
+		0: {sarama.OffsetNewest: 4},
+		// the partition ends at the offset, it isn't consumed
+		1: {sarama.OffsetNewest: 1},
+	}, writer, Options{Topic: topic, Offset: 1}, metricstest.NewFactory(0), zap.NewNop())
+
+	if err := replayer.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.spans) != 3 {
+		t.Errorf("%d spans replayed, expected 3", len(writer.spans))
+	}
+}
+
+func TestReplayUndecodableMessages(t *testing.T) {
+	consumer := newConsumer(t, 0)
+	partitionConsumer := consumer.ExpectConsumePartition(topic, 0, 1)
+	partitionConsumer.YieldMessage(message(t, 1, ""))
+	partitionConsumer.YieldMessage(&sarama.ConsumerMessage{Value: []byte("{not a span")})
+	partitionConsumer.YieldMessage(message(t, 3, ""))
+	writer := &recordingWriter{}
+	metricsFactory := metricstest.NewFactory(0)
+	replayer := NewReplayer(consumer, offsets{0: {sarama.OffsetOldest: 1, sarama.OffsetNewest: 4}},
+		writer, Options{Topic: topic}, metricsFactory, zap.NewNop())
+
+	if err := replayer.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.spans) != 2 || writer.spans[0].span.SpanID != 1 || writer.spans[1].span.SpanID != 3 {
+		t.Errorf("spans replayed are %v, expected the decodable ones", writer.spans)
+	}
+	metricsFactory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "replay.spans", Value: 2},
+		metricstest.ExpectedMetric{Name: "replay.decode-errors", Value: 1},
+	)
+}
+
+func TestReplayTenants(t *testing.T) {
+	consumer := newConsumer(t, 0)
+	partitionConsumer := consumer.ExpectConsumePartition(topic, 0, 1)
+	partitionConsumer.YieldMessage(message(t, 1, "acme"))
+	partitionConsumer.YieldMessage(message(t, 2, "umbrella"))
+	partitionConsumer.YieldMessage(message(t, 3, ""))
+	writer := &recordingWriter{}
+	replayer := NewReplayer(consumer, offsets{0: {sarama.OffsetOldest: 1, sarama.OffsetNewest: 4}},
+		writer, Options{Topic: topic}, metricstest.NewFactory(0), zap.NewNop())
+
+	if err := replayer.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tenants := make([]string, len(writer.spans))
+	for i, span := range writer.spans {
+		tenants[i] = span.tenant
+	}
+	if len(tenants) != 3 || tenants[0] != "acme" || tenants[1] != "umbrella" || tenants[2] != "" {
+		t.Errorf("spans replayed on behalf of %q, expected the tenants of the messages", tenants)
+	}
+}
+
+func TestReplayWriteError(t *testing.T) {
+	consumer := newConsumer(t, 0)
+	partitionConsumer := consumer.ExpectConsumePartition(topic, 0, 1)
+	partitionConsumer.YieldMessage(message(t, 1, ""))
+	writer := &recordingWriter{err: errors.New("storage unavailable")}
+	replayer := NewReplayer(consumer, offsets{0: {sarama.OffsetOldest: 1, sarama.OffsetNewest: 4}},
+		writer, Options{Topic: topic}, metricstest.NewFactory(0), zap.NewNop())
+
+	if err := replayer.Run(context.Background()); err != writer.err {
+		t.Errorf("replay returned %v, expected the write error", err)
+	}
+	if writer.flushes != 0 {
+		t.Error("writer flushed after a failed replay")
+	}
+}
@@ -0,0 +1,106 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// Header is the HTTP header carrying the tenant of a request
+	Header = "x-tenant"
+	// ProcessTag is the process tag carrying the tenant of a span, only trusted when the pipeline
+	// sets it, see Resolver.TrustProcessTag.
+	ProcessTag = "tenant"
+)
+
+var (
+	// ErrMissingTenant is returned by the storages when tenancy is enabled and no tenant is found.
+	ErrMissingTenant = errors.New("missing tenant")
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// GetTenant returns the tenant carried by ctx, empty if there is none.
+func GetTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// FromSpan returns the tenant process tag of span, empty if there is none.
+func FromSpan(span *model.Span) string {
+	if span.Process == nil {
+		return ""
+	}
+	if tag, ok := model.KeyValues(span.Process.Tags).FindByKey(ProcessTag); ok {
+		return tag.AsString()
+	}
+	return ""
+}
+
+// Resolver decides the tenant of the reads and writes of a storage. The tenant of the context, set
+// by Middleware on the requests of the servers embedding the storage or by WithTenant, comes first.
+type Resolver struct {
+	// Enabled isolates the tenants, the tenant is empty when it is disabled
+	Enabled bool
+	// Default is the tenant of requests without one in their context, for instances dedicated to a
+	// tenant. Requests without tenant fail when it is empty.
+	Default string
+	// TrustProcessTag takes the tenant of spans written without one in their context from their
+	// tenant process tag. Clients can set any process tag, so it must only be enabled when the
+	// pipeline sets the tag, e.g. an agent per tenant started with --jaeger.tags=tenant=<tenant>.
+	TrustProcessTag bool
+}
+
+// Read returns the tenant a read is restricted to, ErrMissingTenant when there is none so reads
+// never cross tenants.
+func (r Resolver) Read(ctx context.Context) (string, error) {
+	if !r.Enabled {
+		return "", nil
+	}
+	if tenant := GetTenant(ctx); tenant != "" {
+		return tenant, nil
+	}
+	if r.Default != "" {
+		return r.Default, nil
+	}
+	return "", ErrMissingTenant
+}
+
+// Write returns the tenant a span is written on behalf of, ErrMissingTenant when there is none.
+func (r Resolver) Write(ctx context.Context, span *model.Span) (string, error) {
+	if !r.Enabled {
+		return "", nil
+	}
+	if tenant := GetTenant(ctx); tenant != "" {
+		return tenant, nil
+	}
+	if r.TrustProcessTag {
+		if tenant := FromSpan(span); tenant != "" {
+			return tenant, nil
+		}
+	}
+	if r.Default != "" {
+		return r.Default, nil
+	}
+	return "", ErrMissingTenant
+}
+
+// Middleware propagates the tenant header of the incoming requests to their context. Servers
+// embedding a storage with tenancy enabled wrap their handlers with it, behind a proxy that
+// authenticates the tenant and sets the header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenant := r.Header.Get(Header); tenant != "" {
+			r = r.WithContext(WithTenant(r.Context(), tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
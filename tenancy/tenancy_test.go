@@ -0,0 +1,89 @@
+package tenancy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func spanOf(tenant string) *model.Span {
+	span := &model.Span{Process: model.NewProcess("frontend", nil)}
+	if tenant != "" {
+		span.Process.Tags = []model.KeyValue{model.String(ProcessTag, tenant)}
+	}
+	return span
+}
+
+func TestResolverRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver Resolver
+		ctx      context.Context
+		expected string
+		err      error
+	}{
+		{name: "disabled", resolver: Resolver{}, ctx: WithTenant(context.Background(), "acme")},
+		{name: "context", resolver: Resolver{Enabled: true}, ctx: WithTenant(context.Background(), "acme"), expected: "acme"},
+		{name: "missing", resolver: Resolver{Enabled: true}, ctx: context.Background(), err: ErrMissingTenant},
+		{name: "default", resolver: Resolver{Enabled: true, Default: "acme"}, ctx: context.Background(), expected: "acme"},
+		{name: "context before default", resolver: Resolver{Enabled: true, Default: "acme"}, ctx: WithTenant(context.Background(), "umbrella"), expected: "umbrella"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tenant, err := test.resolver.Read(test.ctx)
+			if tenant != test.expected || err != test.err {
+				t.Errorf("tenant is %q, %v, expected %q, %v", tenant, err, test.expected, test.err)
+			}
+		})
+	}
+}
+
+func TestResolverWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver Resolver
+		ctx      context.Context
+		span     *model.Span
+		expected string
+		err      error
+	}{
+		{name: "disabled", resolver: Resolver{}, ctx: context.Background(), span: spanOf("acme")},
+		{name: "context", resolver: Resolver{Enabled: true}, ctx: WithTenant(context.Background(), "acme"), span: spanOf(""), expected: "acme"},
+		{name: "context before process tag", resolver: Resolver{Enabled: true, TrustProcessTag: true}, ctx: WithTenant(context.Background(), "acme"), span: spanOf("umbrella"), expected: "acme"},
+		{name: "untrusted process tag", resolver: Resolver{Enabled: true}, ctx: context.Background(), span: spanOf("acme"), err: ErrMissingTenant},
+		{name: "trusted process tag", resolver: Resolver{Enabled: true, TrustProcessTag: true}, ctx: context.Background(), span: spanOf("acme"), expected: "acme"},
+		{name: "process tag before default", resolver: Resolver{Enabled: true, TrustProcessTag: true, Default: "umbrella"}, ctx: context.Background(), span: spanOf("acme"), expected: "acme"},
+		{name: "default", resolver: Resolver{Enabled: true, TrustProcessTag: true, Default: "umbrella"}, ctx: context.Background(), span: spanOf(""), expected: "umbrella"},
+		{name: "missing", resolver: Resolver{Enabled: true, TrustProcessTag: true}, ctx: context.Background(), span: &model.Span{}, err: ErrMissingTenant},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tenant, err := test.resolver.Write(test.ctx, test.span)
+			if tenant != test.expected || err != test.err {
+				t.Errorf("tenant is %q, %v, expected %q, %v", tenant, err, test.expected, test.err)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var tenant string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = GetTenant(r.Context())
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/services", nil)
+	request.Header.Set(Header, "acme")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if tenant != "acme" {
+		t.Errorf("tenant is %q, expected acme", tenant)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/services", nil))
+	if tenant != "" {
+		t.Errorf("tenant is %q without header", tenant)
+	}
+}
@@ -0,0 +1,42 @@
+// Package traceid defines the format trace IDs are stored in.
+//
+// Trace IDs used to be stored as model.TraceID.String(), which drops the high part of 64-bit trace
+// IDs, leaving 16 hex characters. They are now stored as 32 zero-padded lowercase hex characters, as
+// most clients print them. Lookups match both forms, so
+// spans stored before the change are still found. To rewrite the old spans in the canonical form:
+//
+//   - druid: submit druid.TraceIDReindexSpec, printed by druid-spec reindex-trace-ids, to the overlord
+//     /druid/indexer/v1/task endpoint. It reindexes the spans datasource in place, padding the traceId
+//     dimension.
+//   - questdb: run the migrate command to export the traces to a file, drop the traces table and
+//     import the file back, the writer stores the canonical form.
+package traceid
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// Length is the number of characters of a canonical trace ID
+const Length = 32
+
+// Canonical returns the storage format of a trace ID, 32 zero-padded lowercase hex characters.
+func Canonical(traceID model.TraceID) string {
+	return fmt.Sprintf("%016x%016x", traceID.High, traceID.Low)
+}
+
+// Forms returns every form a trace ID may be stored in, the canonical form first.
+func Forms(traceID model.TraceID) []string {
+	canonical := Canonical(traceID)
+	if legacy := traceID.String(); legacy != canonical {
+		return []string{canonical, legacy}
+	}
+	return []string{canonical}
+}
+
+// Parse accepts a trace ID in any equivalent form: padded or not, upper or lower case.
+func Parse(traceID string) (model.TraceID, error) {
+	return model.TraceIDFromString(strings.ToLower(strings.TrimSpace(traceID)))
+}
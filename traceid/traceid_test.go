@@ -0,0 +1,103 @@
+package traceid
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestCanonicalAndForms(t *testing.T) {
+	tests := []struct {
+		name      string
+		traceID   model.TraceID
+		canonical string
+		forms     []string
+	}{
+		{
+			name:      "64-bit",
+			traceID:   model.NewTraceID(0, 0xabc),
+			canonical: "00000000000000000000000000000abc",
+			forms:     []string{"00000000000000000000000000000abc", "0000000000000abc"},
+		},
+		{
+			name:      "64-bit with every digit",
+			traceID:   model.NewTraceID(0, 0x1234567890abcdef),
+			canonical: "00000000000000001234567890abcdef",
+			forms:     []string{"00000000000000001234567890abcdef", "1234567890abcdef"},
+		},
+		{
+			name:      "128-bit with a small high part",
+			traceID:   model.NewTraceID(0x1, 0xa),
+			canonical: "0000000000000001000000000000000a",
+			forms:     []string{"0000000000000001000000000000000a"},
+		},
+		{
+			name:      "128-bit",
+			traceID:   model.NewTraceID(0x1234567890abcdef, 0xfedcba0987654321),
+			canonical: "1234567890abcdeffedcba0987654321",
+			forms:     []string{"1234567890abcdeffedcba0987654321"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if canonical := Canonical(test.traceID); canonical != test.canonical || len(canonical) != Length {
+				t.Errorf("canonical form is %q, expected %q", canonical, test.canonical)
+			}
+			if forms := Forms(test.traceID); !reflect.DeepEqual(forms, test.forms) {
+				t.Errorf("forms are %q, expected %q", forms, test.forms)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected model.TraceID
+		invalid  bool
+	}{
+		{name: "padded 64-bit", input: "00000000000000000000000000000abc", expected: model.NewTraceID(0, 0xabc)},
+		{name: "unpadded 64-bit", input: "abc", expected: model.NewTraceID(0, 0xabc)},
+		{name: "padded low part", input: "0000000000000abc", expected: model.NewTraceID(0, 0xabc)},
+		{name: "uppercase", input: "ABC", expected: model.NewTraceID(0, 0xabc)},
+		{name: "surrounding spaces", input: " abc\n", expected: model.NewTraceID(0, 0xabc)},
+		{name: "128-bit", input: "1234567890abcdeffedcba0987654321", expected: model.NewTraceID(0x1234567890abcdef, 0xfedcba0987654321)},
+		{name: "128-bit uppercase", input: "1234567890ABCDEFFEDCBA0987654321", expected: model.NewTraceID(0x1234567890abcdef, 0xfedcba0987654321)},
+		{name: "unpadded 128-bit", input: "1000000000000000a", expected: model.NewTraceID(0x1, 0xa)},
+		{name: "empty", input: "", invalid: true},
+		{name: "not hex", input: "xyz", invalid: true},
+		{name: "too long", input: "1234567890abcdeffedcba09876543210", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			traceID, err := Parse(test.input)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("%q parsed as %v", test.input, traceID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if traceID != test.expected {
+				t.Errorf("%q parsed as %v, expected %v", test.input, traceID, test.expected)
+			}
+		})
+	}
+}
+
+func TestFormsParseBack(t *testing.T) {
+	traceID := model.NewTraceID(0x1, 0xa)
+	for _, form := range Forms(traceID) {
+		parsed, err := Parse(form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != traceID {
+			t.Errorf("%q parsed as %v, expected %v", form, parsed, traceID)
+		}
+	}
+}